@@ -1,20 +1,95 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"receipt-processor/internal/handlers"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
 	"receipt-processor/internal/store"
+	"runtime"
+	"syscall"
+	"time"
 )
 
-func setupServer() http.Handler {
-	store := store.NewStore()
-	handler := handlers.NewReceiptHandler(store)
+var (
+	rulesPath = flag.String("rules", os.Getenv("RULES_CONFIG"),
+		"path to a YAML or JSON rules config file (default: built-in rules, env RULES_CONFIG)")
+	consistencyMode = flag.String("consistency", envOr("CONSISTENCY_MODE", "off"),
+		"what to do when item prices don't add up to the total: off, reject or flag (env CONSISTENCY_MODE)")
+	consistencyTolerance = flag.String("consistency-tolerance", envOr("CONSISTENCY_TOLERANCE", "0.00"),
+		"how far the total may differ from the item prices, e.g. 0.50 (env CONSISTENCY_TOLERANCE)")
+	duplicatePolicy = flag.String("duplicates", envOr("DUPLICATE_POLICY", "flag"),
+		"what to do with a receipt that was already submitted: reject, existing or flag (env DUPLICATE_POLICY)")
+	idempotencyTTL = flag.String("idempotency-ttl", envOr("IDEMPOTENCY_TTL", store.DefaultIdempotencyTTL.String()),
+		"how long responses are kept for retries with the same Idempotency-Key, e.g. 1h (env IDEMPOTENCY_TTL)")
+	batchWorkers = flag.Int("batch-workers", runtime.GOMAXPROCS(0),
+		"how many receipts of a POST /receipts/batch are validated and scored at once")
+	asyncWorkers = flag.Int("async-workers", runtime.GOMAXPROCS(0),
+		"how many POST /receipts/process?async=true submissions are processed at once")
+	webhookAttempts = flag.Int("webhook-attempts", service.DefaultRetryPolicy.Attempts,
+		"how many times a webhook delivery is tried before it is dead-lettered")
+	webhookBackoff = flag.Duration("webhook-backoff", service.DefaultRetryPolicy.Backoff,
+		"how long to wait before retrying a failed webhook delivery, doubled for each later retry")
+	webhookAllowPrivate = flag.Bool("webhook-allow-private", false,
+		"let webhooks reach private, loopback and link-local addresses")
+	eventBuffer = flag.Int("event-buffer", service.DefaultEventBuffer,
+		"how many recent events GET /events keeps for clients resuming with Last-Event-ID")
+	adminToken = flag.String("admin-token", os.Getenv("ADMIN_TOKEN"),
+		"bearer token the /admin endpoints require; they are disabled without one (env ADMIN_TOKEN)")
+	dataLog = flag.String("data-log", os.Getenv("DATA_LOG"),
+		"path to a log file that persists receipts across restarts (default: in memory only, env DATA_LOG)")
+	sqlitePath = flag.String("sqlite", os.Getenv("SQLITE_PATH"),
+		"path to a SQLite database to keep receipts in, instead of -data-log (env SQLITE_PATH)")
+)
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// setupServer routes every endpoint. The /admin endpoints require adminToken
+// as a bearer token, and are closed if it is empty.
+func setupServer(scorer *service.Scorer, store store.Store, webhooks *service.Webhooks, adminToken string, opts ...handlers.Option) http.Handler {
+	opts = append([]handlers.Option{handlers.WithScorer(scorer), handlers.WithWebhooks(webhooks)}, opts...)
+	handler := handlers.NewReceiptHandler(store, opts...)
+	admin := handlers.NewAdminHandler(scorer, store, handlers.WithReceiptEvents(handler))
+	hooks := handlers.NewWebhookHandler(webhooks)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/receipts/process", handler.ProcessReceipt).Methods("POST")
+	router.HandleFunc("/receipts/simulate", handler.SimulateReceipt).Methods("POST")
+	router.HandleFunc("/receipts/batch", handler.ProcessBatch).Methods("POST")
+	router.HandleFunc("/receipts/import", handler.ImportReceipts).Methods("POST")
+	router.HandleFunc("/receipts", handler.ListReceipts).Methods("GET")
+	router.HandleFunc("/receipts/{id}", handler.GetReceipt).Methods("GET")
+	router.HandleFunc("/receipts/{id}", handler.AmendReceipt).Methods("PUT")
+	router.HandleFunc("/receipts/{id}", handler.DeleteReceipt).Methods("DELETE")
+	router.HandleFunc("/receipts/{id}/history", handler.GetReceiptHistory).Methods("GET")
 	router.HandleFunc("/receipts/{id}/points", handler.GetPoints).Methods("GET")
+	router.HandleFunc("/receipts/{id}/points/breakdown", handler.GetPointsBreakdown).Methods("GET")
+	router.HandleFunc("/jobs/{id}", handler.GetJob).Methods("GET")
+	router.HandleFunc("/events", handler.StreamEvents).Methods("GET")
+	router.HandleFunc("/webhooks", hooks.CreateWebhook).Methods("POST")
+	router.HandleFunc("/webhooks", hooks.ListWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters", hooks.ListDeadLetters).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters/{id}/redeliver", hooks.RedeliverDeadLetter).Methods("POST")
+	router.HandleFunc("/webhooks/{id}", hooks.GetWebhook).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", hooks.DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", hooks.GetWebhookDeliveries).Methods("GET")
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(handlers.RequireAdminToken(adminToken))
+	adminRouter.HandleFunc("/rules", admin.GetRules).Methods("GET")
+	adminRouter.HandleFunc("/rules/reload", admin.ReloadRules).Methods("POST")
+	adminRouter.HandleFunc("/receipts/rescore", admin.RescoreReceipts).Methods("POST")
+	adminRouter.HandleFunc("/audit", admin.GetAuditLog).Methods("GET")
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}).Methods("GET")
@@ -22,8 +97,111 @@ func setupServer() http.Handler {
 	return router
 }
 
+// loadRegistry builds the scoring rules from the config file at path, or the
+// built-in defaults when path is empty, followed by the rules added with
+// service.Register.
+func loadRegistry(path string) (*service.Registry, error) {
+	config := service.DefaultConfig()
+	if path != "" {
+		var err error
+		if config, err = service.LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	return service.NewRegistryFromConfig(config, service.RegisteredRules()...)
+}
+
+// reloadOnSignal reloads the scoring rules each time the process receives
+// SIGHUP. A failed reload is logged and the previous rules stay active.
+func reloadOnSignal(scorer *service.Scorer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if _, err := scorer.Reload(); err != nil {
+			log.Printf("Rule reload failed, keeping previous rules: %v", err)
+			continue
+		}
+		log.Printf("Rules reloaded from %q", *rulesPath)
+	}
+}
+
+// loadValidator builds the receipt validator from the consistency flags.
+func loadValidator(mode, tolerance string) (service.Validator, error) {
+	consistency, err := service.ParseConsistencyMode(mode)
+	if err != nil {
+		return service.Validator{}, err
+	}
+	amount, err := models.ParseMoney(tolerance)
+	if err != nil {
+		return service.Validator{}, fmt.Errorf("invalid consistency tolerance: %w", err)
+	}
+	return service.Validator{Consistency: consistency, Tolerance: amount}, nil
+}
+
+// openStore opens the receipt log or SQLite database that is configured, or
+// an in-memory store if neither is.
+func openStore(logPath, sqlitePath string) (store.Store, error) {
+	switch {
+	case logPath != "" && sqlitePath != "":
+		return nil, fmt.Errorf("-data-log and -sqlite are mutually exclusive")
+	case logPath != "":
+		return store.OpenFileStore(logPath)
+	case sqlitePath != "":
+		return store.OpenSQLiteStore(sqlitePath)
+	}
+	return store.NewStore(), nil
+}
+
 func main() {
-	router := setupServer()
+	flag.Parse()
+	run()
+}
+
+// run starts the server with the flags as parsed. It is split from main so
+// tests can start the server without parsing the command line, which the
+// testing package does itself.
+func run() {
+	registry, err := loadRegistry(*rulesPath)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	validator, err := loadValidator(*consistencyMode, *consistencyTolerance)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	duplicates, err := handlers.ParseDuplicatePolicy(*duplicatePolicy)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	ttl, err := time.ParseDuration(*idempotencyTTL)
+	if err != nil || ttl <= 0 {
+		log.Fatalf("Refusing to start: invalid idempotency TTL %q: want a positive duration like 24h", *idempotencyTTL)
+	}
+
+	receipts, err := openStore(*dataLog, *sqlitePath)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	scorer := service.NewScorer(registry, func() (*service.Registry, error) {
+		return loadRegistry(*rulesPath)
+	})
+	go reloadOnSignal(scorer)
+
+	if *webhookAttempts < 1 || *webhookBackoff <= 0 {
+		log.Fatalf("Refusing to start: -webhook-attempts and -webhook-backoff must be positive")
+	}
+	var webhookOpts []service.WebhookOption
+	if *webhookAllowPrivate {
+		webhookOpts = append(webhookOpts, service.AllowPrivateWebhooks())
+	}
+	webhooks := service.NewWebhooks(nil, service.RetryPolicy{Attempts: *webhookAttempts, Backoff: *webhookBackoff}, webhookOpts...)
+
+	router := setupServer(scorer, receipts, webhooks, *adminToken, handlers.WithValidator(validator), handlers.WithDuplicatePolicy(duplicates),
+		handlers.WithIdempotencyTTL(ttl), handlers.WithBatchWorkers(*batchWorkers),
+		handlers.WithAsyncWorkers(*asyncWorkers), handlers.WithEventStream(service.NewEventStream(*eventBuffer)))
 	log.Printf("Server starting on port 8080...")
 	log.Fatal(http.ListenAndServe(":8080", router))
 }
@@ -5,12 +5,16 @@ import (
     "encoding/json"
     "net/http"
     "net/http/httptest"
+    "os"
+    "path/filepath"
     "testing"
     "receipt-processor/internal/models"
+    "receipt-processor/internal/service"
+    "receipt-processor/internal/store"
 )
 
 func TestSetupServer(t *testing.T) {
-    srv := setupServer()
+    srv := setupServer(service.NewScorer(service.DefaultRegistry, nil), store.NewStore(), service.NewWebhooks(nil, service.DefaultRetryPolicy), "")
     
     // Create test server
     testServer := httptest.NewServer(srv)
@@ -130,9 +134,178 @@ func TestSetupServer(t *testing.T) {
     })
 }
 
+func TestAdminRoutesRequireToken(t *testing.T) {
+    newServer := func(token string) *httptest.Server {
+        srv := httptest.NewServer(setupServer(service.NewScorer(service.DefaultRegistry, nil), store.NewStore(), service.NewWebhooks(nil, service.DefaultRetryPolicy), token))
+        t.Cleanup(srv.Close)
+        return srv
+    }
+    request := func(srv *httptest.Server, method, path, token string) int {
+        req, _ := http.NewRequest(method, srv.URL+path, nil)
+        if token != "" {
+            req.Header.Set("Authorization", "Bearer "+token)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatalf("Could not send %s request: %v", method, err)
+        }
+        resp.Body.Close()
+        return resp.StatusCode
+    }
+
+    secured, closed := newServer("s3cret"), newServer("")
+    for _, route := range []struct{ method, path string }{
+        {"GET", "/admin/rules"},
+        {"POST", "/admin/rules/reload"},
+        {"POST", "/admin/receipts/rescore"},
+        {"GET", "/admin/audit"},
+    } {
+        if code := request(secured, route.method, route.path, ""); code != http.StatusUnauthorized {
+            t.Errorf("%s %s without a token: expected 401; got %v", route.method, route.path, code)
+        }
+        if code := request(closed, route.method, route.path, "s3cret"); code != http.StatusForbidden {
+            t.Errorf("%s %s with no token configured: expected 403; got %v", route.method, route.path, code)
+        }
+    }
+    if code := request(secured, "GET", "/admin/rules", "s3cret"); code != http.StatusOK {
+        t.Errorf("GET /admin/rules with the token: expected 200; got %v", code)
+    }
+}
+
+func TestLoadRegistry(t *testing.T) {
+    t.Run("Defaults Without Config", func(t *testing.T) {
+        registry, err := loadRegistry("")
+        if err != nil {
+            t.Fatalf("loadRegistry() error = %v", err)
+        }
+        want := len(service.BuiltinRules()) + len(service.RegisteredRules())
+        if len(registry.Rules()) != want {
+            t.Errorf("Expected %d rules; got %d", want, len(registry.Rules()))
+        }
+    })
+
+    t.Run("Invalid Config", func(t *testing.T) {
+        path := filepath.Join(t.TempDir(), "rules.yaml")
+        if err := os.WriteFile(path, []byte("rules:\n  oddDay:\n    points: -1\n"), 0o644); err != nil {
+            t.Fatalf("Failed to write config: %v", err)
+        }
+        if _, err := loadRegistry(path); err == nil {
+            t.Error("Expected an error for an invalid config")
+        }
+    })
+}
+
+func TestLoadValidator(t *testing.T) {
+    validator, err := loadValidator("flag", "0.50")
+    if err != nil {
+        t.Fatalf("loadValidator() error = %v", err)
+    }
+    if validator.Consistency != service.ConsistencyFlag || validator.Tolerance != 50 {
+        t.Errorf("Unexpected validator %+v", validator)
+    }
+
+    if _, err := loadValidator("warn", "0.00"); err == nil {
+        t.Error("Expected an error for an unknown mode")
+    }
+    if _, err := loadValidator("reject", "50 cents"); err == nil {
+        t.Error("Expected an error for an invalid tolerance")
+    }
+}
+
+func TestOpenStore(t *testing.T) {
+    if _, ok := mustOpenStore(t, "").(*store.ReceiptStore); !ok {
+        t.Error("Expected an in-memory store when no data log is set")
+    }
+
+    path := filepath.Join(t.TempDir(), "receipts.log")
+    first := mustOpenStore(t, path)
+    first.Save(store.Record{ID: "persisted", Score: models.Score{Points: 12}})
+    first.(*store.FileStore).Close()
+
+    record, err := mustOpenStore(t, path).Get("persisted")
+    if err != nil || record.Score.Points != 12 {
+        t.Errorf("Expected the receipt to survive a reopen, got %+v, %v", record, err)
+    }
+
+    if _, err := openStore(filepath.Join(t.TempDir(), "missing", "receipts.log"), ""); err == nil {
+        t.Error("Expected an error for an unusable data log path")
+    }
+
+    database, err := openStore("", filepath.Join(t.TempDir(), "receipts.db"))
+    if err != nil {
+        t.Fatalf("openStore() with -sqlite error = %v", err)
+    }
+    if _, ok := database.(*store.SQLiteStore); !ok {
+        t.Errorf("Expected a SQLite store, got %T", database)
+    }
+    database.(*store.SQLiteStore).Close()
+
+    if _, err := openStore(path, filepath.Join(t.TempDir(), "receipts.db")); err == nil {
+        t.Error("Expected an error when both a data log and a database are set")
+    }
+}
+
+func mustOpenStore(t *testing.T, path string) store.Store {
+    t.Helper()
+    s, err := openStore(path, "")
+    if err != nil {
+        t.Fatalf("openStore(%q) error = %v", path, err)
+    }
+    return s
+}
+
+// partnerRule awards 1000 points to receipts from one retailer only, so
+// registering it leaves the other tests' scores alone.
+type partnerRule struct{}
+
+func (partnerRule) Name() string        { return "partner-bonus" }
+func (partnerRule) Description() string { return "1000 points for receipts from Partner Test" }
+func (partnerRule) Evaluate(receipt models.Receipt) service.RuleResult {
+    if receipt.Retailer != "Partner Test" {
+        return service.RuleResult{}
+    }
+    return service.RuleResult{Points: 1000}
+}
+
+func TestRegisteredRuleScores(t *testing.T) {
+    if err := service.Register(partnerRule{}); err != nil {
+        t.Fatalf("Register() error = %v", err)
+    }
+    registry, err := loadRegistry("")
+    if err != nil {
+        t.Fatalf("loadRegistry() error = %v", err)
+    }
+    testServer := httptest.NewServer(setupServer(service.NewScorer(registry, nil), store.NewStore(), service.NewWebhooks(nil, service.DefaultRetryPolicy), ""))
+    defer testServer.Close()
+
+    receipt := `{"retailer": "Partner Test", "purchaseDate": "2022-01-02", "purchaseTime": "08:00",
+        "items": [{"shortDescription": "Pepsi", "price": "1.25"}], "total": "1.25"}`
+    resp, err := http.Post(testServer.URL+"/receipts/process", "application/json", bytes.NewBufferString(receipt))
+    if err != nil {
+        t.Fatalf("Could not send POST request: %v", err)
+    }
+    var processed models.ReceiptResponse
+    json.NewDecoder(resp.Body).Decode(&processed)
+    resp.Body.Close()
+
+    resp, err = http.Get(testServer.URL + "/receipts/" + processed.ID + "/points")
+    if err != nil {
+        t.Fatalf("Could not send GET request: %v", err)
+    }
+    defer resp.Body.Close()
+    var points models.PointsResponse
+    json.NewDecoder(resp.Body).Decode(&points)
+    if points.Points < 1000 {
+        t.Errorf("Expected the registered rule's 1000 points; got %d", points.Points)
+    }
+    if points.RuleVersion != registry.Version() {
+        t.Errorf("Expected rule version %+v; got %+v", registry.Version(), points.RuleVersion)
+    }
+}
+
 func TestMain(m *testing.M) {
     go func() {
-        main()
+        run()
     }()
     m.Run()
 }
\ No newline at end of file
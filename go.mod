@@ -3,6 +3,22 @@ module receipt-processor
 go 1.21
 
 require (
-    github.com/google/uuid v1.5.0
-    github.com/gorilla/mux v1.8.1
-)
\ No newline at end of file
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+)
+
+require (
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.34.5
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+)
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"strings"
+)
+
+// AdminHandler serves operational endpoints that manage the running server.
+type AdminHandler struct {
+	scorer *service.Scorer
+	store  store.Store
+	// receipts announces saved re-scores, if set.
+	receipts *ReceiptHandler
+}
+
+// AdminOption configures an AdminHandler.
+type AdminOption func(*AdminHandler)
+
+// WithReceiptEvents announces saved re-scores that change a receipt's points
+// or rule version the way receipts announces stored receipts: a
+// receipt.scored event to its webhooks and a receipt.rescored event on its
+// event stream.
+func WithReceiptEvents(receipts *ReceiptHandler) AdminOption {
+	return func(h *AdminHandler) {
+		h.receipts = receipts
+	}
+}
+
+func NewAdminHandler(scorer *service.Scorer, store store.Store, opts ...AdminOption) *AdminHandler {
+	h := &AdminHandler{scorer: scorer, store: store}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// RequireAdminToken returns middleware that only lets through requests
+// carrying token as a bearer token in the Authorization header. An empty
+// token turns every request away, so admin endpoints stay closed until one is
+// configured.
+func RequireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				writeProblem(w, models.Problem{
+					Type:   problemUnauthorized,
+					Title:  "Admin endpoints are disabled",
+					Status: http.StatusForbidden,
+					Detail: "start the server with an admin token to use them",
+				})
+				return
+			}
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeProblem(w, models.Problem{
+					Type:   problemUnauthorized,
+					Title:  "Missing or wrong admin token",
+					Status: http.StatusUnauthorized,
+					Detail: "send the admin token as Authorization: Bearer <token>",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetRules lists the rules currently used to score receipts.
+func (h *AdminHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rulesResponse(h.scorer.Registry()))
+}
+
+// ReloadRules rebuilds the rules from their config. If the reload fails the
+// previous rules stay active and the error is returned to the caller.
+func (h *AdminHandler) ReloadRules(w http.ResponseWriter, r *http.Request) {
+	registry, err := h.scorer.Reload()
+	if err != nil {
+		log.Printf("Rule reload failed, keeping previous rules: %v", err)
+		writeProblem(w, models.Problem{
+			Type:   problemInvalidRules,
+			Title:  "Rule reload failed",
+			Status: http.StatusInternalServerError,
+			Detail: err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Rules reloaded via admin endpoint")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rulesResponse(registry))
+}
+
+// GetAuditLog lists receipt deletions and redactions, oldest first. The
+// receiptId parameter limits it to one receipt.
+func (h *AdminHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.store.AuditLog(r.URL.Query().Get("receiptId"))
+	if err != nil {
+		log.Printf("Failed to read audit log: %v", err)
+		writeInternalError(w, "Failed to read audit log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AuditResponse{Entries: entries})
+}
+
+// RescoreReceipts re-runs scoring over one stored receipt or all of them,
+// using the active rules, an earlier rule version or a rules config sent
+// with the request, and reports the old and new points. New scores are only saved when the request asks for it, and
+// not onto receipts that changed while the re-score ran. Redacted receipts
+// keep their points.
+func (h *AdminHandler) RescoreReceipts(w http.ResponseWriter, r *http.Request) {
+	var request models.RescoreRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeInvalidBody(w, err)
+			return
+		}
+	}
+
+	if request.RuleVersion != "" && len(request.Rules) > 0 {
+		writeInvalidBody(w, errors.New("give either ruleVersion or rules, not both"))
+		return
+	}
+	registry := h.scorer.Registry()
+	if len(request.Rules) > 0 {
+		var ok bool
+		if registry, ok = configRegistry(w, request.Rules); !ok {
+			return
+		}
+	}
+	if request.RuleVersion != "" {
+		var exists bool
+		if registry, exists = h.scorer.Lookup(request.RuleVersion); !exists {
+			writeNotFound(w, fmt.Sprintf("No rule version named or hashed %q", request.RuleVersion))
+			return
+		}
+	}
+
+	var records []store.Record
+	if request.ID != "" {
+		record, err := h.store.Get(request.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			writeNotFound(w, "Receipt not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load receipt %s: %v", request.ID, err)
+			writeInternalError(w, "Failed to load receipt")
+			return
+		}
+		records = []store.Record{record}
+	} else {
+		var err error
+		if records, err = h.store.List(); err != nil {
+			log.Printf("Failed to list receipts: %v", err)
+			writeInternalError(w, "Failed to list receipts")
+			return
+		}
+	}
+
+	response := models.RescoreResponse{
+		RuleVersion: registry.Version(),
+		Saved:       request.Save,
+		Results:     make([]models.RescoreResult, 0, len(records)),
+	}
+	for _, record := range records {
+		old := record.Score
+		if !record.RedactedAt.IsZero() {
+			// Scoring the wiped receipt would change its points.
+			response.Results = append(response.Results, models.RescoreResult{
+				ID:             record.ID,
+				OldPoints:      old.Points,
+				NewPoints:      old.Points,
+				OldRuleVersion: old.Version,
+				Skipped:        models.RescoreSkippedRedacted,
+			})
+			continue
+		}
+		score := registry.Score(record.Receipt)
+		result := models.RescoreResult{
+			ID:             record.ID,
+			OldPoints:      old.Points,
+			NewPoints:      score.Points,
+			OldRuleVersion: old.Version,
+		}
+		if request.Save {
+			// Save only the score, and only onto the revision that was
+			// scored, so a receipt amended or erased since it was read
+			// isn't overwritten with the stale copy.
+			saved, err := h.store.Rescore(record.ID, record.Revision, score)
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				result.Skipped = models.RescoreSkippedDeleted
+			case errors.Is(err, store.ErrConflict):
+				result.Skipped = models.RescoreSkippedChanged
+			case err != nil:
+				log.Printf("Failed to save re-scored receipt %s: %v", record.ID, err)
+				writeInternalError(w, "Failed to save receipt")
+				return
+			case h.receipts != nil && (score.Points != old.Points || score.Version != old.Version):
+				h.receipts.publishScored(saved)
+				h.receipts.publishActivity(models.EventReceiptRescored, saved)
+			}
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func rulesResponse(registry *service.Registry) models.RulesResponse {
+	rules := registry.Rules()
+	response := models.RulesResponse{
+		RuleVersion: registry.Version(),
+		Rules:       make([]models.RuleInfo, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		response.Rules = append(response.Rules, models.RuleInfo{
+			Name:        rule.Name(),
+			Description: rule.Description(),
+		})
+	}
+	return response
+}
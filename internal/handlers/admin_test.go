@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloadRules(t *testing.T) {
+	reduced := service.DefaultConfig()
+	reduced.Rules.Afternoon.Enabled = false
+
+	tests := []struct {
+		name         string
+		load         func() (*service.Registry, error)
+		expectedCode int
+		expectedLen  int
+	}{
+		{
+			name: "Successful Reload",
+			load: func() (*service.Registry, error) {
+				return service.NewRegistryFromConfig(reduced)
+			},
+			expectedCode: http.StatusOK,
+			expectedLen:  len(service.BuiltinRules()) - 1,
+		},
+		{
+			name: "Failed Reload",
+			load: func() (*service.Registry, error) {
+				return nil, errors.New("invalid rules config")
+			},
+			expectedCode: http.StatusInternalServerError,
+			expectedLen:  len(service.BuiltinRules()),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scorer := service.NewScorer(service.DefaultRegistry, tt.load)
+			handler := NewAdminHandler(scorer, store.NewStore())
+
+			rr := httptest.NewRecorder()
+			handler.ReloadRules(rr, httptest.NewRequest("POST", "/admin/rules/reload", nil))
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if contentType := rr.Header().Get("Content-Type"); tt.expectedCode != http.StatusOK && contentType != "application/problem+json" {
+				t.Errorf("error response has Content-Type %q, want application/problem+json", contentType)
+			}
+
+			rr = httptest.NewRecorder()
+			handler.GetRules(rr, httptest.NewRequest("GET", "/admin/rules", nil))
+			var response models.RulesResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't decode response: %v", err)
+			}
+			if len(response.Rules) != tt.expectedLen {
+				t.Errorf("expected %d active rules, got %d", tt.expectedLen, len(response.Rules))
+			}
+		})
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		expectedCode  int
+	}{
+		{"Right Token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"Wrong Token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"Missing Token", "s3cret", "", http.StatusUnauthorized},
+		{"Not A Bearer Token", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+		{"No Token Configured", "", "Bearer ", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/admin/rules/reload", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			RequireAdminToken(tt.token)(ok).ServeHTTP(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK && rr.Header().Get("Content-Type") != "application/problem+json" {
+				t.Errorf("rejection has Content-Type %q, want application/problem+json", rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestRescoreReceipts(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []models.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+		},
+		Total: "6.49",
+	}
+
+	promo := service.DefaultConfig()
+	promo.Name = "promo"
+	promo.Rules.OddDay.Points = 100
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedCode   int
+		expectedPoints map[string]int64
+		expectedStored int64
+	}{
+		{
+			name:           "All Receipts Dry Run",
+			body:           `{}`,
+			expectedCode:   http.StatusOK,
+			expectedPoints: map[string]int64{"a": 106, "b": 106},
+			expectedStored: 12,
+		},
+		{
+			name:           "Single Receipt Saved",
+			body:           `{"id": "a", "save": true}`,
+			expectedCode:   http.StatusOK,
+			expectedPoints: map[string]int64{"a": 106},
+			expectedStored: 106,
+		},
+		{
+			name:           "Named Version",
+			body:           `{"ruleVersion": "default", "save": true}`,
+			expectedCode:   http.StatusOK,
+			expectedPoints: map[string]int64{"a": 12, "b": 12},
+			expectedStored: 12,
+		},
+		{
+			name:         "Unknown Receipt",
+			body:         `{"id": "missing"}`,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "Unknown Version",
+			body:         `{"ruleVersion": "missing"}`,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:           "Inline Rules",
+			body:           `{"id": "a", "rules": {"name": "later", "rules": {"oddDay": {"points": 50}}}, "save": true}`,
+			expectedCode:   http.StatusOK,
+			expectedPoints: map[string]int64{"a": 56},
+			expectedStored: 56,
+		},
+		{
+			name:         "Invalid Inline Rules",
+			body:         `{"rules": {"rules": {"oddDay": {"points": -1}}}}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Version And Rules",
+			body:         `{"ruleVersion": "default", "rules": {"name": "later"}}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid JSON",
+			body:         `{invalid json}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := store.NewStore()
+			for _, id := range []string{"a", "b"} {
+				store.SaveReceipt(id, receipt, service.DefaultRegistry.Score(receipt))
+			}
+			scorer := service.NewScorer(service.DefaultRegistry, func() (*service.Registry, error) {
+				return service.NewRegistryFromConfig(promo)
+			})
+			scorer.Reload()
+			handler := NewAdminHandler(scorer, store)
+
+			rr := httptest.NewRecorder()
+			handler.RescoreReceipts(rr, httptest.NewRequest("POST", "/admin/receipts/rescore", bytes.NewBufferString(tt.body)))
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				if contentType := rr.Header().Get("Content-Type"); contentType != "application/problem+json" {
+					t.Errorf("error response has Content-Type %q, want application/problem+json", contentType)
+				}
+				return
+			}
+
+			var response models.RescoreResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't decode response: %v", err)
+			}
+			if len(response.Results) != len(tt.expectedPoints) {
+				t.Fatalf("expected %d results, got %d", len(tt.expectedPoints), len(response.Results))
+			}
+			for _, result := range response.Results {
+				if result.OldPoints != 12 || result.OldRuleVersion != service.DefaultRegistry.Version() {
+					t.Errorf("unexpected old score for %s: %+v", result.ID, result)
+				}
+				if result.NewPoints != tt.expectedPoints[result.ID] {
+					t.Errorf("expected %d new points for %s, got %d", tt.expectedPoints[result.ID], result.ID, result.NewPoints)
+				}
+			}
+			if points, _ := store.GetPoints("a"); points != tt.expectedStored {
+				t.Errorf("expected %d stored points, got %d", tt.expectedStored, points)
+			}
+		})
+	}
+}
+
+func TestRescoreInlineRulesMatchLoadedVersion(t *testing.T) {
+	promo := service.DefaultConfig()
+	promo.Name = "promo"
+	promo.Rules.OddDay.Points = 100
+	loaded, _ := service.NewRegistryFromConfig(promo)
+	config, _ := json.Marshal(promo)
+
+	// As after a restart: the scorer no longer knows the promo rules.
+	handler := NewAdminHandler(service.NewScorer(service.DefaultRegistry, nil), store.NewStore())
+	rr := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"rules": %s}`, config)
+	handler.RescoreReceipts(rr, httptest.NewRequest("POST", "/admin/receipts/rescore", bytes.NewBufferString(body)))
+	var response models.RescoreResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.RuleVersion != loaded.Version() {
+		t.Errorf("inline config scored as %+v, want the loaded version %+v", response.RuleVersion, loaded.Version())
+	}
+}
+
+func TestRescoreReceiptsPublishesEvents(t *testing.T) {
+	memory := store.NewStore()
+	memory.SaveReceipt("a", models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}, models.Score{Points: 1})
+	events := service.NewEventStream(10)
+	subscription := events.Subscribe("")
+	defer subscription.Close()
+	handler := NewAdminHandler(service.NewScorer(service.DefaultRegistry, nil), memory,
+		WithReceiptEvents(NewReceiptHandler(memory, WithEventStream(events))))
+
+	rescore := func() {
+		rr := httptest.NewRecorder()
+		handler.RescoreReceipts(rr, httptest.NewRequest("POST", "/admin/receipts/rescore", bytes.NewBufferString(`{"save": true}`)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+	rescore()
+	select {
+	case event := <-subscription.Events:
+		if event.Event.Type != models.EventReceiptRescored || event.Event.ReceiptID != "a" || *event.Event.Points != 12 {
+			t.Errorf("unexpected event %+v", event.Event)
+		}
+	default:
+		t.Fatal("saving a new score published no event")
+	}
+
+	// Nothing changes the second time, so there is nothing to announce.
+	rescore()
+	select {
+	case event := <-subscription.Events:
+		t.Errorf("unchanged re-score published %+v", event.Event)
+	default:
+	}
+}
+
+// racingStore runs beforeRescore ahead of every Rescore, to change a record
+// between the re-score reading it and saving the new score.
+type racingStore struct {
+	*store.ReceiptStore
+	beforeRescore func(id string)
+}
+
+func (s racingStore) Rescore(id string, revision int, score models.Score) (store.Record, error) {
+	s.beforeRescore(id)
+	return s.ReceiptStore.Rescore(id, revision, score)
+}
+
+func TestRescoreReceiptsRace(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+	rescore := func(s store.Store) models.RescoreResponse {
+		rr := httptest.NewRecorder()
+		NewAdminHandler(service.NewScorer(service.DefaultRegistry, nil), s).
+			RescoreReceipts(rr, httptest.NewRequest("POST", "/admin/receipts/rescore", bytes.NewBufferString(`{"save": true}`)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var response models.RescoreResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return response
+	}
+
+	t.Run("Amended", func(t *testing.T) {
+		memory := store.NewStore()
+		memory.SaveReceipt("a", receipt, models.Score{Points: 1})
+		corrected := receipt
+		corrected.Retailer = "Walgreens"
+		var amended store.Record
+		s := racingStore{memory, func(id string) {
+			amended, _ = memory.Amend(store.Record{ID: id, Receipt: corrected, Score: models.Score{Points: 2}})
+		}}
+
+		response := rescore(s)
+		if len(response.Results) != 1 || response.Results[0].Skipped != models.RescoreSkippedChanged {
+			t.Errorf("unexpected results %+v", response.Results)
+		}
+		if got, _ := memory.Get("a"); !reflect.DeepEqual(got, amended) {
+			t.Errorf("stored %+v, want the amendment %+v kept", got, amended)
+		}
+		if history, _ := memory.History("a"); len(history) != 2 || history[1].Receipt.Retailer != "Walgreens" {
+			t.Errorf("unexpected history %+v", history)
+		}
+	})
+
+	t.Run("Redacted", func(t *testing.T) {
+		memory := store.NewStore()
+		memory.SaveReceipt("a", receipt, models.Score{Points: 1})
+		s := racingStore{memory, func(id string) {
+			memory.Erase(id, models.AuditRedact, time.Now().UTC())
+		}}
+
+		response := rescore(s)
+		if len(response.Results) != 1 || response.Results[0].Skipped != models.RescoreSkippedChanged {
+			t.Errorf("unexpected results %+v", response.Results)
+		}
+		if got, _ := memory.Get("a"); got.Receipt.Retailer != "" || got.Score.Points != 1 {
+			t.Errorf("redaction undone: stored %+v", got)
+		}
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		memory := store.NewStore()
+		memory.SaveReceipt("a", receipt, models.Score{Points: 1})
+		s := racingStore{memory, func(id string) {
+			memory.Erase(id, models.AuditDelete, time.Now().UTC())
+		}}
+
+		response := rescore(s)
+		if len(response.Results) != 1 || response.Results[0].Skipped != models.RescoreSkippedDeleted {
+			t.Errorf("unexpected results %+v", response.Results)
+		}
+		if _, err := memory.Get("a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("deleted receipt came back: Get() error = %v", err)
+		}
+	})
+}
+
+func TestRescoreKeepsRedactedPoints(t *testing.T) {
+	memory := store.NewStore()
+	memory.SaveReceipt("a", models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}, models.Score{Points: 20})
+	memory.Erase("a", models.AuditRedact, time.Now().UTC())
+
+	rr := httptest.NewRecorder()
+	NewAdminHandler(service.NewScorer(service.DefaultRegistry, nil), memory).
+		RescoreReceipts(rr, httptest.NewRequest("POST", "/admin/receipts/rescore", bytes.NewBufferString(`{"save": true}`)))
+	var response models.RescoreResponse
+	json.NewDecoder(rr.Body).Decode(&response)
+
+	if len(response.Results) != 1 || response.Results[0].Skipped != models.RescoreSkippedRedacted || response.Results[0].NewPoints != 20 {
+		t.Errorf("unexpected results %+v", response.Results)
+	}
+	if points, _ := memory.GetPoints("a"); points != 20 {
+		t.Errorf("redacted receipt stored with %d points, want 20", points)
+	}
+}
+
+func TestGetAuditLog(t *testing.T) {
+	store := store.NewStore()
+	for _, id := range []string{"a", "b"} {
+		store.SaveReceipt(id, models.Receipt{Retailer: "Target"}, models.Score{Points: 6})
+	}
+	store.Erase("a", models.AuditRedact, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	store.Erase("b", models.AuditDelete, time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC))
+	handler := NewAdminHandler(service.NewScorer(service.DefaultRegistry, nil), store)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"All Entries", "", []string{"a:redact", "b:delete"}},
+		{"One Receipt", "?receiptId=b", []string{"b:delete"}},
+		{"No Entries", "?receiptId=c", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.GetAuditLog(rr, httptest.NewRequest("GET", "/admin/audit"+tt.query, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			var response models.AuditResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't decode response: %v", err)
+			}
+			var got []string
+			for _, entry := range response.Entries {
+				got = append(got, entry.ReceiptID+":"+string(entry.Action))
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got entries %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"runtime"
+	"sync"
+)
+
+// maxBatchSize is the most receipts one batch may hold.
+const maxBatchSize = 1000
+
+// WithBatchWorkers sets how many receipts of a batch are validated and
+// scored at once. The default is runtime.GOMAXPROCS(0).
+func WithBatchWorkers(n int) Option {
+	return func(h *ReceiptHandler) {
+		h.batchWorkers = n
+	}
+}
+
+func defaultBatchWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// ProcessBatch submits an array of receipts. Each receipt is stored or
+// rejected on its own, exactly as POST /receipts/process would, and the
+// results are returned in the order of the receipts.
+func (h *ReceiptHandler) ProcessBatch(w http.ResponseWriter, r *http.Request) {
+	receipts, err := decodeBatch(r.Body)
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+
+	results := make([]models.BatchResult, len(receipts))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	workers := min(max(h.batchWorkers, 1), len(receipts))
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = h.batchResult(i, receipts[i])
+			}
+		}()
+	}
+	for i := range receipts {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	response := models.BatchResponse{Results: results}
+	for _, result := range results {
+		if result.Error == nil {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// decodeBatch reads a JSON array of receipts one element at a time, so an
+// oversized batch is refused once it passes maxBatchSize receipts instead of
+// being read whole. The receipts are left encoded, so one malformed receipt
+// fails alone.
+func decodeBatch(body io.Reader) ([]json.RawMessage, error) {
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, errors.New("a batch must be a JSON array of receipts")
+	}
+	var receipts []json.RawMessage
+	for decoder.More() {
+		if len(receipts) == maxBatchSize {
+			return nil, fmt.Errorf("a batch must hold between 1 and %d receipts, got more", maxBatchSize)
+		}
+		var receipt json.RawMessage
+		if err := decoder.Decode(&receipt); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	// Read the closing bracket, so a truncated array is refused.
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	if len(receipts) == 0 {
+		return nil, fmt.Errorf("a batch must hold between 1 and %d receipts, got 0", maxBatchSize)
+	}
+	return receipts, nil
+}
+
+// batchResult submits the receipt at index i of a batch.
+func (h *ReceiptHandler) batchResult(i int, data []byte) models.BatchResult {
+	record, problem := h.submitJSON(data)
+	if problem != nil {
+		return models.BatchResult{Index: i, Status: problem.Status, Error: problem}
+	}
+	points := record.Score.Points
+	return models.BatchResult{Index: i, Status: http.StatusOK, ID: record.ID, Points: &points, Flags: record.Flags}
+}
+
+// submitJSON decodes and submits one receipt of a bulk request, like
+// submitReceipt.
+func (h *ReceiptHandler) submitJSON(data []byte) (store.Record, *models.Problem) {
+	var receipt models.Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return store.Record{}, &models.Problem{
+			Type:   problemInvalidBody,
+			Title:  "Invalid receipt",
+			Status: http.StatusBadRequest,
+			Detail: err.Error(),
+		}
+	}
+	return h.submitReceipt(receipt)
+}
+
+// submitReceipt submits receipt, describing any failure as the problem POST
+// /receipts/process would have answered with.
+func (h *ReceiptHandler) submitReceipt(receipt models.Receipt) (store.Record, *models.Problem) {
+	record, err := h.submit(receipt)
+	var duplicate *duplicateError
+	switch {
+	case errors.As(err, &duplicate):
+		problem := duplicateProblem(duplicate)
+		return store.Record{}, &problem
+	case errors.As(err, new(service.ValidationErrors)):
+		problem := validationProblem(err)
+		return store.Record{}, &problem
+	case err != nil:
+		log.Printf("Failed to save receipt: %v", err)
+		return store.Record{}, &models.Problem{
+			Type:   "about:blank",
+			Title:  "Failed to save receipt",
+			Status: http.StatusInternalServerError,
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"strings"
+	"testing"
+)
+
+func TestProcessBatch(t *testing.T) {
+	receipt := func(total string) string {
+		return fmt.Sprintf(`{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+			"items": [{"shortDescription": "Pepsi", "price": %q}], "total": %q}`, total, total)
+	}
+	post := func(handler *ReceiptHandler, body string) (*httptest.ResponseRecorder, models.BatchResponse) {
+		rr := httptest.NewRecorder()
+		handler.ProcessBatch(rr, httptest.NewRequest("POST", "/receipts/batch", bytes.NewBufferString(body)))
+		var response models.BatchResponse
+		json.NewDecoder(bytes.NewReader(rr.Body.Bytes())).Decode(&response)
+		return rr, response
+	}
+
+	t.Run("Each Receipt Stands Alone", func(t *testing.T) {
+		store := store.NewStore()
+		// One worker, so the first copy of the repeated receipt is stored
+		// first.
+		handler := NewReceiptHandler(store, WithDuplicatePolicy(DuplicateReject), WithBatchWorkers(1))
+		body := "[" + strings.Join([]string{
+			receipt("1.00"),
+			`{"retailer": "Target!!!", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+				"items": [{"shortDescription": "Pepsi", "price": "1.00"}], "total": "1.00"}`,
+			`{"retailer": 42}`,
+			receipt("1.00"),
+			receipt("2.00"),
+		}, ", ") + "]"
+
+		rr, response := post(handler, body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if len(response.Results) != 5 || response.Succeeded != 2 || response.Failed != 3 {
+			t.Fatalf("unexpected response %+v", response)
+		}
+
+		wantStatus := []int{http.StatusOK, http.StatusBadRequest, http.StatusBadRequest, http.StatusConflict, http.StatusOK}
+		for i, result := range response.Results {
+			if result.Index != i || result.Status != wantStatus[i] {
+				t.Errorf("result %d = index %d status %d, want index %d status %d", i, result.Index, result.Status, i, wantStatus[i])
+			}
+		}
+
+		first := response.Results[0]
+		if first.ID == "" || first.Points == nil || *first.Points != 87 || first.Error != nil {
+			t.Errorf("unexpected result for a valid receipt %+v", first)
+		}
+		if problem := response.Results[1].Error; problem == nil || problem.Type != problemInvalidReceipt ||
+			len(problem.Errors) != 1 || problem.Errors[0].Path != "/retailer" {
+			t.Errorf("expected a validation problem for the invalid retailer, got %+v", problem)
+		}
+		if problem := response.Results[2].Error; problem == nil || problem.Type != problemInvalidBody {
+			t.Errorf("expected an invalid body problem for the malformed receipt, got %+v", problem)
+		}
+		if problem := response.Results[3].Error; problem == nil || problem.ExistingID != first.ID {
+			t.Errorf("expected the repeated receipt to duplicate %s, got %+v", first.ID, problem)
+		}
+
+		if records, _ := store.List(); len(records) != 2 {
+			t.Errorf("store holds %d receipts, want 2", len(records))
+		}
+	})
+
+	t.Run("Results Keep Receipt Order", func(t *testing.T) {
+		store := store.NewStore()
+		handler := NewReceiptHandler(store, WithBatchWorkers(4))
+		receipts := make([]string, 50)
+		for i := range receipts {
+			receipts[i] = receipt(fmt.Sprintf("0.%02d", i+1))
+		}
+
+		_, response := post(handler, "["+strings.Join(receipts, ",")+"]")
+		if response.Succeeded != len(receipts) {
+			t.Fatalf("%d of %d receipts succeeded: %+v", response.Succeeded, len(receipts), response)
+		}
+		for i, result := range response.Results {
+			record, err := store.Get(result.ID)
+			if want := fmt.Sprintf("0.%02d", i+1); err != nil || record.Receipt.Total != want {
+				t.Errorf("result %d holds the receipt with total %s, want %s", i, record.Receipt.Total, want)
+			}
+		}
+	})
+
+	t.Run("Invalid Batches", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		tooMany := "[" + strings.TrimSuffix(strings.Repeat(receipt("1.00")+",", maxBatchSize+1), ",") + "]"
+		for name, body := range map[string]string{
+			"Empty":     "[]",
+			"Not Array": receipt("1.00"),
+			"Too Many":  tooMany,
+			"Truncated": "[" + receipt("1.00"),
+			"Null":      "null",
+		} {
+			rr, _ := post(handler, body)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: handler returned wrong status code: got %v want %v", name, rr.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("Too Many Stops Reading", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		// An endless array: the handler must give up after maxBatchSize+1
+		// receipts rather than read it all.
+		body := io.MultiReader(strings.NewReader("["), &endlessReceipts{receipt: receipt("1.00")})
+		rr := httptest.NewRecorder()
+		handler.ProcessBatch(rr, httptest.NewRequest("POST", "/receipts/batch", body))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Store Failure", func(t *testing.T) {
+		_, response := post(NewReceiptHandler(failingStore{}), "["+receipt("1.00")+"]")
+		if len(response.Results) != 1 || response.Results[0].Status != http.StatusInternalServerError {
+			t.Errorf("expected a 500 result, got %+v", response)
+		}
+	})
+}
+
+// endlessReceipts reads as an endless comma-separated list of receipt.
+type endlessReceipts struct {
+	receipt string
+	pending []byte
+}
+
+func (r *endlessReceipts) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		r.pending = []byte(r.receipt + ",")
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
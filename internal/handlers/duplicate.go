@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"sync"
+)
+
+// DuplicatePolicy controls what happens to a submitted receipt whose
+// fingerprint matches one already stored.
+type DuplicatePolicy string
+
+const (
+	// DuplicateReject refuses the receipt with 409 Conflict.
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateExisting stores nothing and returns the stored receipt's ID.
+	DuplicateExisting DuplicatePolicy = "existing"
+	// DuplicateFlag stores the receipt under a new ID with a flag naming the
+	// receipt it duplicates.
+	DuplicateFlag DuplicatePolicy = "flag"
+)
+
+// ParseDuplicatePolicy parses "reject", "existing" or "flag".
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch policy := DuplicatePolicy(s); policy {
+	case DuplicateReject, DuplicateExisting, DuplicateFlag:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid duplicate policy %q: want reject, existing or flag", s)
+}
+
+// WithDuplicatePolicy sets how duplicate submissions are handled. The
+// default is DuplicateFlag.
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(h *ReceiptHandler) {
+		h.duplicates = policy
+	}
+}
+
+// codeDuplicateReceipt is the models.FieldError code of the flag recorded on
+// a duplicate. Its path is the whole receipt.
+const codeDuplicateReceipt = "duplicate_receipt"
+
+// duplicateError rejects a receipt that matches the stored one.
+type duplicateError struct {
+	existing store.Record
+}
+
+func (e *duplicateError) Error() string {
+	return fmt.Sprintf("receipt duplicates %s", e.existing.ID)
+}
+
+// findDuplicate returns the stored receipt with the given fingerprint, if
+// any.
+func (h *ReceiptHandler) findDuplicate(fingerprint string) (store.Record, bool, error) {
+	existing, err := h.store.FindByFingerprint(fingerprint)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Record{}, false, nil
+	}
+	if err != nil {
+		return store.Record{}, false, err
+	}
+	return existing, true, nil
+}
+
+// fingerprintLocks serializes the submissions of receipts with the same
+// fingerprint, from the duplicate check until the receipt is stored, so two
+// copies submitted at once can't both pass the check while different
+// receipts are stored in parallel.
+type fingerprintLocks struct {
+	mutex sync.Mutex
+	locks map[string]*fingerprintLock
+}
+
+// fingerprintLock is the lock of one fingerprint, kept while any submission
+// holds or waits for it.
+type fingerprintLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock blocks until no other submission holds fingerprint, then holds it
+// and returns a function that releases it.
+func (l *fingerprintLocks) lock(fingerprint string) func() {
+	l.mutex.Lock()
+	lock := l.locks[fingerprint]
+	if lock == nil {
+		if l.locks == nil {
+			l.locks = make(map[string]*fingerprintLock)
+		}
+		lock = &fingerprintLock{}
+		l.locks[fingerprint] = lock
+	}
+	lock.users++
+	l.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(l.locks, fingerprint)
+		}
+	}
+}
+
+func duplicateFlag(existing store.Record) models.FieldError {
+	return models.FieldError{
+		Path:    "",
+		Code:    codeDuplicateReceipt,
+		Message: fmt.Sprintf("receipt duplicates %s", existing.ID),
+	}
+}
+
+// duplicateProblem reports a receipt rejected as a duplicate.
+func duplicateProblem(err *duplicateError) models.Problem {
+	return models.Problem{
+		Type:       problemDuplicate,
+		Title:      "The receipt was already submitted",
+		Status:     http.StatusConflict,
+		Detail:     err.Error(),
+		ExistingID: err.existing.ID,
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"time"
+)
+
+const (
+	// eventHeartbeat is how often an idle stream gets a comment line, so
+	// proxies don't close it.
+	eventHeartbeat = 15 * time.Second
+	// eventWriteTimeout bounds one write to a stream, so a client that stops
+	// reading doesn't hold its connection open forever.
+	eventWriteTimeout = 30 * time.Second
+)
+
+// WithEventStream publishes receipt activity to stream instead of a stream of
+// the handler's own.
+func WithEventStream(stream *service.EventStream) Option {
+	return func(h *ReceiptHandler) {
+		h.events = stream
+	}
+}
+
+// publishActivity reports a change to record on the event stream.
+func (h *ReceiptHandler) publishActivity(eventType string, record store.Record) {
+	points := record.Score.Points
+	h.events.Publish(models.ActivityEvent{
+		Type:      eventType,
+		ReceiptID: record.ID,
+		Revision:  max(record.Revision, 1),
+		Points:    &points,
+		Flags:     record.Flags,
+		At:        time.Now().UTC(),
+	})
+}
+
+// StreamEvents streams receipt activity as Server-Sent Events. A client that
+// reconnects with Last-Event-ID, or the lastEventId parameter, first gets the
+// buffered events it missed.
+func (h *ReceiptHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	subscription := h.events.Subscribe(lastEventID)
+	defer subscription.Close()
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep reverse proxies such as nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(frame string) bool {
+		controller.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if _, err := fmt.Fprint(w, frame); err != nil {
+			return false
+		}
+		return controller.Flush() == nil
+	}
+	if !write(": connected\n\n") {
+		return
+	}
+	for _, event := range subscription.Backlog {
+		if !write(eventFrame(event)) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": keep-alive\n\n") {
+				return
+			}
+		case event, ok := <-subscription.Events:
+			if !ok {
+				// Dropped for falling behind. The client reconnects and
+				// resumes from its last event.
+				return
+			}
+			if !write(eventFrame(event)) {
+				return
+			}
+		}
+	}
+}
+
+// eventFrame formats event as a Server-Sent Event.
+func eventFrame(event service.StreamEvent) string {
+	data, _ := json.Marshal(event.Event)
+	return fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Event.Type, data)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"strings"
+	"testing"
+)
+
+// sseEvent is one event read from a stream.
+type sseEvent struct {
+	id, event string
+	data      models.ActivityEvent
+}
+
+// readEvent reads the next event from a stream, skipping comments.
+func readEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	t.Helper()
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			if err := json.Unmarshal([]byte(value), &event.data); err != nil {
+				t.Fatalf("decoding %q: %v", value, err)
+			}
+		case "":
+			if event.id != "" {
+				return event
+			}
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	handler := NewReceiptHandler(store.NewStore(), WithEventStream(service.NewEventStream(10)))
+	server := httptest.NewServer(http.HandlerFunc(handler.StreamEvents))
+	defer server.Close()
+
+	connect := func(lastEventID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("connecting: %v", err)
+		}
+		if resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("got Content-Type %q, want text/event-stream", resp.Header.Get("Content-Type"))
+		}
+		reader := bufio.NewReader(resp.Body)
+		// Wait for the comment that confirms the subscription.
+		if line, err := reader.ReadString('\n'); err != nil || line != ": connected\n" {
+			t.Fatalf("got %q, %v; want the connected comment", line, err)
+		}
+		return resp, reader
+	}
+
+	resp, reader := connect("")
+	defer resp.Body.Close()
+
+	rr := httptest.NewRecorder()
+	handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(importReceipt)))
+	var processed models.ReceiptResponse
+	json.NewDecoder(rr.Body).Decode(&processed)
+	vars := map[string]string{"id": processed.ID}
+	amended := strings.Replace(importReceipt, "13:01", "14:30", 1)
+	handler.AmendReceipt(httptest.NewRecorder(), mux.SetURLVars(httptest.NewRequest("PUT", "/receipts/{id}", strings.NewReader(amended)), vars))
+	handler.DeleteReceipt(httptest.NewRecorder(), mux.SetURLVars(httptest.NewRequest("DELETE", "/receipts/{id}?mode=redact", nil), vars))
+
+	first := readEvent(t, reader)
+	if first.event != models.EventReceiptProcessed || first.data.ReceiptID != processed.ID ||
+		first.data.Points == nil || *first.data.Points != 87 {
+		t.Errorf("unexpected processed event %+v", first)
+	}
+	second := readEvent(t, reader)
+	if second.event != models.EventReceiptAmended || second.data.Revision != 2 || *second.data.Points != 97 {
+		t.Errorf("unexpected amended event %+v", second)
+	}
+	third := readEvent(t, reader)
+	if third.event != models.EventReceiptDeleted || third.data.Action != models.AuditRedact || third.data.Points != nil {
+		t.Errorf("unexpected deleted event %+v", third)
+	}
+
+	t.Run("Resume", func(t *testing.T) {
+		resp, reader := connect(first.id)
+		defer resp.Body.Close()
+		if event := readEvent(t, reader); event.id != second.id {
+			t.Errorf("resumed at %s, want %s", event.id, second.id)
+		}
+		if event := readEvent(t, reader); event.id != third.id {
+			t.Errorf("resumed with %s second, want %s", event.id, third.id)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayHeader marks a response replayed from an earlier
+	// request with the same key.
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+)
+
+// defaultIdempotencyTTL is the TTL of handlers created without
+// WithIdempotencyTTL.
+const defaultIdempotencyTTL = store.DefaultIdempotencyTTL
+
+// WithIdempotencyTTL sets how long a response is kept for retries under its
+// Idempotency-Key.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(h *ReceiptHandler) {
+		h.idempotencyTTL = ttl
+	}
+}
+
+// keyLocks tracks the idempotency keys of requests being processed, so a
+// retry that arrives while the original is still running is turned away
+// instead of being applied a second time.
+type keyLocks struct {
+	mutex sync.Mutex
+	inUse map[string]struct{}
+}
+
+// acquire marks key in use and returns a function that releases it, or
+// returns false if it is already in use.
+func (l *keyLocks) acquire(key string) (func(), bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, busy := l.inUse[key]; busy {
+		return nil, false
+	}
+	if l.inUse == nil {
+		l.inUse = make(map[string]struct{})
+	}
+	l.inUse[key] = struct{}{}
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		delete(l.inUse, key)
+	}, true
+}
+
+// idempotentRequest is a request made with an Idempotency-Key.
+type idempotentRequest struct {
+	key, hash string
+	release   func()
+}
+
+// beginIdempotent handles the Idempotency-Key of a request with the given
+// body. If the key was already used, it replays the saved response or
+// reports the reuse and returns false. Otherwise it returns true, with the
+// key held until the caller releases it, or a nil request if there is no key.
+func (h *ReceiptHandler) beginIdempotent(w http.ResponseWriter, r *http.Request, body []byte) (*idempotentRequest, bool) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return nil, true
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeProblem(w, models.Problem{
+			Type:   problemInvalidHeader,
+			Title:  "Invalid Idempotency-Key header",
+			Status: http.StatusBadRequest,
+			Detail: fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength),
+		})
+		return nil, false
+	}
+
+	release, ok := h.keys.acquire(key)
+	if !ok {
+		writeProblem(w, models.Problem{
+			Type:   problemKeyInUse,
+			Title:  "A request with this Idempotency-Key is in progress",
+			Status: http.StatusConflict,
+			Detail: "retry once the original request has finished",
+		})
+		return nil, false
+	}
+
+	sum := sha256.Sum256(body)
+	request := &idempotentRequest{key: key, hash: hex.EncodeToString(sum[:]), release: release}
+	saved, err := h.store.GetIdempotencyKey(key, time.Now().UTC())
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return request, true
+	case err != nil:
+		release()
+		log.Printf("Failed to look up idempotency key %q: %v", key, err)
+		http.Error(w, "Failed to look up Idempotency-Key", http.StatusInternalServerError)
+		return nil, false
+	}
+	release()
+
+	if saved.RequestHash != request.hash {
+		writeProblem(w, models.Problem{
+			Type:   problemKeyReused,
+			Title:  "The Idempotency-Key was used with a different request",
+			Status: http.StatusUnprocessableEntity,
+			Detail: "send a new Idempotency-Key for a different receipt",
+		})
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(idempotentReplayHeader, "true")
+	w.WriteHeader(saved.Status)
+	w.Write(append(saved.Response, '\n'))
+	return nil, false
+}
+
+// finishIdempotent saves the response to request under its key. A failure to
+// save is only logged, since the request itself succeeded.
+func (h *ReceiptHandler) finishIdempotent(request *idempotentRequest, status int, response []byte) {
+	if request == nil {
+		return
+	}
+	now := time.Now().UTC()
+	err := h.store.SaveIdempotencyKey(store.IdempotencyKey{
+		Key:         request.key,
+		RequestHash: request.hash,
+		Status:      status,
+		Response:    response,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(h.idempotencyTTL),
+	})
+	if err != nil {
+		log.Printf("Failed to save idempotency key %q: %v", request.key, err)
+	}
+}
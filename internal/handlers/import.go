@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"receipt-processor/internal/models"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	// maxImportLine bounds one line of an import, so a missing newline can't
+	// make the server buffer the rest of the input.
+	maxImportLine = 1 << 20
+)
+
+// errLineTooLong reports a line longer than maxImportLine.
+var errLineTooLong = fmt.Errorf("line is longer than %d bytes", maxImportLine)
+
+// ImportReceipts reads newline-delimited JSON receipts and submits each one
+// as POST /receipts/process would. A result is streamed back for every line
+// as soon as it is processed, followed by a summary line, so arbitrarily
+// large imports run in constant memory.
+func (h *ReceiptHandler) ImportReceipts(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != ndjsonContentType {
+		writeProblem(w, models.Problem{
+			Type:   problemUnsupportedMedia,
+			Title:  "Unsupported media type",
+			Status: http.StatusUnsupportedMediaType,
+			Detail: "imports must be sent as " + ndjsonContentType,
+		})
+		return
+	}
+
+	// Results are written while the body is still being read. HTTP/1.1
+	// servers only allow that once asked; HTTP/2 always does, and reports
+	// the call as unsupported.
+	controller := http.NewResponseController(w)
+	controller.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	flush := func() {
+		out.Flush()
+		controller.Flush()
+	}
+
+	reader := bufio.NewReader(r.Body)
+	var summary models.ImportSummary
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := readLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.Is(err, errLineTooLong) {
+			summary.Error = fmt.Sprintf("read line %d: %v", lineNumber, err)
+			break
+		}
+		if err == nil && len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var result models.ImportResult
+		if err != nil {
+			result = importFailure(lineNumber, &models.Problem{
+				Type:   problemInvalidBody,
+				Title:  "Invalid receipt",
+				Status: http.StatusRequestEntityTooLarge,
+				Detail: err.Error(),
+			})
+		} else {
+			result = h.importResult(lineNumber, line)
+		}
+
+		summary.Lines++
+		if result.Error == nil {
+			summary.Accepted++
+		} else {
+			summary.Rejected++
+		}
+		encoder.Encode(result)
+		// Flush whenever reading on would wait for the client, so results
+		// go out in large writes while input streams in but are never held
+		// back while it stalls.
+		if reader.Buffered() == 0 {
+			flush()
+		}
+	}
+
+	encoder.Encode(models.ImportSummaryLine{Summary: summary})
+	flush()
+}
+
+// importResult submits one line of an import.
+func (h *ReceiptHandler) importResult(line int, data []byte) models.ImportResult {
+	record, problem := h.submitJSON(data)
+	if problem != nil {
+		return importFailure(line, problem)
+	}
+	points := record.Score.Points
+	return models.ImportResult{Line: line, Status: http.StatusOK, ID: record.ID, Points: &points, Flags: record.Flags}
+}
+
+func importFailure(line int, problem *models.Problem) models.ImportResult {
+	return models.ImportResult{Line: line, Status: problem.Status, Error: problem}
+}
+
+// readLine returns the next line without its newline. A line longer than
+// maxImportLine is skipped and reported as errLineTooLong. It returns io.EOF
+// only at the end of the input; a final line without a newline is returned
+// as usual.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > maxImportLine+1 {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && (len(line) > 0 || tooLong):
+			// The input ended without a final newline.
+		case err != nil:
+			return nil, err
+		}
+		if tooLong {
+			return nil, errLineTooLong
+		}
+		return bytes.TrimSuffix(line, []byte("\n")), nil
+	}
+}
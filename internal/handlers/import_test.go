@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"strings"
+	"testing"
+)
+
+const importReceipt = `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", ` +
+	`"items": [{"shortDescription": "Pepsi", "price": "1.00"}], "total": "1.00"}`
+
+// readImport splits an import response into its results and summary.
+func readImport(t *testing.T, body io.Reader) ([]models.ImportResult, models.ImportSummary) {
+	t.Helper()
+	var (
+		results []models.ImportResult
+		summary *models.ImportSummary
+	)
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if summary != nil {
+			t.Fatalf("line after the summary: %s", scanner.Text())
+		}
+		var line struct {
+			models.ImportResult
+			Summary *models.ImportSummary `json:"summary"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("couldn't decode %s: %v", scanner.Text(), err)
+		}
+		if line.Summary != nil {
+			summary = line.Summary
+			continue
+		}
+		results = append(results, line.ImportResult)
+	}
+	if summary == nil {
+		t.Fatal("response has no summary line")
+	}
+	return results, *summary
+}
+
+func TestImportReceipts(t *testing.T) {
+	post := func(handler *ReceiptHandler, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/receipts/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		handler.ImportReceipts(rr, req)
+		return rr
+	}
+
+	t.Run("Result Per Line", func(t *testing.T) {
+		store := store.NewStore()
+		handler := NewReceiptHandler(store)
+		input := strings.Join([]string{
+			importReceipt,
+			"",
+			`{"retailer": "Target!!!"}`,
+			`not json`,
+			// Like the lines of requests.jsonl, which aren't receipts.
+			`{"request_id": "user-001", "title": "Add a rule", "body": "Please add a rule."}`,
+			strings.Replace(importReceipt, "1.00", "2.00", 2),
+		}, "\n")
+
+		rr := post(handler, "application/x-ndjson", input)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("got %d %s, want 200 application/x-ndjson", rr.Code, rr.Header().Get("Content-Type"))
+		}
+		results, summary := readImport(t, rr.Body)
+
+		wantLines := []int{1, 3, 4, 5, 6}
+		wantStatus := []int{http.StatusOK, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest, http.StatusOK}
+		if len(results) != len(wantLines) {
+			t.Fatalf("got %d results, want %d: %+v", len(results), len(wantLines), results)
+		}
+		for i, result := range results {
+			if result.Line != wantLines[i] || result.Status != wantStatus[i] {
+				t.Errorf("result %d = line %d status %d, want line %d status %d",
+					i, result.Line, result.Status, wantLines[i], wantStatus[i])
+			}
+		}
+		if results[0].ID == "" || results[0].Points == nil || *results[0].Points != 87 {
+			t.Errorf("unexpected result for a valid receipt %+v", results[0])
+		}
+		if results[2].Error == nil || results[2].Error.Type != problemInvalidBody {
+			t.Errorf("expected an invalid body problem for a line that isn't JSON, got %+v", results[2].Error)
+		}
+
+		if summary != (models.ImportSummary{Lines: 5, Accepted: 2, Rejected: 3}) {
+			t.Errorf("unexpected summary %+v", summary)
+		}
+		if records, _ := store.List(); len(records) != 2 {
+			t.Errorf("store holds %d receipts, want 2", len(records))
+		}
+	})
+
+	t.Run("Line Too Long", func(t *testing.T) {
+		long := `{"retailer": "` + strings.Repeat("a", maxImportLine) + `"}`
+		rr := post(NewReceiptHandler(store.NewStore()), "application/x-ndjson", long+"\r\n"+importReceipt+"\r\n")
+		results, summary := readImport(t, rr.Body)
+		if len(results) != 2 || results[0].Status != http.StatusRequestEntityTooLarge || results[1].Status != http.StatusOK {
+			t.Errorf("unexpected results %+v", results)
+		}
+		if summary.Accepted != 1 || summary.Rejected != 1 {
+			t.Errorf("unexpected summary %+v", summary)
+		}
+	})
+
+	t.Run("Empty Input", func(t *testing.T) {
+		rr := post(NewReceiptHandler(store.NewStore()), "application/x-ndjson", "")
+		if results, summary := readImport(t, rr.Body); len(results) != 0 || summary != (models.ImportSummary{}) {
+			t.Errorf("got %+v %+v, want only an empty summary", results, summary)
+		}
+	})
+
+	t.Run("Wrong Content Type", func(t *testing.T) {
+		rr := post(NewReceiptHandler(store.NewStore()), "application/json", "["+importReceipt+"]")
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnsupportedMediaType)
+		}
+	})
+
+	t.Run("Streams While Reading", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(NewReceiptHandler(store.NewStore()).ImportReceipts))
+		defer server.Close()
+
+		// Send one line at a time and wait for its result before sending
+		// the next, which only works if results are streamed back.
+		body, input := io.Pipe()
+		req, _ := http.NewRequest("POST", server.URL, body)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		go input.Write([]byte(importReceipt + "\n"))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("import request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		output := bufio.NewReader(resp.Body)
+
+		for i := 1; i <= 3; i++ {
+			line, err := output.ReadBytes('\n')
+			if err != nil {
+				t.Fatalf("reading result %d: %v", i, err)
+			}
+			var result models.ImportResult
+			if json.Unmarshal(line, &result); result.Line != i || result.Status != http.StatusOK {
+				t.Fatalf("result %d = %s", i, line)
+			}
+			if i < 3 {
+				go input.Write([]byte(strings.Replace(importReceipt, "13:01", fmt.Sprintf("13:0%d", i+1), 1) + "\n"))
+			}
+		}
+		input.Close()
+
+		results, summary := readImport(t, output)
+		if len(results) != 0 || summary.Accepted != 3 {
+			t.Errorf("got %+v %+v after the last result, want a summary of 3 accepted", results, summary)
+		}
+	})
+}
+
+func TestReadLine(t *testing.T) {
+	reader := bufio.NewReaderSize(strings.NewReader("a\n\nbc\r\nlast"), 16)
+	for _, want := range []string{"a", "", "bc\r", "last"} {
+		line, err := readLine(reader)
+		if err != nil || string(line) != want {
+			t.Fatalf("readLine() = %q, %v; want %q", line, err, want)
+		}
+	}
+	if _, err := readLine(reader); err != io.EOF {
+		t.Errorf("readLine() at the end error = %v, want io.EOF", err)
+	}
+
+	long := bytes.Repeat([]byte("x"), maxImportLine+1)
+	reader = bufio.NewReader(io.MultiReader(bytes.NewReader(long), strings.NewReader("\nnext\n")))
+	if _, err := readLine(reader); err != errLineTooLong {
+		t.Errorf("readLine() of a long line error = %v, want errLineTooLong", err)
+	}
+	if line, err := readLine(reader); err != nil || string(line) != "next" {
+		t.Errorf("readLine() after a long line = %q, %v; want next", line, err)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"receipt-processor/internal/models"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// jobQueueSize is how many submissions may wait for a worker before
+	// further ones are turned away.
+	jobQueueSize = 1000
+	// jobRetention is how long a finished job can still be looked up.
+	jobRetention = time.Hour
+)
+
+// WithAsyncWorkers sets how many workers process ?async=true submissions.
+// The default is runtime.GOMAXPROCS(0).
+func WithAsyncWorkers(n int) Option {
+	return func(h *ReceiptHandler) {
+		h.jobs.workers = n
+	}
+}
+
+// job is one asynchronous submission.
+type job struct {
+	receipt models.Receipt
+	// status is guarded by the queue's mutex.
+	status models.JobResponse
+}
+
+// jobQueue holds asynchronous submissions until a worker processes them, and
+// their outcomes for jobRetention after. Workers start with the first job.
+type jobQueue struct {
+	workers int
+	start   sync.Once
+	pending chan *job
+
+	mutex sync.Mutex
+	jobs  map[string]*job
+	// finished holds finished jobs in the order they finished, so expired
+	// ones can be dropped from the front.
+	finished []*job
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{workers: runtime.GOMAXPROCS(0), pending: make(chan *job, jobQueueSize), jobs: make(map[string]*job)}
+}
+
+// enqueue adds a job for receipt and returns its initial status, or false if
+// the queue is full.
+func (h *ReceiptHandler) enqueue(receipt models.Receipt) (models.JobResponse, bool) {
+	q := h.jobs
+	q.start.Do(func() {
+		for n := 0; n < max(q.workers, 1); n++ {
+			go h.work()
+		}
+	})
+
+	job := &job{receipt: receipt, status: models.JobResponse{
+		ID:        uuid.New().String(),
+		Status:    models.JobQueued,
+		CreatedAt: time.Now().UTC(),
+	}}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.expire(job.status.CreatedAt)
+	select {
+	case q.pending <- job:
+	default:
+		return models.JobResponse{}, false
+	}
+	q.jobs[job.status.ID] = job
+	return job.status, true
+}
+
+// work processes queued jobs until the process exits.
+func (h *ReceiptHandler) work() {
+	q := h.jobs
+	for job := range q.pending {
+		q.update(job, func(status *models.JobResponse) {
+			status.Status = models.JobProcessing
+		})
+
+		record, problem := h.submitReceipt(job.receipt)
+
+		q.update(job, func(status *models.JobResponse) {
+			finished := time.Now().UTC()
+			status.FinishedAt = &finished
+			if problem != nil {
+				status.Status, status.Error = models.JobFailed, problem
+				return
+			}
+			points := record.Score.Points
+			status.Status, status.ReceiptID, status.Points, status.Flags = models.JobDone, record.ID, &points, record.Flags
+		})
+	}
+}
+
+// update changes the status of job, recording it as finished once it is.
+func (q *jobQueue) update(job *job, change func(status *models.JobResponse)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	change(&job.status)
+	if job.status.FinishedAt != nil {
+		job.receipt = models.Receipt{}
+		q.finished = append(q.finished, job)
+	}
+}
+
+// get returns the status of the job with the given ID.
+func (q *jobQueue) get(id string) (models.JobResponse, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.expire(time.Now().UTC())
+	job, ok := q.jobs[id]
+	if !ok {
+		return models.JobResponse{}, false
+	}
+	return job.status, true
+}
+
+// expire forgets jobs that finished more than jobRetention before now.
+// Callers must hold mutex.
+func (q *jobQueue) expire(now time.Time) {
+	for len(q.finished) > 0 && now.Sub(*q.finished[0].status.FinishedAt) > jobRetention {
+		delete(q.jobs, q.finished[0].status.ID)
+		q.finished = q.finished[1:]
+	}
+}
+
+// GetJob reports the status of an asynchronous submission.
+func (h *ReceiptHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	status, ok := h.jobs.get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// processAsync queues receipt and answers with its job, which is also the
+// response kept under the request's Idempotency-Key, so a retry finds the same
+// job rather than queueing the receipt again.
+func (h *ReceiptHandler) processAsync(w http.ResponseWriter, request *idempotentRequest, receipt models.Receipt) {
+	status, ok := h.enqueue(receipt)
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		writeProblem(w, models.Problem{
+			Type:   problemQueueFull,
+			Title:  "Too many receipts are waiting to be processed",
+			Status: http.StatusServiceUnavailable,
+		})
+		return
+	}
+
+	encoded, _ := json.Marshal(status)
+	h.finishIdempotent(request, http.StatusAccepted, encoded)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+status.ID)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(append(encoded, '\n'))
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessReceiptAsync(t *testing.T) {
+	post := func(handler *ReceiptHandler, target, body string) (*httptest.ResponseRecorder, models.JobResponse) {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.ProcessReceipt(rr, req)
+		var job models.JobResponse
+		json.Unmarshal(rr.Body.Bytes(), &job)
+		return rr, job
+	}
+	get := func(handler *ReceiptHandler, id string) *httptest.ResponseRecorder {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/jobs/"+id, nil), map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+		handler.GetJob(rr, req)
+		return rr
+	}
+	// wait polls a job until it finishes.
+	wait := func(t *testing.T, handler *ReceiptHandler, id string) models.JobResponse {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			rr := get(handler, id)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("GetJob returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			var job models.JobResponse
+			json.NewDecoder(rr.Body).Decode(&job)
+			if job.Status == models.JobDone || job.Status == models.JobFailed {
+				return job
+			}
+		}
+		t.Fatalf("job %s didn't finish", id)
+		return models.JobResponse{}
+	}
+
+	t.Run("Done", func(t *testing.T) {
+		store := store.NewStore()
+		handler := NewReceiptHandler(store)
+		rr, job := post(handler, "/receipts/process?async=true", importReceipt)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+		}
+		if job.ID == "" || job.Status != models.JobQueued || rr.Header().Get("Location") != "/jobs/"+job.ID {
+			t.Fatalf("unexpected response %+v with Location %q", job, rr.Header().Get("Location"))
+		}
+
+		job = wait(t, handler, job.ID)
+		if job.Status != models.JobDone || job.Points == nil || *job.Points != 87 || job.FinishedAt == nil {
+			t.Fatalf("unexpected finished job %+v", job)
+		}
+		if _, err := store.Get(job.ReceiptID); err != nil {
+			t.Errorf("receipt %s of the job wasn't stored: %v", job.ReceiptID, err)
+		}
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		store := store.NewStore()
+		handler := NewReceiptHandler(store)
+		_, job := post(handler, "/receipts/process?async=true", strings.Replace(importReceipt, `"Target"`, `"Target!!!"`, 1))
+
+		job = wait(t, handler, job.ID)
+		if job.Status != models.JobFailed || job.ReceiptID != "" || job.Error == nil || job.Error.Type != problemInvalidReceipt {
+			t.Fatalf("expected a validation problem, got %+v", job)
+		}
+		if records, _ := store.List(); len(records) != 0 {
+			t.Errorf("store holds %d receipts, want none", len(records))
+		}
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		if rr, _ := post(handler, "/receipts/process?async=soon", importReceipt); rr.Code != http.StatusBadRequest {
+			t.Errorf("invalid async: handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+		// A body that isn't a receipt is turned away before a job is made.
+		if rr, _ := post(handler, "/receipts/process?async=true", "not json"); rr.Code != http.StatusBadRequest {
+			t.Errorf("invalid body: handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+		if rr := get(handler, "unknown"); rr.Code != http.StatusNotFound {
+			t.Errorf("unknown job: handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Retry Finds Same Job", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		send := func() models.JobResponse {
+			req := httptest.NewRequest("POST", "/receipts/process?async=true", strings.NewReader(importReceipt))
+			req.Header.Set("Idempotency-Key", "async-retry")
+			rr := httptest.NewRecorder()
+			handler.ProcessReceipt(rr, req)
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+			}
+			var job models.JobResponse
+			json.Unmarshal(rr.Body.Bytes(), &job)
+			return job
+		}
+		if first, retry := send(), send(); first.ID != retry.ID {
+			t.Errorf("retry got job %s, want %s", retry.ID, first.ID)
+		}
+	})
+
+	t.Run("Queue Full", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		// Keep the workers from starting, so nothing leaves the queue.
+		handler.jobs.start.Do(func() {})
+		for i := 0; i < jobQueueSize; i++ {
+			if _, ok := handler.enqueue(models.Receipt{}); !ok {
+				t.Fatalf("queue full after %d jobs, want %d", i, jobQueueSize)
+			}
+		}
+		rr, _ := post(handler, "/receipts/process?async=true", importReceipt)
+		if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+			t.Errorf("got %d with Retry-After %q, want 503 with Retry-After", rr.Code, rr.Header().Get("Retry-After"))
+		}
+	})
+}
+
+func TestJobQueueExpire(t *testing.T) {
+	q := newJobQueue()
+	now := time.Now().UTC()
+	for _, id := range []string{"old", "recent"} {
+		job := &job{status: models.JobResponse{ID: id, Status: models.JobQueued}}
+		q.jobs[id] = job
+		q.update(job, func(status *models.JobResponse) {
+			finished := now
+			if id == "old" {
+				finished = now.Add(-jobRetention - time.Second)
+			}
+			status.Status, status.FinishedAt = models.JobDone, &finished
+		})
+	}
+	q.jobs["queued"] = &job{status: models.JobResponse{ID: "queued", Status: models.JobQueued}}
+
+	q.expire(now)
+	for id, want := range map[string]bool{"old": false, "recent": true, "queued": true} {
+		if _, ok := q.jobs[id]; ok != want {
+			t.Errorf("job %s kept = %v, want %v", id, ok, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// ListReceipts returns stored receipts matching the query parameters, one
+// page at a time. Pass the returned nextCursor as cursor to get the next page.
+func (h *ReceiptHandler) ListReceipts(w http.ResponseWriter, r *http.Request) {
+	query, errs := parseListQuery(r.URL.Query())
+	if len(errs) > 0 {
+		writeQueryProblem(w, errs)
+		return
+	}
+
+	page, err := h.store.Query(query)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		writeQueryProblem(w, service.ValidationErrors{{
+			Path:    "cursor",
+			Code:    codeInvalidParameter,
+			Message: "cursor belongs to a different sort order",
+		}})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list receipts: %v", err)
+		http.Error(w, "Failed to list receipts", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.ReceiptListResponse{Receipts: make([]models.ReceiptDetailResponse, 0, len(page.Records))}
+	for _, record := range page.Records {
+		response.Receipts = append(response.Receipts, receiptDetail(record))
+	}
+	if page.Next != nil {
+		response.NextCursor = page.Next.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseListQuery reads a store.Query from the GET /receipts parameters,
+// collecting a problem for every parameter that can't be used.
+func parseListQuery(values url.Values) (store.Query, service.ValidationErrors) {
+	query := store.Query{Retailer: values.Get("retailer"), Limit: defaultListLimit}
+	var errs service.ValidationErrors
+	invalid := func(param, format string, args ...any) {
+		errs = append(errs, models.FieldError{Path: param, Code: codeInvalidParameter, Message: fmt.Sprintf(format, args...)})
+	}
+
+	date := func(param string) string {
+		value := values.Get(param)
+		if value == "" {
+			return ""
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			invalid(param, "%s must be a date like 2022-01-31", param)
+		}
+		return value
+	}
+	query.PurchasedFrom = date("purchasedFrom")
+	query.PurchasedTo = date("purchasedTo")
+
+	points := func(param string) *int64 {
+		value := values.Get(param)
+		if value == "" {
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			invalid(param, "%s must be a whole number", param)
+			return nil
+		}
+		return &n
+	}
+	query.MinPoints = points("minPoints")
+	query.MaxPoints = points("maxPoints")
+
+	timestamp := func(param string) time.Time {
+		value := values.Get(param)
+		if value == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			invalid(param, "%s must be an RFC 3339 time like 2022-01-31T13:45:00Z", param)
+		}
+		return t
+	}
+	query.SubmittedFrom = timestamp("submittedFrom")
+	query.SubmittedTo = timestamp("submittedTo")
+
+	if sort := values.Get("sort"); sort != "" {
+		query.Descending = strings.HasPrefix(sort, "-")
+		query.Sort = store.SortField(strings.TrimPrefix(sort, "-"))
+		known := false
+		for _, field := range store.SortFields {
+			known = known || query.Sort == field
+		}
+		if !known {
+			invalid("sort", "sort must be one of %v, optionally prefixed with - for descending order", store.SortFields)
+		}
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			invalid("limit", "limit must be between 1 and %d", maxListLimit)
+		}
+		query.Limit = n
+	}
+
+	if token := values.Get("cursor"); token != "" {
+		cursor, err := store.ParseCursor(token)
+		if err != nil {
+			invalid("cursor", "cursor is not one returned by this endpoint")
+		}
+		query.After = &cursor
+	}
+	return query, errs
+}
+
+func writeQueryProblem(w http.ResponseWriter, errs service.ValidationErrors) {
+	writeProblem(w, models.Problem{
+		Type:   problemInvalidQuery,
+		Title:  "The query parameters are invalid",
+		Status: http.StatusBadRequest,
+		Detail: errs.Error(),
+		Errors: errs,
+	})
+}
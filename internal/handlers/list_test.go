@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listReceipts(t *testing.T, handler *ReceiptHandler, query string) (*httptest.ResponseRecorder, models.ReceiptListResponse) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.ListReceipts(rr, httptest.NewRequest("GET", "/receipts?"+query, nil))
+	var response models.ReceiptListResponse
+	if rr.Code == http.StatusOK {
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("couldn't decode response: %v", err)
+		}
+	}
+	return rr, response
+}
+
+func receiptIDs(response models.ReceiptListResponse) []string {
+	ids := []string{}
+	for _, receipt := range response.Receipts {
+		ids = append(ids, receipt.ID)
+	}
+	return ids
+}
+
+func TestListReceipts(t *testing.T) {
+	s := store.NewStore()
+	submitted := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	for i, retailer := range []string{"Target", "Walgreens", "Target", "Target", "Walgreens"} {
+		s.Save(store.Record{
+			ID: fmt.Sprintf("r%d", i),
+			Receipt: models.Receipt{
+				Retailer:     retailer,
+				PurchaseDate: fmt.Sprintf("2024-04-%02d", 10+i),
+				PurchaseTime: "12:00",
+				Total:        "1.00",
+			},
+			Score:       models.Score{Points: int64(10 * (5 - i))},
+			SubmittedAt: submitted.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	handler := NewReceiptHandler(s)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"Defaults To Submission Order", "", []string{"r0", "r1", "r2", "r3", "r4"}},
+		{"Retailer", "retailer=Target", []string{"r0", "r2", "r3"}},
+		{"Purchase Date Range", "purchasedFrom=2024-04-11&purchasedTo=2024-04-13", []string{"r1", "r2", "r3"}},
+		{"Points Range Sorted By Points", "minPoints=20&maxPoints=40&sort=points", []string{"r3", "r2", "r1"}},
+		{"Descending", "retailer=Walgreens&sort=-purchaseDate", []string{"r4", "r1"}},
+		{
+			"Submission Time Range",
+			"submittedFrom=" + url.QueryEscape("2024-05-01T10:00:00Z") + "&submittedTo=" + url.QueryEscape("2024-05-01T11:00:00Z"),
+			[]string{"r1", "r2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, response := listReceipts(t, handler, tt.query)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+			if got := receiptIDs(response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got receipts %v, want %v", got, tt.want)
+			}
+			if response.NextCursor != "" {
+				t.Errorf("expected no next cursor, got %q", response.NextCursor)
+			}
+		})
+	}
+
+	t.Run("Pagination", func(t *testing.T) {
+		var ids []string
+		query := "sort=-points&limit=2"
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatal("pagination never ended")
+			}
+			_, response := listReceipts(t, handler, query)
+			ids = append(ids, receiptIDs(response)...)
+			if response.NextCursor == "" {
+				break
+			}
+			query = "sort=-points&limit=2&cursor=" + response.NextCursor
+		}
+		if want := []string{"r0", "r1", "r2", "r3", "r4"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("got receipts %v, want %v", ids, want)
+		}
+	})
+
+	t.Run("Full Receipt Details", func(t *testing.T) {
+		_, response := listReceipts(t, handler, "limit=1")
+		got := response.Receipts[0]
+		if got.Retailer != "Target" || got.Points != 50 || got.SubmittedAt == nil || !got.SubmittedAt.Equal(submitted) {
+			t.Errorf("unexpected receipt %+v", got)
+		}
+	})
+
+	t.Run("Invalid Parameters", func(t *testing.T) {
+		rr, _ := listReceipts(t, handler, "purchasedFrom=yesterday&minPoints=ten&sort=retailer&limit=0&cursor=xyz")
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+		var problem models.Problem
+		json.NewDecoder(rr.Body).Decode(&problem)
+		var params []string
+		for _, fieldError := range problem.Errors {
+			params = append(params, fieldError.Path)
+		}
+		want := []string{"purchasedFrom", "minPoints", "sort", "limit", "cursor"}
+		if problem.Type != problemInvalidQuery || !reflect.DeepEqual(params, want) {
+			t.Errorf("got problem %+v, want errors for %v", problem, want)
+		}
+	})
+
+	t.Run("Cursor From Another Sort", func(t *testing.T) {
+		_, response := listReceipts(t, handler, "sort=points&limit=1")
+		rr, _ := listReceipts(t, handler, "sort=purchaseDate&cursor="+response.NextCursor)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Store Failure", func(t *testing.T) {
+		rr, _ := listReceipts(t, NewReceiptHandler(failingStore{}), "")
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+)
+
+// Problem types returned in models.Problem.Type.
+const (
+	problemInvalidReceipt   = "urn:receipt-processor:problem:invalid-receipt"
+	problemInvalidBody      = "urn:receipt-processor:problem:invalid-body"
+	problemInvalidRules     = "urn:receipt-processor:problem:invalid-rules"
+	problemInvalidQuery     = "urn:receipt-processor:problem:invalid-query"
+	problemDuplicate        = "urn:receipt-processor:problem:duplicate-receipt"
+	problemInvalidHeader    = "urn:receipt-processor:problem:invalid-header"
+	problemKeyReused        = "urn:receipt-processor:problem:idempotency-key-reused"
+	problemKeyInUse         = "urn:receipt-processor:problem:idempotency-key-in-use"
+	problemUnsupportedMedia = "urn:receipt-processor:problem:unsupported-media-type"
+	problemQueueFull        = "urn:receipt-processor:problem:queue-full"
+	problemInvalidWebhook   = "urn:receipt-processor:problem:invalid-webhook"
+	problemNotFound         = "urn:receipt-processor:problem:not-found"
+	problemInternal         = "urn:receipt-processor:problem:internal-error"
+	problemUnauthorized     = "urn:receipt-processor:problem:unauthorized"
+)
+
+// codeInvalidParameter is the models.FieldError code for a query parameter
+// that can't be used. Its path is the parameter name.
+const codeInvalidParameter = "invalid_parameter"
+
+// writeProblem sends an RFC 7807 application/problem+json response.
+func writeProblem(w http.ResponseWriter, problem models.Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// writeInvalidBody reports a request body that could not be decoded, with
+// 413 Request Entity Too Large if http.MaxBytesReader cut it off.
+func writeInvalidBody(w http.ResponseWriter, err error) {
+	problem := models.Problem{
+		Type:   problemInvalidBody,
+		Title:  "Invalid request body",
+		Status: http.StatusBadRequest,
+		Detail: err.Error(),
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		problem.Status = http.StatusRequestEntityTooLarge
+		problem.Detail = fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit)
+	}
+	writeProblem(w, problem)
+}
+
+// writeNotFound reports that what the request names doesn't exist.
+func writeNotFound(w http.ResponseWriter, detail string) {
+	writeProblem(w, models.Problem{
+		Type:   problemNotFound,
+		Title:  "Not found",
+		Status: http.StatusNotFound,
+		Detail: detail,
+	})
+}
+
+// writeInternalError reports a failure on the server's side. detail says
+// what failed without exposing the underlying error, which is logged.
+func writeInternalError(w http.ResponseWriter, detail string) {
+	writeProblem(w, models.Problem{
+		Type:   problemInternal,
+		Title:  "Internal server error",
+		Status: http.StatusInternalServerError,
+		Detail: detail,
+	})
+}
+
+// writeValidationProblem reports every validation error found in a receipt.
+func writeValidationProblem(w http.ResponseWriter, err error) {
+	writeProblem(w, validationProblem(err))
+}
+
+func validationProblem(err error) models.Problem {
+	problem := models.Problem{
+		Type:   problemInvalidReceipt,
+		Title:  "The receipt is invalid",
+		Status: http.StatusBadRequest,
+		Detail: err.Error(),
+	}
+	var validationErrors service.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		problem.Errors = validationErrors
+	}
+	return problem
+}
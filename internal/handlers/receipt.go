@@ -2,53 +2,424 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"io"
+	"log"
 	"net/http"
 	"receipt-processor/internal/models"
 	"receipt-processor/internal/service"
 	"receipt-processor/internal/store"
+	"strconv"
+	"time"
 )
 
+// maxReceiptBody bounds the body of a request holding one receipt, which is
+// read into memory whole.
+const maxReceiptBody = 1 << 20
+
 type ReceiptHandler struct {
-	store *store.ReceiptStore
+	store      store.Store
+	scorer     *service.Scorer
+	validator  service.Validator
+	duplicates DuplicatePolicy
+	// idempotencyTTL is how long responses are kept under their
+	// Idempotency-Key, and keys holds the keys of requests in progress.
+	idempotencyTTL time.Duration
+	keys           keyLocks
+	batchWorkers   int
+	jobs           *jobQueue
+	webhooks       *service.Webhooks
+	events         *service.EventStream
+	// submitting serializes duplicate checks with the saves that follow
+	// them, per fingerprint.
+	submitting fingerprintLocks
+}
+
+// Option customizes a ReceiptHandler.
+type Option func(*ReceiptHandler)
+
+// WithRegistry scores receipts with the given rules instead of
+// service.DefaultRegistry.
+func WithRegistry(registry *service.Registry) Option {
+	return func(h *ReceiptHandler) {
+		h.scorer = service.NewScorer(registry, nil)
+	}
+}
+
+// WithScorer scores receipts with whatever rules the scorer has active, so
+// rules reloaded at runtime apply to the next request.
+func WithScorer(scorer *service.Scorer) Option {
+	return func(h *ReceiptHandler) {
+		h.scorer = scorer
+	}
 }
 
-func NewReceiptHandler(store *store.ReceiptStore) *ReceiptHandler {
-	return &ReceiptHandler{store: store}
+// WithValidator enables the validator's cross-field checks on submitted and
+// simulated receipts.
+func WithValidator(validator service.Validator) Option {
+	return func(h *ReceiptHandler) {
+		h.validator = validator
+	}
+}
+
+func NewReceiptHandler(store store.Store, opts ...Option) *ReceiptHandler {
+	h := &ReceiptHandler{
+		store:          store,
+		scorer:         service.NewScorer(service.DefaultRegistry, nil),
+		duplicates:     DuplicateFlag,
+		idempotencyTTL: defaultIdempotencyTTL,
+		batchWorkers:   defaultBatchWorkers(),
+		jobs:           newJobQueue(),
+		events:         service.NewEventStream(service.DefaultEventBuffer),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
+// ProcessReceipt stores a receipt and returns its ID. With ?async=true the
+// receipt is queued instead, and the response is a job to poll at GET
+// /jobs/{id}.
 func (h *ReceiptHandler) ProcessReceipt(w http.ResponseWriter, r *http.Request) {
+	async := false
+	if value := r.URL.Query().Get("async"); value != "" {
+		var err error
+		if async, err = strconv.ParseBool(value); err != nil {
+			writeQueryProblem(w, service.ValidationErrors{{
+				Path:    "async",
+				Code:    codeInvalidParameter,
+				Message: "async must be true or false",
+			}})
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReceiptBody))
+	if err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	request, ok := h.beginIdempotent(w, r, body)
+	if !ok {
+		return
+	}
+	if request != nil {
+		defer request.release()
+	}
+
 	var receipt models.Receipt
-	if err := json.NewDecoder(r.Body).Decode(&receipt); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := json.Unmarshal(body, &receipt); err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+	if async {
+		h.processAsync(w, request, receipt)
 		return
 	}
 
-	if err := service.ValidateReceipt(receipt); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	record, err := h.submit(receipt)
+	var duplicate *duplicateError
+	switch {
+	case errors.As(err, &duplicate):
+		writeProblem(w, duplicateProblem(duplicate))
+		return
+	case errors.As(err, new(service.ValidationErrors)):
+		writeValidationProblem(w, err)
+		return
+	case err != nil:
+		log.Printf("Failed to save receipt: %v", err)
+		http.Error(w, "Failed to save receipt", http.StatusInternalServerError)
 		return
 	}
 
+	encoded, _ := json.Marshal(models.ReceiptResponse{ID: record.ID, Flags: record.Flags})
+	h.finishIdempotent(request, http.StatusOK, encoded)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(encoded, '\n'))
+}
+
+// submit validates, scores and stores a new receipt, applying the duplicate
+// policy, and returns the stored record, which under DuplicateExisting is the
+// one the receipt duplicates. It returns a service.ValidationErrors for an
+// invalid receipt and a *duplicateError for a rejected duplicate.
+func (h *ReceiptHandler) submit(receipt models.Receipt) (store.Record, error) {
+	flags, err := h.validator.Validate(receipt)
+	if err != nil {
+		return store.Record{}, err
+	}
+	score := h.scorer.Score(receipt)
+
+	// Hold the fingerprint from the duplicate check until the receipt is
+	// stored, so two copies submitted at once can't both pass the check.
+	fingerprint := receipt.Fingerprint()
+	defer h.submitting.lock(fingerprint)()
+
+	existing, found, err := h.findDuplicate(fingerprint)
+	if err != nil {
+		return store.Record{}, fmt.Errorf("check for duplicates: %w", err)
+	}
+	if found {
+		switch h.duplicates {
+		case DuplicateReject:
+			return store.Record{}, &duplicateError{existing: existing}
+		case DuplicateExisting:
+			return existing, nil
+		default:
+			flags = append(flags, duplicateFlag(existing))
+		}
+	}
+
 	id := uuid.New().String()
-	points := service.CalculatePoints(receipt)
+	record := store.Record{ID: id, Receipt: receipt, Score: score, SubmittedAt: time.Now().UTC(), Revision: 1, Flags: flags}
+	if err := h.store.Save(record); err != nil {
+		return store.Record{}, fmt.Errorf("save receipt %s: %w", id, err)
+	}
+	h.publishScored(record)
+	h.publishActivity(models.EventReceiptProcessed, record)
+	return record, nil
+}
 
-	h.store.SaveReceipt(id, receipt, points)
+// GetReceipt returns a stored receipt with its points, rule version and
+// submission time.
+func (h *ReceiptHandler) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	record, ok := loadRecord(w, h.store, id)
+	if !ok {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.ReceiptResponse{ID: id})
+	json.NewEncoder(w).Encode(receiptDetail(record))
+}
+
+func receiptDetail(record store.Record) models.ReceiptDetailResponse {
+	response := models.ReceiptDetailResponse{
+		ID:          record.ID,
+		Receipt:     record.Receipt,
+		Points:      record.Score.Points,
+		RuleVersion: record.Score.Version,
+		Revision:    max(record.Revision, 1),
+		Flags:       record.Flags,
+	}
+	if !record.SubmittedAt.IsZero() {
+		response.SubmittedAt = &record.SubmittedAt
+	}
+	if !record.AmendedAt.IsZero() {
+		response.AmendedAt = &record.AmendedAt
+	}
+	if !record.RedactedAt.IsZero() {
+		response.RedactedAt = &record.RedactedAt
+	}
+	return response
+}
+
+// AmendReceipt replaces a stored receipt with a corrected one, validating and
+// scoring it like a new submission. The receipt keeps its ID, and the version
+// it replaces stays in its history.
+func (h *ReceiptHandler) AmendReceipt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var receipt models.Receipt
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReceiptBody)).Decode(&receipt); err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+
+	flags, err := h.validator.Validate(receipt)
+	if err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	score := h.scorer.Score(receipt)
+	record, err := h.store.Amend(store.Record{ID: id, Receipt: receipt, Score: score, AmendedAt: time.Now().UTC(), Flags: flags})
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to amend receipt %s: %v", id, err)
+		http.Error(w, "Failed to amend receipt", http.StatusInternalServerError)
+		return
+	}
+	h.publishScored(record)
+	h.publishActivity(models.EventReceiptAmended, record)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receiptDetail(record))
+}
+
+// GetReceiptHistory returns every revision of a stored receipt, oldest
+// first.
+func (h *ReceiptHandler) GetReceiptHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	history, err := h.store.History(id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load history of receipt %s: %v", id, err)
+		http.Error(w, "Failed to load receipt history", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.ReceiptHistoryResponse{ID: id, Revisions: make([]models.ReceiptDetailResponse, 0, len(history))}
+	for _, record := range history {
+		response.Revisions = append(response.Revisions, receiptDetail(record))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeleteReceipt deletes a stored receipt, or with ?mode=redact wipes its
+// retailer and item descriptions but keeps its points. Either way the action
+// is written to the audit log.
+func (h *ReceiptHandler) DeleteReceipt(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var action models.AuditAction
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "delete":
+		action = models.AuditDelete
+	case "redact":
+		action = models.AuditRedact
+	default:
+		writeQueryProblem(w, service.ValidationErrors{{
+			Path:    "mode",
+			Code:    codeInvalidParameter,
+			Message: "mode must be delete or redact",
+		}})
+		return
+	}
+
+	at := time.Now().UTC()
+	err := h.store.Erase(id, action, at)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to %s receipt %s: %v", action, id, err)
+		http.Error(w, "Failed to "+string(action)+" receipt", http.StatusInternalServerError)
+		return
+	}
+	h.events.Publish(models.ActivityEvent{Type: models.EventReceiptDeleted, ReceiptID: id, Action: action, At: at})
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *ReceiptHandler) GetPoints(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	points, exists := h.store.GetPoints(id)
-	if !exists {
+	record, ok := loadRecord(w, h.store, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PointsResponse{
+		Points:      record.Score.Points,
+		RuleVersion: record.Score.Version,
+	})
+}
+
+func (h *ReceiptHandler) GetPointsBreakdown(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	record, ok := loadRecord(w, h.store, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PointsBreakdownResponse{
+		Points:      record.Score.Points,
+		Breakdown:   record.Score.Breakdown,
+		RuleVersion: record.Score.Version,
+		Flags:       record.Flags,
+	})
+}
+
+// loadRecord fetches a record for a request, writing a 404 or 500 response
+// and returning false if it can't.
+func loadRecord(w http.ResponseWriter, s store.Store, id string) (store.Record, bool) {
+	record, err := s.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
 		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return store.Record{}, false
+	}
+	if err != nil {
+		log.Printf("Failed to load receipt %s: %v", id, err)
+		http.Error(w, "Failed to load receipt", http.StatusInternalServerError)
+		return store.Record{}, false
+	}
+	return record, true
+}
+
+// SimulateReceipt validates and scores a receipt without storing it. When the
+// request carries a rules config the receipt is scored under those rules.
+func (h *ReceiptHandler) SimulateReceipt(w http.ResponseWriter, r *http.Request) {
+	var request models.SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+
+	flags, err := h.validator.Validate(request.Receipt)
+	if err != nil {
+		writeValidationProblem(w, err)
 		return
 	}
 
+	registry := h.scorer.Registry()
+	if len(request.Rules) > 0 {
+		var ok bool
+		if registry, ok = configRegistry(w, request.Rules); !ok {
+			return
+		}
+	}
+
+	score := registry.Score(request.Receipt)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.PointsResponse{Points: points})
+	json.NewEncoder(w).Encode(models.PointsBreakdownResponse{
+		Points:      score.Points,
+		Breakdown:   score.Breakdown,
+		RuleVersion: score.Version,
+		Flags:       flags,
+	})
+}
+
+// configRegistry builds a registry from a JSON rules config sent with a
+// request, followed by the rules added with service.Register, as the server
+// builds its own. So the same config yields the same rule version hash. It
+// writes a 400 problem if the config is invalid.
+func configRegistry(w http.ResponseWriter, rules json.RawMessage) (*service.Registry, bool) {
+	config, err := service.ParseConfig(rules, "json")
+	var registry *service.Registry
+	if err == nil {
+		registry, err = service.NewRegistryFromConfig(config, service.RegisteredRules()...)
+	}
+	if err != nil {
+		writeProblem(w, models.Problem{
+			Type:   problemInvalidRules,
+			Title:  "The rules config is invalid",
+			Status: http.StatusBadRequest,
+			Detail: err.Error(),
+		})
+		return nil, false
+	}
+	return registry, true
 }
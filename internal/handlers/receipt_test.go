@@ -3,12 +3,17 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"net/http"
 	"net/http/httptest"
 	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
 	"receipt-processor/internal/store"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestProcessReceipt(t *testing.T) {
@@ -165,7 +170,10 @@ func TestGetPoints(t *testing.T) {
 
 			// Setup test data if needed
 			if tt.setupID != "" {
-				store.SaveReceipt(tt.setupID, models.Receipt{}, tt.setupPoints)
+				store.SaveReceipt(tt.setupID, models.Receipt{}, models.Score{
+					Points:  tt.setupPoints,
+					Version: models.RuleVersion{Name: "default", Hash: "abc123"},
+				})
 			}
 
 			// Create request with mux vars
@@ -190,7 +198,829 @@ func TestGetPoints(t *testing.T) {
 				if response.Points != tt.expectedPoints {
 					t.Errorf("expected %d points, got %d", tt.expectedPoints, response.Points)
 				}
+				if response.RuleVersion.Name != "default" || response.RuleVersion.Hash != "abc123" {
+					t.Errorf("unexpected rule version %+v", response.RuleVersion)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPointsBreakdown(t *testing.T) {
+	store := store.NewStore()
+	handler := NewReceiptHandler(store)
+
+	breakdown := []models.RuleBreakdown{
+		{Rule: "retailer-name", Description: "One point per character", Points: 6, Inputs: map[string]string{"retailer": "Target"}},
+		{Rule: "odd-day", Description: "6 points for odd days", Points: 6, Inputs: map[string]string{"purchaseDate": "2022-01-01"}},
+	}
+	store.SaveReceipt("test-id-1", models.Receipt{}, models.Score{Points: 12, Breakdown: breakdown})
+
+	t.Run("Existing Receipt", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/receipts/{id}/points/breakdown", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "test-id-1"})
+		rr := httptest.NewRecorder()
+		handler.GetPointsBreakdown(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var response models.PointsBreakdownResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("couldn't decode response: %v", err)
+		}
+		if response.Points != 12 {
+			t.Errorf("expected 12 points, got %d", response.Points)
+		}
+		if len(response.Breakdown) != 2 || response.Breakdown[1].Inputs["purchaseDate"] != "2022-01-01" {
+			t.Errorf("unexpected breakdown %+v", response.Breakdown)
+		}
+	})
+
+	t.Run("Non-existent Receipt", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/receipts/{id}/points/breakdown", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "non-existent"})
+		rr := httptest.NewRecorder()
+		handler.GetPointsBreakdown(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestGetReceipt(t *testing.T) {
+	store := store.NewStore()
+	handler := NewReceiptHandler(store)
+
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+	body, _ := json.Marshal(receipt)
+	rr := httptest.NewRecorder()
+	handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBuffer(body)))
+	var processed models.ReceiptResponse
+	json.NewDecoder(rr.Body).Decode(&processed)
+
+	t.Run("Existing Receipt", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}", nil), map[string]string{"id": processed.ID})
+		rr := httptest.NewRecorder()
+		handler.GetReceipt(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var response models.ReceiptDetailResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("couldn't decode response: %v", err)
+		}
+		if response.ID != processed.ID || response.Retailer != "Target" || len(response.Items) != 1 || response.Total != "6.49" {
+			t.Errorf("unexpected receipt %+v", response)
+		}
+		if response.Points != service.CalculatePoints(receipt) {
+			t.Errorf("expected %d points, got %d", service.CalculatePoints(receipt), response.Points)
+		}
+		if response.RuleVersion != service.DefaultRegistry.Version() {
+			t.Errorf("expected rule version %+v, got %+v", service.DefaultRegistry.Version(), response.RuleVersion)
+		}
+		if response.SubmittedAt == nil || time.Since(*response.SubmittedAt) > time.Minute {
+			t.Errorf("expected a recent submission time, got %v", response.SubmittedAt)
+		}
+	})
+
+	t.Run("Receipt Without Submission Time", func(t *testing.T) {
+		store.SaveReceipt("legacy", receipt, models.Score{Points: 5})
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}", nil), map[string]string{"id": "legacy"})
+		rr := httptest.NewRecorder()
+		handler.GetReceipt(rr, req)
+
+		var response map[string]any
+		json.NewDecoder(rr.Body).Decode(&response)
+		if _, ok := response["submittedAt"]; ok {
+			t.Errorf("expected submittedAt to be omitted, got %v", response["submittedAt"])
+		}
+	})
+
+	t.Run("Non-existent Receipt", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}", nil), map[string]string{"id": "non-existent"})
+		rr := httptest.NewRecorder()
+		handler.GetReceipt(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestAmendReceipt(t *testing.T) {
+	original := models.Receipt{
+		Retailer:     "Targte",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+	corrected := original
+	corrected.Retailer = "Target"
+	corrected.Total = "7.00"
+	correctedJSON, _ := json.Marshal(corrected)
+
+	tests := []struct {
+		name             string
+		id               string
+		body             string
+		expectedCode     int
+		expectedVersions int
+	}{
+		{name: "Corrected Receipt", id: "a", body: string(correctedJSON), expectedCode: http.StatusOK, expectedVersions: 2},
+		{name: "Invalid Receipt", id: "a", body: `{"retailer": "Target!"}`, expectedCode: http.StatusBadRequest, expectedVersions: 1},
+		{name: "Invalid JSON", id: "a", body: `{invalid json}`, expectedCode: http.StatusBadRequest, expectedVersions: 1},
+		{name: "Non-existent Receipt", id: "missing", body: string(correctedJSON), expectedCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := store.NewStore()
+			handler := NewReceiptHandler(store)
+			body, _ := json.Marshal(original)
+			rr := httptest.NewRecorder()
+			handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBuffer(body)))
+			var processed models.ReceiptResponse
+			json.NewDecoder(rr.Body).Decode(&processed)
+			id := tt.id
+			if id == "a" {
+				id = processed.ID
+			}
+
+			req := mux.SetURLVars(httptest.NewRequest("PUT", "/receipts/{id}", bytes.NewBufferString(tt.body)), map[string]string{"id": id})
+			rr = httptest.NewRecorder()
+			handler.AmendReceipt(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if tt.expectedCode == http.StatusOK {
+				var response models.ReceiptDetailResponse
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					t.Fatalf("couldn't decode response: %v", err)
+				}
+				if response.ID != processed.ID || response.Revision != 2 || response.Retailer != "Target" || response.AmendedAt == nil {
+					t.Errorf("unexpected amended receipt %+v", response)
+				}
+				if response.Points != service.CalculatePoints(corrected) {
+					t.Errorf("expected the amendment to be rescored to %d points, got %d", service.CalculatePoints(corrected), response.Points)
+				}
+			}
+			if tt.expectedVersions == 0 {
+				return
+			}
+
+			req = mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}/history", nil), map[string]string{"id": id})
+			rr = httptest.NewRecorder()
+			handler.GetReceiptHistory(rr, req)
+			var history models.ReceiptHistoryResponse
+			json.NewDecoder(rr.Body).Decode(&history)
+			if len(history.Revisions) != tt.expectedVersions {
+				t.Fatalf("expected %d revisions, got %+v", tt.expectedVersions, history)
+			}
+			first := history.Revisions[0]
+			if first.Revision != 1 || first.Retailer != "Targte" || first.Points != service.CalculatePoints(original) {
+				t.Errorf("expected the original submission first, got %+v", first)
+			}
+			for _, revision := range history.Revisions {
+				if revision.SubmittedAt == nil || !revision.SubmittedAt.Equal(*first.SubmittedAt) {
+					t.Errorf("expected every revision to keep the original submission time, got %+v", revision)
+				}
+			}
+		})
+	}
+
+	t.Run("History Of Non-existent Receipt", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}/history", nil), map[string]string{"id": "missing"})
+		rr := httptest.NewRecorder()
+		NewReceiptHandler(store.NewStore()).GetReceiptHistory(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Store Failure", func(t *testing.T) {
+		handler := NewReceiptHandler(failingStore{})
+		req := mux.SetURLVars(httptest.NewRequest("PUT", "/receipts/{id}", bytes.NewBuffer(correctedJSON)), map[string]string{"id": "a"})
+		rr := httptest.NewRecorder()
+		handler.AmendReceipt(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("AmendReceipt returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+		}
+		req = mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}/history", nil), map[string]string{"id": "a"})
+		rr = httptest.NewRecorder()
+		handler.GetReceiptHistory(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("GetReceiptHistory returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+		}
+	})
+}
+
+func TestDeleteReceipt(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	}
+
+	tests := []struct {
+		name           string
+		id             string
+		mode           string
+		expectedCode   int
+		expectedAction models.AuditAction
+	}{
+		{name: "Delete", id: "a", expectedCode: http.StatusNoContent, expectedAction: models.AuditDelete},
+		{name: "Explicit Delete", id: "a", mode: "delete", expectedCode: http.StatusNoContent, expectedAction: models.AuditDelete},
+		{name: "Redact", id: "a", mode: "redact", expectedCode: http.StatusNoContent, expectedAction: models.AuditRedact},
+		{name: "Unknown Mode", id: "a", mode: "shred", expectedCode: http.StatusBadRequest},
+		{name: "Non-existent Receipt", id: "missing", expectedCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := store.NewStore()
+			store.SaveReceipt("a", receipt, service.Score(receipt))
+			handler := NewReceiptHandler(store)
+
+			target := "/receipts/{id}"
+			if tt.mode != "" {
+				target += "?mode=" + tt.mode
+			}
+			req := mux.SetURLVars(httptest.NewRequest("DELETE", target, nil), map[string]string{"id": tt.id})
+			rr := httptest.NewRecorder()
+			handler.DeleteReceipt(rr, req)
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+
+			entries, _ := store.AuditLog("")
+			if tt.expectedAction == "" {
+				if len(entries) != 0 {
+					t.Errorf("expected nothing audited, got %+v", entries)
+				}
+				return
+			}
+			if len(entries) != 1 || entries[0].ReceiptID != "a" || entries[0].Action != tt.expectedAction {
+				t.Errorf("expected one %s audit entry, got %+v", tt.expectedAction, entries)
+			}
+
+			req = mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}", nil), map[string]string{"id": "a"})
+			rr = httptest.NewRecorder()
+			handler.GetReceipt(rr, req)
+			if tt.expectedAction == models.AuditDelete {
+				if rr.Code != http.StatusNotFound {
+					t.Errorf("expected the deleted receipt to be gone, got status %v", rr.Code)
+				}
+				return
+			}
+			var response models.ReceiptDetailResponse
+			json.NewDecoder(rr.Body).Decode(&response)
+			if response.Retailer != "" || response.Items[0].ShortDescription != "" || response.RedactedAt == nil {
+				t.Errorf("expected a redacted receipt, got %+v", response)
+			}
+			if response.Points != service.CalculatePoints(receipt) || response.Items[0].Price != "6.49" {
+				t.Errorf("expected redaction to keep points and prices, got %+v", response)
+			}
+		})
+	}
+
+	t.Run("Store Failure", func(t *testing.T) {
+		req := mux.SetURLVars(httptest.NewRequest("DELETE", "/receipts/{id}", nil), map[string]string{"id": "a"})
+		rr := httptest.NewRecorder()
+		NewReceiptHandler(failingStore{}).DeleteReceipt(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+		}
+	})
+}
+
+func TestSimulateReceipt(t *testing.T) {
+	receipt := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}], "total": "6.49"}`
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedCode   int
+		expectedPoints int64
+		expectedRule   string
+	}{
+		{
+			name:           "Active Rules",
+			body:           `{"receipt": ` + receipt + `}`,
+			expectedCode:   http.StatusOK,
+			expectedPoints: 12,
+			expectedRule:   "default",
+		},
+		{
+			name:           "Alternate Rules",
+			body:           `{"receipt": ` + receipt + `, "rules": {"name": "promo", "rules": {"oddDay": {"points": 100}}}}`,
+			expectedCode:   http.StatusOK,
+			expectedPoints: 106,
+			expectedRule:   "promo",
+		},
+		{
+			name:         "Invalid Rules",
+			body:         `{"receipt": ` + receipt + `, "rules": {"rules": {"oddDay": {"points": -1}}}}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid Receipt",
+			body:         `{"receipt": {"retailer": "Target!!!"}}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid JSON",
+			body:         `{invalid json}`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := store.NewStore()
+			handler := NewReceiptHandler(store)
+
+			req := httptest.NewRequest("POST", "/receipts/simulate", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			handler.SimulateReceipt(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if records, _ := store.List(); len(records) != 0 {
+				t.Errorf("simulation stored %d receipts", len(records))
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response models.PointsBreakdownResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't decode response: %v", err)
+			}
+			if response.Points != tt.expectedPoints {
+				t.Errorf("expected %d points, got %d", tt.expectedPoints, response.Points)
+			}
+			if response.RuleVersion.Name != tt.expectedRule {
+				t.Errorf("expected rule version %q, got %q", tt.expectedRule, response.RuleVersion.Name)
+			}
+			if len(response.Breakdown) == 0 {
+				t.Error("expected a per-rule breakdown")
+			}
+		})
+	}
+}
+
+func TestProcessReceiptProblemDetails(t *testing.T) {
+	store := store.NewStore()
+	handler := NewReceiptHandler(store)
+
+	body := `{"retailer": "Target!!!", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Pepsi", "price": "1.25"}, {"shortDescription": "Dasani", "price": "1.4"}],
+		"total": "2.65"}`
+	req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	handler.ProcessReceipt(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("expected application/problem+json, got %q", contentType)
+	}
+
+	var problem models.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatalf("couldn't decode response: %v", err)
+	}
+	if problem.Status != http.StatusBadRequest || problem.Type == "" || problem.Title == "" {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+	if len(problem.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got %+v", problem.Errors)
+	}
+	if problem.Errors[0].Path != "/retailer" || problem.Errors[1].Path != "/items/1/price" {
+		t.Errorf("unexpected error paths %+v", problem.Errors)
+	}
+	if problem.Errors[1].Code != "invalid_amount" {
+		t.Errorf("expected invalid_amount code, got %q", problem.Errors[1].Code)
+	}
+}
+
+func TestReceiptBodyTooLarge(t *testing.T) {
+	// A receipt padded past maxReceiptBody with a long retailer name.
+	body := `{"retailer": "` + strings.Repeat("A", maxReceiptBody) + `", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Pepsi", "price": "1.00"}], "total": "1.00"}`
+	store := store.NewStore()
+	handler := NewReceiptHandler(store)
+
+	for _, tt := range []struct {
+		name   string
+		handle http.HandlerFunc
+		req    *http.Request
+	}{
+		{"Process", handler.ProcessReceipt, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(body))},
+		{"Amend", handler.AmendReceipt, mux.SetURLVars(httptest.NewRequest("PUT", "/receipts/{id}", strings.NewReader(body)), map[string]string{"id": "a"})},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.handle(rr, tt.req)
+			if rr.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+			}
+			var problem models.Problem
+			json.NewDecoder(rr.Body).Decode(&problem)
+			if problem.Type != problemInvalidBody || problem.Status != http.StatusRequestEntityTooLarge {
+				t.Errorf("unexpected problem %+v", problem)
+			}
+		})
+	}
+	if records, _ := store.List(); len(records) != 0 {
+		t.Errorf("store holds %d receipts, want 0", len(records))
+	}
+}
+
+func TestProcessReceiptConsistency(t *testing.T) {
+	// Items add up to 3.00 but the total claims 500.00.
+	body := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Pepsi", "price": "1.00"}, {"shortDescription": "Dasani", "price": "2.00"}],
+		"total": "500.00"}`
+
+	tests := []struct {
+		name         string
+		mode         service.ConsistencyMode
+		expectedCode int
+		expectedFlag bool
+	}{
+		{name: "Off", mode: service.ConsistencyOff, expectedCode: http.StatusOK},
+		{name: "Reject", mode: service.ConsistencyReject, expectedCode: http.StatusBadRequest},
+		{name: "Flag", mode: service.ConsistencyFlag, expectedCode: http.StatusOK, expectedFlag: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := store.NewStore()
+			handler := NewReceiptHandler(store, WithValidator(service.Validator{Consistency: tt.mode}))
+
+			req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body))
+			rr := httptest.NewRecorder()
+			handler.ProcessReceipt(rr, req)
+
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+			if tt.expectedCode != http.StatusOK {
+				var problem models.Problem
+				json.NewDecoder(rr.Body).Decode(&problem)
+				if len(problem.Errors) != 1 || problem.Errors[0].Code != service.CodeTotalMismatch {
+					t.Errorf("expected a total_mismatch error, got %+v", problem.Errors)
+				}
+				return
+			}
+
+			var response models.ReceiptResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("couldn't decode response: %v", err)
+			}
+			if (len(response.Flags) > 0) != tt.expectedFlag {
+				t.Errorf("unexpected flags %+v", response.Flags)
+			}
+			record, err := store.Get(response.ID)
+			if err != nil {
+				t.Fatalf("receipt was not stored: %v", err)
+			}
+			if (len(record.Flags) > 0) != tt.expectedFlag {
+				t.Errorf("unexpected stored flags %+v", record.Flags)
 			}
 		})
 	}
 }
+
+func TestProcessReceiptDuplicates(t *testing.T) {
+	original := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Pepsi", "price": "1.00"}, {"shortDescription": "Dasani", "price": "2.00"}],
+		"total": "3.00"}`
+	// The same purchase typed in differently.
+	resubmitted := `{"retailer": "  TARGET ", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "dasani", "price": "2.00"}, {"shortDescription": "Pepsi", "price": "1.00"}],
+		"total": "3.00"}`
+
+	tests := []struct {
+		name         string
+		policy       DuplicatePolicy
+		expectedCode int
+		sameID       bool
+		stored       int
+	}{
+		{name: "Reject", policy: DuplicateReject, expectedCode: http.StatusConflict, stored: 1},
+		{name: "Existing", policy: DuplicateExisting, expectedCode: http.StatusOK, sameID: true, stored: 1},
+		{name: "Flag", policy: DuplicateFlag, expectedCode: http.StatusOK, stored: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := store.NewStore()
+			handler := NewReceiptHandler(store, WithDuplicatePolicy(tt.policy))
+
+			rr := httptest.NewRecorder()
+			handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(original)))
+			var first models.ReceiptResponse
+			json.NewDecoder(rr.Body).Decode(&first)
+			if rr.Code != http.StatusOK || len(first.Flags) != 0 {
+				t.Fatalf("first submission = %d %+v, want 200 without flags", rr.Code, first)
+			}
+
+			rr = httptest.NewRecorder()
+			handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(resubmitted)))
+			if rr.Code != tt.expectedCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedCode)
+			}
+
+			switch tt.policy {
+			case DuplicateReject:
+				var problem models.Problem
+				json.NewDecoder(rr.Body).Decode(&problem)
+				if problem.Type != problemDuplicate || problem.ExistingID != first.ID {
+					t.Errorf("unexpected problem %+v, want a duplicate of %s", problem, first.ID)
+				}
+			default:
+				var second models.ReceiptResponse
+				json.NewDecoder(rr.Body).Decode(&second)
+				if (second.ID == first.ID) != tt.sameID {
+					t.Errorf("second submission got ID %s, first got %s", second.ID, first.ID)
+				}
+				if tt.policy == DuplicateFlag {
+					if len(second.Flags) != 1 || second.Flags[0].Code != codeDuplicateReceipt {
+						t.Errorf("expected a duplicate_receipt flag, got %+v", second.Flags)
+					}
+					record, _ := store.Get(second.ID)
+					if len(record.Flags) != 1 {
+						t.Errorf("flag was not stored: %+v", record.Flags)
+					}
+				}
+			}
+
+			if records, _ := store.List(); len(records) != tt.stored {
+				t.Errorf("store holds %d receipts, want %d", len(records), tt.stored)
+			}
+		})
+	}
+
+	t.Run("Redacted Receipt Is Not A Duplicate", func(t *testing.T) {
+		store := store.NewStore()
+		handler := NewReceiptHandler(store, WithDuplicatePolicy(DuplicateReject))
+
+		rr := httptest.NewRecorder()
+		handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(original)))
+		var first models.ReceiptResponse
+		json.NewDecoder(rr.Body).Decode(&first)
+		store.Erase(first.ID, models.AuditRedact, time.Now())
+
+		rr = httptest.NewRecorder()
+		handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(original)))
+		if rr.Code != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("Concurrent Copies Store One", func(t *testing.T) {
+		store := store.NewStore()
+		handler := NewReceiptHandler(store, WithDuplicatePolicy(DuplicateReject))
+
+		var wg sync.WaitGroup
+		for _, body := range []string{original, resubmitted, original, resubmitted, original, resubmitted} {
+			wg.Add(1)
+			go func(body string) {
+				defer wg.Done()
+				handler.ProcessReceipt(httptest.NewRecorder(), httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body)))
+			}(body)
+		}
+		wg.Wait()
+		if records, _ := store.List(); len(records) != 1 {
+			t.Errorf("store holds %d receipts, want 1", len(records))
+		}
+	})
+
+	t.Run("Other Receipts Don't Wait", func(t *testing.T) {
+		// Hold the first save until another receipt has been stored, which
+		// only finishes if that receipt's submission isn't queued behind it.
+		other := strings.Replace(original, "Target", "Walgreens", 1)
+		saving, release := make(chan struct{}), make(chan struct{})
+		s := slowStore{ReceiptStore: store.NewStore(), beforeSave: func(record store.Record) {
+			if record.Receipt.Retailer == "Target" {
+				close(saving)
+				<-release
+			}
+		}}
+		handler := NewReceiptHandler(s, WithDuplicatePolicy(DuplicateReject))
+
+		done := make(chan int, 2)
+		go func() {
+			rr := httptest.NewRecorder()
+			handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(original)))
+			done <- rr.Code
+		}()
+		<-saving
+		go func() {
+			rr := httptest.NewRecorder()
+			handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(other)))
+			done <- rr.Code
+		}()
+		select {
+		case code := <-done:
+			if code != http.StatusOK {
+				t.Errorf("other receipt got %d, want 200", code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("other receipt waited for the first one's save")
+		}
+		close(release)
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("first receipt got %d, want 200", code)
+		}
+	})
+}
+
+// slowStore runs beforeSave ahead of every Save, to hold a submission in the
+// middle of storing its receipt.
+type slowStore struct {
+	*store.ReceiptStore
+	beforeSave func(record store.Record)
+}
+
+func (s slowStore) Save(record store.Record) error {
+	s.beforeSave(record)
+	return s.ReceiptStore.Save(record)
+}
+
+func TestProcessReceiptIdempotency(t *testing.T) {
+	body := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Pepsi", "price": "1.00"}], "total": "1.00"}`
+	other := `{"retailer": "Walgreens", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"items": [{"shortDescription": "Pepsi", "price": "1.00"}], "total": "1.00"}`
+
+	post := func(handler *ReceiptHandler, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/receipts/process", bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ProcessReceipt(rr, req)
+		return rr
+	}
+	id := func(rr *httptest.ResponseRecorder) string {
+		var response models.ReceiptResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		return response.ID
+	}
+	problemType := func(rr *httptest.ResponseRecorder) string {
+		var problem models.Problem
+		json.NewDecoder(rr.Body).Decode(&problem)
+		return problem.Type
+	}
+
+	t.Run("Retry Returns Original Response", func(t *testing.T) {
+		store := store.NewStore()
+		handler := NewReceiptHandler(store)
+
+		first := post(handler, "key-1", body)
+		retry := post(handler, "key-1", body)
+		if first.Code != http.StatusOK || retry.Code != http.StatusOK {
+			t.Fatalf("got status codes %d and %d, want 200", first.Code, retry.Code)
+		}
+		if retry.Header().Get("Idempotent-Replayed") != "true" {
+			t.Error("retry was not marked as replayed")
+		}
+		if a, b := id(first), id(retry); a == "" || a != b {
+			t.Errorf("retry got ID %q, want the original %q", b, a)
+		}
+		if records, _ := store.List(); len(records) != 1 {
+			t.Errorf("store holds %d receipts, want 1", len(records))
+		}
+	})
+
+	t.Run("Same Key Different Body", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		post(handler, "key-1", body)
+		rr := post(handler, "key-1", other)
+		if rr.Code != http.StatusUnprocessableEntity || problemType(rr) != problemKeyReused {
+			t.Errorf("got %d %s, want 422 %s", rr.Code, rr.Body, problemKeyReused)
+		}
+	})
+
+	t.Run("Keys Are Independent", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore(), WithDuplicatePolicy(DuplicateReject))
+		post(handler, "key-1", body)
+		if rr := post(handler, "key-2", body); rr.Code != http.StatusConflict {
+			t.Errorf("a new key for the same receipt got %d, want the duplicate check's 409", rr.Code)
+		}
+		if rr := post(handler, "", other); rr.Code != http.StatusOK {
+			t.Errorf("a request without a key got %d, want 200", rr.Code)
+		}
+	})
+
+	t.Run("Failed Requests Are Not Saved", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		if rr := post(handler, "key-1", `{"retailer": "Target!!!"}`); rr.Code != http.StatusBadRequest {
+			t.Fatalf("got %d, want 400", rr.Code)
+		}
+		if rr := post(handler, "key-1", body); rr.Code != http.StatusOK || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("got %d replayed=%q, want a fresh 200", rr.Code, rr.Header().Get("Idempotent-Replayed"))
+		}
+	})
+
+	t.Run("Expired Key", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore(), WithIdempotencyTTL(time.Nanosecond))
+		first := id(post(handler, "key-1", body))
+		time.Sleep(time.Millisecond)
+		if second := id(post(handler, "key-1", other)); second == "" || second == first {
+			t.Errorf("expired key got ID %q, want a new receipt", second)
+		}
+	})
+
+	t.Run("Request In Progress", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		release, _ := handler.keys.acquire("key-1")
+		rr := post(handler, "key-1", body)
+		release()
+		if rr.Code != http.StatusConflict || problemType(rr) != problemKeyInUse {
+			t.Errorf("got %d %s, want 409 %s", rr.Code, rr.Body, problemKeyInUse)
+		}
+		if rr := post(handler, "key-1", body); rr.Code != http.StatusOK {
+			t.Errorf("got %d after the key was released, want 200", rr.Code)
+		}
+	})
+
+	t.Run("Key Too Long", func(t *testing.T) {
+		handler := NewReceiptHandler(store.NewStore())
+		rr := post(handler, strings.Repeat("k", 256), body)
+		if rr.Code != http.StatusBadRequest || problemType(rr) != problemInvalidHeader {
+			t.Errorf("got %d %s, want 400 %s", rr.Code, rr.Body, problemInvalidHeader)
+		}
+	})
+}
+
+// failingStore is a store.Store whose every operation fails.
+type failingStore struct{}
+
+func (failingStore) Save(store.Record) error          { return errors.New("disk full") }
+func (failingStore) Get(string) (store.Record, error) { return store.Record{}, errors.New("disk full") }
+func (failingStore) List() ([]store.Record, error)    { return nil, errors.New("disk full") }
+func (failingStore) Delete(string) error              { return errors.New("disk full") }
+func (failingStore) Query(store.Query) (store.Page, error) {
+	return store.Page{}, errors.New("disk full")
+}
+func (failingStore) Erase(string, models.AuditAction, time.Time) error {
+	return errors.New("disk full")
+}
+func (failingStore) AuditLog(string) ([]models.AuditEntry, error) {
+	return nil, errors.New("disk full")
+}
+func (failingStore) Amend(store.Record) (store.Record, error) {
+	return store.Record{}, errors.New("disk full")
+}
+func (failingStore) Rescore(string, int, models.Score) (store.Record, error) {
+	return store.Record{}, errors.New("disk full")
+}
+func (failingStore) History(string) ([]store.Record, error) { return nil, errors.New("disk full") }
+func (failingStore) FindByFingerprint(string) (store.Record, error) {
+	return store.Record{}, errors.New("disk full")
+}
+func (failingStore) SaveIdempotencyKey(store.IdempotencyKey) error { return errors.New("disk full") }
+func (failingStore) GetIdempotencyKey(string, time.Time) (store.IdempotencyKey, error) {
+	return store.IdempotencyKey{}, errors.New("disk full")
+}
+
+func TestStoreFailures(t *testing.T) {
+	handler := NewReceiptHandler(failingStore{})
+
+	body, _ := json.Marshal(models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []models.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "6.49",
+	})
+	rr := httptest.NewRecorder()
+	handler.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("ProcessReceipt returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/receipts/{id}/points", nil), map[string]string{"id": "test-id-1"})
+	rr = httptest.NewRecorder()
+	handler.GetPoints(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("GetPoints returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"time"
+)
+
+// WithWebhooks publishes a receipt.scored event to webhooks whenever a
+// receipt is stored or amended.
+func WithWebhooks(webhooks *service.Webhooks) Option {
+	return func(h *ReceiptHandler) {
+		h.webhooks = webhooks
+	}
+}
+
+// publishScored tells webhooks that record was scored.
+func (h *ReceiptHandler) publishScored(record store.Record) {
+	if h.webhooks == nil {
+		return
+	}
+	h.webhooks.Publish(models.ReceiptEvent{
+		ID:        uuid.New().String(),
+		Type:      models.EventReceiptScored,
+		CreatedAt: time.Now().UTC(),
+		Data: models.ReceiptEventData{
+			ReceiptID:   record.ID,
+			Revision:    max(record.Revision, 1),
+			Points:      record.Score.Points,
+			RuleVersion: record.Score.Version,
+			Flags:       record.Flags,
+		},
+	})
+}
+
+// WebhookHandler serves the webhook subscription API.
+type WebhookHandler struct {
+	webhooks *service.Webhooks
+}
+
+func NewWebhookHandler(webhooks *service.Webhooks) *WebhookHandler {
+	return &WebhookHandler{webhooks: webhooks}
+}
+
+// CreateWebhook subscribes a URL to events. The response is the only one
+// that includes the signing secret.
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var request models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeInvalidBody(w, err)
+		return
+	}
+
+	webhook, err := h.webhooks.Subscribe(request)
+	var validationErrors service.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		writeProblem(w, models.Problem{
+			Type:   problemInvalidWebhook,
+			Title:  "The webhook is invalid",
+			Status: http.StatusBadRequest,
+			Detail: err.Error(),
+			Errors: validationErrors,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create webhook: %v", err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/webhooks/"+webhook.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// ListWebhooks lists the subscriptions, oldest first.
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.WebhookListResponse{Webhooks: h.webhooks.Webhooks()})
+}
+
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	webhook, err := h.webhooks.Webhook(id)
+	if err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// DeleteWebhook unsubscribes a webhook. Its delivery log and dead letters
+// are deleted with it.
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := h.webhooks.Unsubscribe(id); err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries lists the latest delivery attempts to a webhook,
+// oldest first.
+func (h *WebhookHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	deliveries, err := h.webhooks.Deliveries(id)
+	if err != nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.WebhookDeliveriesResponse{Deliveries: deliveries})
+}
+
+// ListDeadLetters lists the events that couldn't be delivered, oldest first.
+// The webhookId parameter limits them to one webhook.
+func (h *WebhookHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters := h.webhooks.DeadLetters(r.URL.Query().Get("webhookId"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.DeadLettersResponse{DeadLetters: letters})
+}
+
+// RedeliverDeadLetter queues a dead letter's event for delivery again.
+func (h *WebhookHandler) RedeliverDeadLetter(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	err := h.webhooks.Redeliver(id)
+	switch {
+	case errors.Is(err, service.ErrDeadLetterNotFound):
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrWebhookNotFound):
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrWebhookQueueFull):
+		w.Header().Set("Retry-After", "1")
+		writeProblem(w, models.Problem{
+			Type:   problemQueueFull,
+			Title:  "Too many webhook deliveries are waiting",
+			Status: http.StatusServiceUnavailable,
+		})
+	case err != nil:
+		log.Printf("Failed to redeliver %s: %v", id, err)
+		http.Error(w, "Failed to redeliver", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
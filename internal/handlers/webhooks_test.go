@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
+	"receipt-processor/internal/store"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	webhooks := service.NewWebhooks(nil, service.RetryPolicy{Attempts: 1, Backoff: time.Millisecond}, service.AllowPrivateWebhooks())
+	handler := NewWebhookHandler(webhooks)
+	router := mux.NewRouter()
+	router.HandleFunc("/webhooks", handler.CreateWebhook).Methods("POST")
+	router.HandleFunc("/webhooks", handler.ListWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters", handler.ListDeadLetters).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters/{id}/redeliver", handler.RedeliverDeadLetter).Methods("POST")
+	router.HandleFunc("/webhooks/{id}", handler.GetWebhook).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", handler.DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", handler.GetWebhookDeliveries).Methods("GET")
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		rr := serve("POST", "/webhooks", `{"url": "https://example.com/hook"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		var created models.Webhook
+		json.NewDecoder(rr.Body).Decode(&created)
+		if created.Secret == "" || rr.Header().Get("Location") != "/webhooks/"+created.ID {
+			t.Errorf("unexpected webhook %+v with Location %q", created, rr.Header().Get("Location"))
+		}
+
+		rr = serve("GET", "/webhooks/"+created.ID, "")
+		var fetched models.Webhook
+		json.NewDecoder(rr.Body).Decode(&fetched)
+		if rr.Code != http.StatusOK || fetched.ID != created.ID || fetched.Secret != "" {
+			t.Errorf("GET returned %d %+v, want the webhook without its secret", rr.Code, fetched)
+		}
+		serve("DELETE", "/webhooks/"+created.ID, "")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		rr := serve("POST", "/webhooks", `{"url": "not a url", "events": ["receipt.eaten"]}`)
+		var problem models.Problem
+		json.NewDecoder(rr.Body).Decode(&problem)
+		if rr.Code != http.StatusBadRequest || problem.Type != problemInvalidWebhook || len(problem.Errors) != 2 {
+			t.Errorf("got %d %+v, want an invalid webhook problem naming both fields", rr.Code, problem)
+		}
+		if rr := serve("POST", "/webhooks", `[`); rr.Code != http.StatusBadRequest {
+			t.Errorf("malformed body: handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		for _, request := range [][2]string{
+			{"GET", "/webhooks/unknown"},
+			{"DELETE", "/webhooks/unknown"},
+			{"GET", "/webhooks/unknown/deliveries"},
+			{"POST", "/webhooks/dead-letters/unknown/redeliver"},
+		} {
+			if rr := serve(request[0], request[1], ""); rr.Code != http.StatusNotFound {
+				t.Errorf("%s %s returned %d, want 404", request[0], request[1], rr.Code)
+			}
+		}
+	})
+
+	t.Run("Receipt Scored", func(t *testing.T) {
+		received := make(chan []byte, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- body
+			w.WriteHeader(http.StatusGone)
+		}))
+		defer receiver.Close()
+
+		var hook models.Webhook
+		json.NewDecoder(serve("POST", "/webhooks", `{"url": "`+receiver.URL+`"}`).Body).Decode(&hook)
+		defer serve("DELETE", "/webhooks/"+hook.ID, "")
+
+		receipts := NewReceiptHandler(store.NewStore(), WithWebhooks(webhooks))
+		rr := httptest.NewRecorder()
+		receipts.ProcessReceipt(rr, httptest.NewRequest("POST", "/receipts/process", strings.NewReader(importReceipt)))
+		var response models.ReceiptResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+
+		var event models.ReceiptEvent
+		select {
+		case body := <-received:
+			json.Unmarshal(body, &event)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook delivery")
+		}
+		if event.Type != models.EventReceiptScored || event.Data.ReceiptID != response.ID || event.Data.Points != 87 {
+			t.Errorf("unexpected event %+v for receipt %s", event, response.ID)
+		}
+
+		// The receiver refused it and the policy allows one attempt, so the
+		// event is dead-lettered.
+		var letters models.DeadLettersResponse
+		for deadline := time.Now().Add(5 * time.Second); len(letters.DeadLetters) == 0; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("no dead letter after 5s")
+			}
+			json.NewDecoder(serve("GET", "/webhooks/dead-letters?webhookId="+hook.ID, "").Body).Decode(&letters)
+		}
+		var deliveries models.WebhookDeliveriesResponse
+		json.NewDecoder(serve("GET", "/webhooks/"+hook.ID+"/deliveries", "").Body).Decode(&deliveries)
+		if len(deliveries.Deliveries) != 1 || deliveries.Deliveries[0].StatusCode != http.StatusGone {
+			t.Errorf("unexpected delivery log %+v", deliveries)
+		}
+
+		if rr := serve("POST", "/webhooks/dead-letters/"+letters.DeadLetters[0].ID+"/redeliver", ""); rr.Code != http.StatusAccepted {
+			t.Errorf("redeliver returned %d, want 202", rr.Code)
+		}
+		<-received
+	})
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Event types of the GET /events stream.
+const (
+	EventReceiptProcessed = "receipt.processed"
+	EventReceiptAmended   = "receipt.amended"
+	EventReceiptRescored  = "receipt.rescored"
+	EventReceiptDeleted   = "receipt.deleted"
+)
+
+// ActivityEvent reports a change to a stored receipt on the GET /events
+// stream. Processed, amended and rescored events carry the receipt's score; deleted
+// events only carry its ID and whether it was deleted or redacted. Events
+// are buffered for clients that reconnect, so they hold nothing a deletion
+// or redaction would erase.
+type ActivityEvent struct {
+	Type      string       `json:"type"`
+	ReceiptID string       `json:"receiptId"`
+	Revision  int          `json:"revision,omitempty"`
+	Points    *int64       `json:"points,omitempty"`
+	Flags     []FieldError `json:"flags,omitempty"`
+	Action    AuditAction  `json:"action,omitempty"`
+	At        time.Time    `json:"at"`
+}
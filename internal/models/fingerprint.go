@@ -0,0 +1,62 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Fingerprint identifies a purchase independently of how its receipt was
+// typed in. Two receipts share a fingerprint when they have the same
+// retailer, date, time, items and total after normalizing: names are
+// compared case-insensitively with runs of whitespace collapsed, amounts by
+// value, and items in any order.
+func (r Receipt) Fingerprint() string {
+	type item struct {
+		Description string `json:"d"`
+		Price       string `json:"p"`
+	}
+	canonical := struct {
+		Retailer string `json:"r"`
+		Date     string `json:"d"`
+		Time     string `json:"t"`
+		Items    []item `json:"i"`
+		Total    string `json:"x"`
+	}{
+		Retailer: normalizeName(r.Retailer),
+		Date:     strings.TrimSpace(r.PurchaseDate),
+		Time:     strings.TrimSpace(r.PurchaseTime),
+		Items:    make([]item, len(r.Items)),
+		Total:    normalizeAmount(r.Total),
+	}
+	for i, it := range r.Items {
+		canonical.Items[i] = item{Description: normalizeName(it.ShortDescription), Price: normalizeAmount(it.Price)}
+	}
+	sort.Slice(canonical.Items, func(i, j int) bool {
+		a, b := canonical.Items[i], canonical.Items[j]
+		if a.Description != b.Description {
+			return a.Description < b.Description
+		}
+		return a.Price < b.Price
+	})
+
+	data, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeName(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// normalizeAmount formats a parseable amount canonically and leaves anything
+// else as written.
+func normalizeAmount(s string) string {
+	s = strings.TrimSpace(s)
+	if money, err := ParseMoney(s); err == nil {
+		return money.String()
+	}
+	return s
+}
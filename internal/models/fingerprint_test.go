@@ -0,0 +1,59 @@
+package models
+
+import "testing"
+
+func TestReceiptFingerprint(t *testing.T) {
+	base := Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Items: []Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Klarbrunn 12-PK 12 FL OZ", Price: "12.00"},
+		},
+		Total: "14.25",
+	}
+	fingerprint := base.Fingerprint()
+
+	same := map[string]func(r *Receipt){
+		"retailer case and spacing": func(r *Receipt) { r.Retailer = "  m&m   corner MARKET " },
+		"item order": func(r *Receipt) {
+			r.Items = []Item{r.Items[1], r.Items[0]}
+		},
+		"description spacing": func(r *Receipt) { r.Items[1].ShortDescription = "Klarbrunn 12-PK  12 FL OZ " },
+		"leading zero":        func(r *Receipt) { r.Items[0].Price = "02.25" },
+	}
+	for name, change := range same {
+		t.Run(name, func(t *testing.T) {
+			r := base
+			r.Items = append([]Item{}, base.Items...)
+			change(&r)
+			if got := r.Fingerprint(); got != fingerprint {
+				t.Errorf("Fingerprint() = %s, want %s", got, fingerprint)
+			}
+		})
+	}
+
+	different := map[string]func(r *Receipt){
+		"retailer":     func(r *Receipt) { r.Retailer = "Target" },
+		"date":         func(r *Receipt) { r.PurchaseDate = "2022-03-21" },
+		"time":         func(r *Receipt) { r.PurchaseTime = "14:34" },
+		"total":        func(r *Receipt) { r.Total = "14.26" },
+		"item price":   func(r *Receipt) { r.Items[0].Price = "2.26" },
+		"extra item":   func(r *Receipt) { r.Items = append(r.Items, Item{ShortDescription: "Gatorade", Price: "2.25"}) },
+		"missing item": func(r *Receipt) { r.Items = r.Items[:1] },
+		"swapped prices": func(r *Receipt) {
+			r.Items[0].Price, r.Items[1].Price = r.Items[1].Price, r.Items[0].Price
+		},
+	}
+	for name, change := range different {
+		t.Run(name, func(t *testing.T) {
+			r := base
+			r.Items = append([]Item{}, base.Items...)
+			change(&r)
+			if got := r.Fingerprint(); got == fingerprint {
+				t.Errorf("Fingerprint() unchanged after changing the %s", name)
+			}
+		})
+	}
+}
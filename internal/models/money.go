@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount of money in cents. Receipt totals and prices are
+// parsed into Money so the scoring rules never see float rounding error.
+type Money int64
+
+var moneyPattern = regexp.MustCompile(`^\d+\.\d{2}$`)
+
+// ParseMoney parses a dollar amount with exactly two decimal places, such as
+// "6.49", into cents.
+func ParseMoney(s string) (Money, error) {
+	if !moneyPattern.MatchString(s) {
+		return 0, fmt.Errorf("invalid amount %q: want dollars and two-digit cents, like 6.49", s)
+	}
+	cents, err := strconv.ParseInt(strings.Replace(s, ".", "", 1), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	return Money(cents), nil
+}
+
+// Cents returns the amount in cents.
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// String formats the amount the way receipts write it, such as "6.49".
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// IsRoundDollar reports whether the amount has no cents.
+func (m Money) IsRoundDollar() bool {
+	return m%100 == 0
+}
+
+// IsMultipleOf reports whether the amount is a whole multiple of unit.
+func (m Money) IsMultipleOf(unit Money) bool {
+	return unit != 0 && m%unit == 0
+}
+
+// CeilDollars multiplies the amount by factor and rounds the result up to a
+// whole number of dollars. The product is computed exactly.
+func (m Money) CeilDollars(factor *big.Rat) int64 {
+	product := new(big.Rat).Mul(new(big.Rat).SetFrac64(int64(m), 100), factor)
+	quotient, remainder := new(big.Int).QuoRem(product.Num(), product.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient.Int64()
+}
+
+// DecimalFactor converts a multiplier read from config, such as 0.2, to the
+// exact decimal it was written as rather than its nearest binary float.
+func DecimalFactor(f float64) *big.Rat {
+	factor, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return factor
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+	"testing/quick"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Money
+		wantErr bool
+	}{
+		{input: "6.49", want: 649},
+		{input: "0.00", want: 0},
+		{input: "35.00", want: 3500},
+		{input: "1234567.89", want: 123456789},
+		{input: "1", wantErr: true},
+		{input: "1.5", wantErr: true},
+		{input: "1.005", wantErr: true},
+		{input: "-1.00", wantErr: true},
+		{input: ".99", wantErr: true},
+		{input: "invalid", wantErr: true},
+		{input: "99999999999999999999.99", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseMoney(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMoney(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyProperties(t *testing.T) {
+	config := &quick.Config{MaxCount: 10000}
+
+	t.Run("String Round Trips Through ParseMoney", func(t *testing.T) {
+		roundTrip := func(cents uint32) bool {
+			m := Money(cents)
+			parsed, err := ParseMoney(m.String())
+			return err == nil && parsed == m
+		}
+		if err := quick.Check(roundTrip, config); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Round Dollar Matches Float Suffix Check", func(t *testing.T) {
+		// The original rule checked for a ".00" suffix on the string.
+		matches := func(cents uint32) bool {
+			s := Money(cents).String()
+			return Money(cents).IsRoundDollar() == (s[len(s)-3:] == ".00")
+		}
+		if err := quick.Check(matches, config); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Quarter Multiple Matches Float Mod", func(t *testing.T) {
+		// The original rule computed math.Mod(total*100, 25) on a float64.
+		// Quarters are exact in binary, so both agree on every amount.
+		matches := func(cents uint32) bool {
+			m := Money(cents)
+			total, _ := strconv.ParseFloat(m.String(), 64)
+			return m.IsMultipleOf(25) == (math.Mod(total*100, 25) == 0)
+		}
+		if err := quick.Check(matches, config); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("CeilDollars Is Exact", func(t *testing.T) {
+		// Compare against ceil(cents * numerator / (100 * denominator)) in
+		// integer arithmetic.
+		exact := func(cents uint32, numerator uint8, denominator uint8) bool {
+			if denominator == 0 {
+				return true
+			}
+			m := Money(cents)
+			factor := big.NewRat(int64(numerator), int64(denominator))
+			scaled := int64(cents) * int64(numerator)
+			divisor := int64(100) * int64(denominator)
+			want := scaled / divisor
+			if scaled%divisor != 0 {
+				want++
+			}
+			return m.CeilDollars(factor) == want
+		}
+		if err := quick.Check(exact, config); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestCeilDollarsAvoidsFloatRounding(t *testing.T) {
+	// 50.00 * 1.1 is 55.00000000000001 in float64, which math.Ceil rounds up
+	// to 56. The exact product is 55.
+	price, _ := ParseMoney("50.00")
+	if got := price.CeilDollars(DecimalFactor(1.1)); got != 55 {
+		t.Errorf("CeilDollars() = %d, want 55", got)
+	}
+
+	floatPrice, _ := strconv.ParseFloat("50.00", 64)
+	multiplier, _ := strconv.ParseFloat("1.1", 64)
+	if float := int64(math.Ceil(floatPrice * multiplier)); float != 56 {
+		t.Fatalf("expected float arithmetic to round 50.00 * 1.1 up to 56, got %d", float)
+	}
+}
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Item struct {
 	ShortDescription string `json:"shortDescription"`
 	Price            string `json:"price"`
@@ -13,10 +18,241 @@ type Receipt struct {
 	Total        string `json:"total"`
 }
 
+// SimulateRequest is a receipt to score without storing. Rules optionally
+// holds a JSON rules config to score it with instead of the active rules.
+type SimulateRequest struct {
+	Receipt Receipt         `json:"receipt"`
+	Rules   json.RawMessage `json:"rules,omitempty"`
+}
+
 type ReceiptResponse struct {
-	ID string `json:"id"`
+	ID    string       `json:"id"`
+	Flags []FieldError `json:"flags,omitempty"`
+}
+
+// BatchResult is the outcome of one receipt of a batch, at the same index
+// as the receipt. Status is the HTTP status the receipt would have been
+// answered with on its own. A stored receipt has ID and Points; a rejected
+// one has Error instead.
+type BatchResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	ID     string       `json:"id,omitempty"`
+	Points *int64       `json:"points,omitempty"`
+	Flags  []FieldError `json:"flags,omitempty"`
+	Error  *Problem     `json:"error,omitempty"`
+}
+
+// BatchResponse holds one result per submitted receipt, in order.
+type BatchResponse struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// ImportResult is the outcome of one line of an NDJSON import. Line counts
+// from 1, including blank lines, which are skipped.
+type ImportResult struct {
+	Line   int          `json:"line"`
+	Status int          `json:"status"`
+	ID     string       `json:"id,omitempty"`
+	Points *int64       `json:"points,omitempty"`
+	Flags  []FieldError `json:"flags,omitempty"`
+	Error  *Problem     `json:"error,omitempty"`
+}
+
+// ImportSummary is the last line of an NDJSON import response. Error is set
+// if the import stopped before the end of the input.
+type ImportSummary struct {
+	Lines    int    `json:"lines"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ImportSummaryLine wraps the summary so clients can tell it apart from the
+// results.
+type ImportSummaryLine struct {
+	Summary ImportSummary `json:"summary"`
+}
+
+// JobStatus is the state of an asynchronous submission.
+type JobStatus string
+
+const (
+	JobQueued     JobStatus = "queued"
+	JobProcessing JobStatus = "processing"
+	JobDone       JobStatus = "done"
+	JobFailed     JobStatus = "failed"
+)
+
+// JobResponse reports an asynchronous submission. A done job has the
+// stored receipt's ID and points; a failed one has Error, in the form POST
+// /receipts/process would have answered with.
+type JobResponse struct {
+	ID         string       `json:"id"`
+	Status     JobStatus    `json:"status"`
+	ReceiptID  string       `json:"receiptId,omitempty"`
+	Points     *int64       `json:"points,omitempty"`
+	Flags      []FieldError `json:"flags,omitempty"`
+	Error      *Problem     `json:"error,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	FinishedAt *time.Time   `json:"finishedAt,omitempty"`
 }
 
 type PointsResponse struct {
-	Points int64 `json:"points"`
+	Points      int64       `json:"points"`
+	RuleVersion RuleVersion `json:"ruleVersion"`
+}
+
+// RuleBreakdown itemizes the points a single rule awarded to a receipt.
+type RuleBreakdown struct {
+	Rule        string            `json:"rule"`
+	Description string            `json:"description"`
+	Points      int64             `json:"points"`
+	Inputs      map[string]string `json:"inputs"`
+}
+
+// RuleVersion identifies the rule set that scored a receipt. Hash covers the
+// name and parameters of every rule, so any rule change yields a new hash.
+type RuleVersion struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// Score is the total points awarded to a receipt and how they were earned.
+type Score struct {
+	Points    int64           `json:"points"`
+	Breakdown []RuleBreakdown `json:"breakdown"`
+	Version   RuleVersion     `json:"ruleVersion"`
+}
+
+type PointsBreakdownResponse struct {
+	Points      int64           `json:"points"`
+	Breakdown   []RuleBreakdown `json:"breakdown"`
+	RuleVersion RuleVersion     `json:"ruleVersion"`
+	Flags       []FieldError    `json:"flags,omitempty"`
+}
+
+// ReceiptDetailResponse is a stored receipt together with what the service
+// recorded about it. SubmittedAt is omitted for receipts stored before
+// submission times were recorded, AmendedAt for the original revision and
+// RedactedAt for receipts that were never redacted.
+type ReceiptDetailResponse struct {
+	ID string `json:"id"`
+	Receipt
+	Points      int64        `json:"points"`
+	RuleVersion RuleVersion  `json:"ruleVersion"`
+	Revision    int          `json:"revision"`
+	SubmittedAt *time.Time   `json:"submittedAt,omitempty"`
+	AmendedAt   *time.Time   `json:"amendedAt,omitempty"`
+	RedactedAt  *time.Time   `json:"redactedAt,omitempty"`
+	Flags       []FieldError `json:"flags,omitempty"`
+}
+
+// ReceiptHistoryResponse lists every revision of a receipt, oldest first and
+// ending with the current one.
+type ReceiptHistoryResponse struct {
+	ID        string                  `json:"id"`
+	Revisions []ReceiptDetailResponse `json:"revisions"`
+}
+
+// ReceiptListResponse is one page of GET /receipts. NextCursor is set when
+// more receipts follow.
+type ReceiptListResponse struct {
+	Receipts   []ReceiptDetailResponse `json:"receipts"`
+	NextCursor string                  `json:"nextCursor,omitempty"`
+}
+
+// RuleInfo describes one rule in the active rule set.
+type RuleInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type RulesResponse struct {
+	RuleVersion RuleVersion `json:"ruleVersion"`
+	Rules       []RuleInfo  `json:"rules"`
+}
+
+// RescoreRequest selects receipts to re-score. An empty ID means every stored
+// receipt. The rules are the rule version this process has loaded named by
+// RuleVersion, or the JSON rules config in Rules, which also works for rule
+// versions loaded before a restart; with neither, the active rules.
+type RescoreRequest struct {
+	ID          string          `json:"id,omitempty"`
+	RuleVersion string          `json:"ruleVersion,omitempty"`
+	Rules       json.RawMessage `json:"rules,omitempty"`
+	Save        bool            `json:"save"`
+}
+
+// Reasons a re-scored receipt's new points weren't saved.
+const (
+	// RescoreSkippedDeleted means the receipt was deleted while the
+	// re-score ran.
+	RescoreSkippedDeleted = "deleted"
+	// RescoreSkippedChanged means the receipt was amended or redacted while
+	// the re-score ran.
+	RescoreSkippedChanged = "changed"
+	// RescoreSkippedRedacted means the receipt is redacted. Redacted
+	// receipts keep the points they had, since their details are gone.
+	RescoreSkippedRedacted = "redacted"
+)
+
+// RescoreResult reports one re-scored receipt. Skipped says why NewPoints
+// weren't saved, when saving was asked for.
+type RescoreResult struct {
+	ID             string      `json:"id"`
+	OldPoints      int64       `json:"oldPoints"`
+	NewPoints      int64       `json:"newPoints"`
+	OldRuleVersion RuleVersion `json:"oldRuleVersion"`
+	Skipped        string      `json:"skipped,omitempty"`
+}
+
+type RescoreResponse struct {
+	RuleVersion RuleVersion     `json:"ruleVersion"`
+	Saved       bool            `json:"saved"`
+	Results     []RescoreResult `json:"results"`
+}
+
+// FieldError describes one problem with one field of a submitted receipt.
+// Path is a JSON pointer to the field, such as "/items/12/price".
+type FieldError struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details response body. ExistingID is an
+// extension member naming the stored receipt a rejected duplicate matches.
+type Problem struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Status     int          `json:"status"`
+	Detail     string       `json:"detail,omitempty"`
+	Errors     []FieldError `json:"errors,omitempty"`
+	ExistingID string       `json:"existingId,omitempty"`
+}
+
+// AuditAction is a privacy action taken on a stored receipt.
+type AuditAction string
+
+const (
+	// AuditDelete removes the receipt entirely.
+	AuditDelete AuditAction = "delete"
+	// AuditRedact wipes the retailer and item descriptions but keeps the
+	// points.
+	AuditRedact AuditAction = "redact"
+)
+
+// AuditEntry records one privacy action.
+type AuditEntry struct {
+	ReceiptID string      `json:"receiptId"`
+	Action    AuditAction `json:"action"`
+	At        time.Time   `json:"at"`
+}
+
+// AuditResponse lists audit entries, oldest first.
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
 }
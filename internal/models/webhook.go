@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// EventReceiptScored is sent when a receipt is stored or amended, with its
+// new points.
+const EventReceiptScored = "receipt.scored"
+
+// ReceiptEvent is the payload of a webhook delivery.
+type ReceiptEvent struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Data      ReceiptEventData `json:"data"`
+}
+
+// ReceiptEventData describes the receipt an event is about.
+type ReceiptEventData struct {
+	ReceiptID   string       `json:"receiptId"`
+	Revision    int          `json:"revision"`
+	Points      int64        `json:"points"`
+	RuleVersion RuleVersion  `json:"ruleVersion"`
+	Flags       []FieldError `json:"flags,omitempty"`
+}
+
+// WebhookRequest subscribes URL to events. Secret signs the deliveries; one
+// is generated when it is empty. Events defaults to every event type.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// Webhook is a subscription. Secret is only returned when the webhook is
+// created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookListResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookDelivery records one attempt to deliver an event. StatusCode is 0
+// when no response was received, and Error says why the attempt failed.
+type WebhookDelivery struct {
+	ID          string    `json:"id"`
+	WebhookID   string    `json:"webhookId"`
+	EventID     string    `json:"eventId"`
+	EventType   string    `json:"eventType"`
+	Attempt     int       `json:"attempt"`
+	Succeeded   bool      `json:"succeeded"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+	DurationMs  int64     `json:"durationMs"`
+}
+
+// WebhookDeliveriesResponse lists delivery attempts, oldest first.
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// DeadLetter is an event that couldn't be delivered to a webhook after every
+// retry.
+type DeadLetter struct {
+	ID        string       `json:"id"`
+	WebhookID string       `json:"webhookId"`
+	Event     ReceiptEvent `json:"event"`
+	Attempts  int          `json:"attempts"`
+	LastError string       `json:"lastError"`
+	FailedAt  time.Time    `json:"failedAt"`
+}
+
+// DeadLettersResponse lists dead letters, oldest first.
+type DeadLettersResponse struct {
+	DeadLetters []DeadLetter `json:"deadLetters"`
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config sets the parameters of the built-in rules and whether each one is
+// applied. Fields left out of a config file keep their DefaultConfig values.
+type Config struct {
+	Name  string      `json:"name" yaml:"name"`
+	Rules RulesConfig `json:"rules" yaml:"rules"`
+}
+
+// RulesConfig holds one entry per built-in rule.
+type RulesConfig struct {
+	RetailerName      RetailerNameConfig      `json:"retailerName" yaml:"retailerName"`
+	RoundTotal        RoundTotalConfig        `json:"roundTotal" yaml:"roundTotal"`
+	QuarterMultiple   QuarterMultipleConfig   `json:"quarterMultiple" yaml:"quarterMultiple"`
+	ItemPairs         ItemPairsConfig         `json:"itemPairs" yaml:"itemPairs"`
+	DescriptionLength DescriptionLengthConfig `json:"descriptionLength" yaml:"descriptionLength"`
+	OddDay            OddDayConfig            `json:"oddDay" yaml:"oddDay"`
+	Afternoon         AfternoonConfig         `json:"afternoon" yaml:"afternoon"`
+}
+
+type RetailerNameConfig struct {
+	Enabled          bool `json:"enabled" yaml:"enabled"`
+	RetailerNameRule `yaml:",inline"`
+}
+
+type RoundTotalConfig struct {
+	Enabled        bool `json:"enabled" yaml:"enabled"`
+	RoundTotalRule `yaml:",inline"`
+}
+
+type QuarterMultipleConfig struct {
+	Enabled             bool `json:"enabled" yaml:"enabled"`
+	QuarterMultipleRule `yaml:",inline"`
+}
+
+type ItemPairsConfig struct {
+	Enabled       bool `json:"enabled" yaml:"enabled"`
+	ItemPairsRule `yaml:",inline"`
+}
+
+type DescriptionLengthConfig struct {
+	Enabled               bool `json:"enabled" yaml:"enabled"`
+	DescriptionLengthRule `yaml:",inline"`
+}
+
+type OddDayConfig struct {
+	Enabled    bool `json:"enabled" yaml:"enabled"`
+	OddDayRule `yaml:",inline"`
+}
+
+type AfternoonConfig struct {
+	Enabled       bool `json:"enabled" yaml:"enabled"`
+	AfternoonRule `yaml:",inline"`
+}
+
+// DefaultConfig returns the configuration matching the original scoring rules.
+func DefaultConfig() Config {
+	return Config{
+		Name: "default",
+		Rules: RulesConfig{
+			RetailerName:      RetailerNameConfig{true, RetailerNameRule{PointsPerCharacter: 1}},
+			RoundTotal:        RoundTotalConfig{true, RoundTotalRule{Points: 50}},
+			QuarterMultiple:   QuarterMultipleConfig{true, QuarterMultipleRule{Points: 25}},
+			ItemPairs:         ItemPairsConfig{true, ItemPairsRule{PointsPerPair: 5}},
+			DescriptionLength: DescriptionLengthConfig{true, DescriptionLengthRule{LengthMultiple: 3, PriceMultiplier: 0.2}},
+			OddDay:            OddDayConfig{true, OddDayRule{Points: 6}},
+			Afternoon:         AfternoonConfig{true, AfternoonRule{Points: 10, Start: "14:00", End: "16:00"}},
+		},
+	}
+}
+
+// LoadConfig reads a rules config file. Files ending in .json are parsed as
+// JSON; .yaml and .yml files are parsed as YAML.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read rules config: %w", err)
+	}
+
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = "json"
+	case ".yaml", ".yml":
+		format = "yaml"
+	default:
+		return Config{}, fmt.Errorf("rules config %s: unsupported file extension, want .json, .yaml or .yml", path)
+	}
+
+	config, err := ParseConfig(data, format)
+	if err != nil {
+		return Config{}, fmt.Errorf("rules config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseConfig decodes a "json" or "yaml" rules config over DefaultConfig and
+// validates the result. Unknown fields are rejected.
+func ParseConfig(data []byte, format string) (Config, error) {
+	config := DefaultConfig()
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return Config{}, fmt.Errorf("invalid JSON: %w", err)
+		}
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("invalid YAML: %w", err)
+		}
+	default:
+		return Config{}, fmt.Errorf("unsupported rules config format %q", format)
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Validate reports every parameter that would make a rule misbehave.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	rules := c.Rules
+	check(strings.TrimSpace(c.Name) != "", "name must not be empty")
+	check(rules.RetailerName.PointsPerCharacter >= 0, "retailerName.pointsPerCharacter must not be negative")
+	check(rules.RoundTotal.Points >= 0, "roundTotal.points must not be negative")
+	check(rules.QuarterMultiple.Points >= 0, "quarterMultiple.points must not be negative")
+	check(rules.ItemPairs.PointsPerPair >= 0, "itemPairs.pointsPerPair must not be negative")
+	check(rules.DescriptionLength.LengthMultiple > 0, "descriptionLength.lengthMultiple must be positive")
+	check(rules.DescriptionLength.PriceMultiplier >= 0, "descriptionLength.priceMultiplier must not be negative")
+	check(rules.OddDay.Points >= 0, "oddDay.points must not be negative")
+	check(rules.Afternoon.Points >= 0, "afternoon.points must not be negative")
+
+	start, startErr := time.Parse("15:04", rules.Afternoon.Start)
+	check(startErr == nil, "afternoon.start must be a 24-hour HH:MM time, got %q", rules.Afternoon.Start)
+	end, endErr := time.Parse("15:04", rules.Afternoon.End)
+	check(endErr == nil, "afternoon.end must be a 24-hour HH:MM time, got %q", rules.Afternoon.End)
+	if startErr == nil && endErr == nil {
+		check(start.Before(end), "afternoon.start must be before afternoon.end")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid rules config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// BuildRules returns the enabled built-in rules in their standard order.
+func (c Config) BuildRules() []Rule {
+	var rules []Rule
+	add := func(enabled bool, rule Rule) {
+		if enabled {
+			rules = append(rules, rule)
+		}
+	}
+	add(c.Rules.RetailerName.Enabled, c.Rules.RetailerName.RetailerNameRule)
+	add(c.Rules.RoundTotal.Enabled, c.Rules.RoundTotal.RoundTotalRule)
+	add(c.Rules.QuarterMultiple.Enabled, c.Rules.QuarterMultiple.QuarterMultipleRule)
+	add(c.Rules.ItemPairs.Enabled, c.Rules.ItemPairs.ItemPairsRule)
+	add(c.Rules.DescriptionLength.Enabled, c.Rules.DescriptionLength.DescriptionLengthRule)
+	add(c.Rules.OddDay.Enabled, c.Rules.OddDay.OddDayRule)
+	add(c.Rules.Afternoon.Enabled, c.Rules.Afternoon.AfternoonRule)
+	return rules
+}
+
+// NewRegistryFromConfig validates the config and returns a registry holding
+// its enabled built-in rules followed by any extra rules.
+func NewRegistryFromConfig(config Config, extra ...Rule) (*Registry, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return NewRegistry(config.Name, append(config.BuildRules(), extra...)...)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"receipt-processor/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "14:30",
+		Items: []models.Item{
+			{ShortDescription: "123", Price: "1.00"},
+			{ShortDescription: "456", Price: "2.00"},
+			{ShortDescription: "789", Price: "3.00"},
+		},
+		Total: "6.00",
+	}
+
+	t.Run("Default Config Matches Builtin Scoring", func(t *testing.T) {
+		registry, err := NewRegistryFromConfig(DefaultConfig())
+		if err != nil {
+			t.Fatalf("NewRegistryFromConfig() error = %v", err)
+		}
+		if got := registry.CalculatePoints(receipt); got != 105 {
+			t.Errorf("CalculatePoints() = %d, want 105", got)
+		}
+	})
+
+	t.Run("Example Config File Matches Defaults", func(t *testing.T) {
+		config, err := LoadConfig(filepath.Join("..", "..", "examples", "rules.yaml"))
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		if !reflect.DeepEqual(config, DefaultConfig()) {
+			t.Errorf("LoadConfig() = %+v, want %+v", config, DefaultConfig())
+		}
+	})
+
+	t.Run("Partial YAML Keeps Defaults", func(t *testing.T) {
+		config, err := ParseConfig([]byte("name: promo\nrules:\n  roundTotal:\n    points: 100\n  afternoon:\n    enabled: false\n"), "yaml")
+		if err != nil {
+			t.Fatalf("ParseConfig() error = %v", err)
+		}
+		if config.Name != "promo" || config.Rules.RoundTotal.Points != 100 || !config.Rules.RoundTotal.Enabled {
+			t.Errorf("unexpected round total config %+v", config.Rules.RoundTotal)
+		}
+		if config.Rules.QuarterMultiple.Points != 25 {
+			t.Errorf("quarterMultiple.points = %d, want default 25", config.Rules.QuarterMultiple.Points)
+		}
+
+		registry, _ := NewRegistryFromConfig(config)
+		// 105 - 10 afternoon + 50 extra round total
+		if got := registry.CalculatePoints(receipt); got != 145 {
+			t.Errorf("CalculatePoints() = %d, want 145", got)
+		}
+	})
+
+	t.Run("JSON Config", func(t *testing.T) {
+		config, err := ParseConfig([]byte(`{"rules": {"descriptionLength": {"priceMultiplier": 1}, "itemPairs": {"enabled": false}}}`), "json")
+		if err != nil {
+			t.Fatalf("ParseConfig() error = %v", err)
+		}
+		registry, _ := NewRegistryFromConfig(config)
+		// 105 - 5 item pairs - 3 description + 6 description at full price
+		if got := registry.CalculatePoints(receipt); got != 103 {
+			t.Errorf("CalculatePoints() = %d, want 103", got)
+		}
+	})
+
+	t.Run("Invalid Configs", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			data    string
+			format  string
+			wantErr string
+		}{
+			{"unknown yaml field", "rules:\n  roundTotal:\n    bonus: 5\n", "yaml", "field bonus not found"},
+			{"unknown json field", `{"rules": {"weekend": {}}}`, "json", "unknown field"},
+			{"negative points", "rules:\n  oddDay:\n    points: -6\n", "yaml", "oddDay.points must not be negative"},
+			{"zero length multiple", `{"rules": {"descriptionLength": {"lengthMultiple": 0}}}`, "json", "lengthMultiple must be positive"},
+			{"bad window time", "rules:\n  afternoon:\n    start: 2pm\n", "yaml", "afternoon.start must be a 24-hour HH:MM time"},
+			{"inverted window", "rules:\n  afternoon:\n    start: \"16:00\"\n    end: \"14:00\"\n", "yaml", "afternoon.start must be before afternoon.end"},
+			{"empty name", `{"name": ""}`, "json", "name must not be empty"},
+			{"malformed json", `{"rules": `, "json", "invalid JSON"},
+			{"unsupported format", `name = "x"`, "toml", "unsupported rules config format"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := ParseConfig([]byte(tt.data), tt.format)
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %q, want it to contain %q", err.Error(), tt.wantErr)
+				}
+			})
+		}
+	})
+
+	t.Run("Load Config Errors", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, err := LoadConfig(filepath.Join(dir, "missing.yaml")); err == nil {
+			t.Error("expected error for missing file")
+		}
+
+		path := filepath.Join(dir, "rules.txt")
+		os.WriteFile(path, []byte("name: x\n"), 0o644)
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "unsupported file extension") {
+			t.Errorf("expected unsupported extension error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"receipt-processor/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEventBuffer is how many recent events an EventStream keeps for
+// clients resuming with Last-Event-ID.
+const DefaultEventBuffer = 1000
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped.
+const subscriberBuffer = 256
+
+// StreamEvent is an event with its position in the stream.
+type StreamEvent struct {
+	ID    string
+	Event models.ActivityEvent
+}
+
+// EventStream fans receipt activity out to subscribers and keeps the latest
+// events in a ring buffer, so a client that reconnects can pick up where it
+// left off. Publish never waits for a subscriber: one that falls too far
+// behind is dropped, and can resume from the buffer when it reconnects.
+type EventStream struct {
+	// epoch tells event IDs of this process from those of an earlier one,
+	// whose sequence numbers mean nothing here.
+	epoch string
+
+	mutex sync.Mutex
+	// buffer holds event n at n % len(buffer); next is the sequence number
+	// of the next event, counting from 1.
+	buffer      []StreamEvent
+	next        uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events published after it was made. Events is
+// closed when the subscriber falls behind or Close is called.
+type Subscription struct {
+	// Backlog holds the buffered events the subscriber missed since the
+	// event it resumed from, oldest first.
+	Backlog []StreamEvent
+	Events  <-chan StreamEvent
+
+	events chan StreamEvent
+	stream *EventStream
+}
+
+// NewEventStream returns a stream that keeps the latest size events.
+func NewEventStream(size int) *EventStream {
+	return &EventStream{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		buffer:      make([]StreamEvent, max(size, 1)),
+		next:        1,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns event the next ID, buffers it and sends it to every
+// subscriber that has room for it. Subscribers without room are dropped.
+func (s *EventStream) Publish(event models.ActivityEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	streamEvent := StreamEvent{ID: s.epoch + "-" + strconv.FormatUint(s.next, 10), Event: event}
+	s.buffer[s.next%uint64(len(s.buffer))] = streamEvent
+	s.next++
+
+	for subscription := range s.subscribers {
+		select {
+		case subscription.events <- streamEvent:
+		default:
+			s.drop(subscription)
+		}
+	}
+}
+
+// Subscribe starts a subscription. lastEventID is the ID of the last event
+// the client saw, if it is resuming: the buffered events after it are
+// returned as the backlog. If that event is no longer buffered, or was sent
+// by an earlier process, the backlog is the whole buffer.
+func (s *EventStream) Subscribe(lastEventID string) *Subscription {
+	events := make(chan StreamEvent, subscriberBuffer)
+	subscription := &Subscription{Events: events, events: events, stream: s}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lastEventID != "" {
+		oldest := uint64(1)
+		if s.next > uint64(len(s.buffer)) {
+			oldest = s.next - uint64(len(s.buffer))
+		}
+		from := oldest
+		if epoch, sequence, ok := strings.Cut(lastEventID, "-"); ok && epoch == s.epoch {
+			if last, err := strconv.ParseUint(sequence, 10, 64); err == nil {
+				from = max(last+1, oldest)
+			}
+		}
+		for n := from; n < s.next; n++ {
+			subscription.Backlog = append(subscription.Backlog, s.buffer[n%uint64(len(s.buffer))])
+		}
+	}
+	s.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// Close ends the subscription.
+func (sub *Subscription) Close() {
+	sub.stream.mutex.Lock()
+	defer sub.stream.mutex.Unlock()
+	sub.stream.drop(sub)
+}
+
+// drop removes a subscription and closes its channel. Callers must hold
+// mutex.
+func (s *EventStream) drop(subscription *Subscription) {
+	if _, ok := s.subscribers[subscription]; ok {
+		delete(s.subscribers, subscription)
+		close(subscription.events)
+	}
+}
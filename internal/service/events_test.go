@@ -0,0 +1,108 @@
+package service
+
+import (
+	"receipt-processor/internal/models"
+	"testing"
+	"time"
+)
+
+func processed(receiptID string) models.ActivityEvent {
+	return models.ActivityEvent{Type: models.EventReceiptProcessed, ReceiptID: receiptID, At: time.Now().UTC()}
+}
+
+// receiptIDs returns the receipt IDs of events.
+func receiptIDs(events []StreamEvent) []string {
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.Event.ReceiptID)
+	}
+	return ids
+}
+
+func equalIDs(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEventStream(t *testing.T) {
+	t.Run("Live Events", func(t *testing.T) {
+		stream := NewEventStream(10)
+		stream.Publish(processed("before"))
+		subscription := stream.Subscribe("")
+		defer subscription.Close()
+		if len(subscription.Backlog) != 0 {
+			t.Errorf("new subscriber got backlog %v", receiptIDs(subscription.Backlog))
+		}
+
+		stream.Publish(processed("a"))
+		stream.Publish(processed("b"))
+		first, second := <-subscription.Events, <-subscription.Events
+		if !equalIDs(receiptIDs([]StreamEvent{first, second}), "a", "b") || first.ID == second.ID {
+			t.Errorf("got %+v then %+v, want a then b with distinct IDs", first, second)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		stream := NewEventStream(3)
+		var ids []string
+		for _, receiptID := range []string{"a", "b", "c", "d", "e"} {
+			subscription := stream.Subscribe("")
+			stream.Publish(processed(receiptID))
+			ids = append(ids, (<-subscription.Events).ID)
+			subscription.Close()
+		}
+
+		for _, test := range []struct {
+			name, lastEventID string
+			want              []string
+		}{
+			{"Buffered", ids[2], []string{"d", "e"}},
+			{"Latest", ids[4], []string{}},
+			{"No Longer Buffered", ids[0], []string{"c", "d", "e"}},
+			{"Earlier Process", "0-3", []string{"c", "d", "e"}},
+		} {
+			subscription := stream.Subscribe(test.lastEventID)
+			if got := receiptIDs(subscription.Backlog); !equalIDs(got, test.want...) {
+				t.Errorf("%s: backlog = %v, want %v", test.name, got, test.want)
+			}
+			subscription.Close()
+		}
+	})
+
+	t.Run("Slow Subscriber Is Dropped", func(t *testing.T) {
+		stream := NewEventStream(10)
+		slow := stream.Subscribe("")
+		fast := stream.Subscribe("")
+		defer fast.Close()
+
+		done := make(chan struct{})
+		go func() {
+			for n := 0; n <= subscriberBuffer; n++ {
+				stream.Publish(processed("r"))
+				<-fast.Events
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Publish blocked on a subscriber that isn't reading")
+		}
+
+		received := 0
+		for range slow.Events {
+			received++
+		}
+		if received != subscriberBuffer {
+			t.Errorf("slow subscriber got %d events before being dropped, want %d", received, subscriberBuffer)
+		}
+		slow.Close()
+	})
+}
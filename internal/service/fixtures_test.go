@@ -0,0 +1,155 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"receipt-processor/internal/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// legacyCalculatePoints is the float64 scoring implementation that predates
+// models.Money, kept to check the exact arithmetic against it.
+func legacyCalculatePoints(receipt models.Receipt) int64 {
+	var points int64 = 0
+
+	alphanumeric := regexp.MustCompile(`[a-zA-Z0-9]`)
+	points += int64(len(alphanumeric.FindAllString(receipt.Retailer, -1)))
+
+	if strings.HasSuffix(receipt.Total, ".00") {
+		points += 50
+	}
+
+	if total, err := strconv.ParseFloat(receipt.Total, 64); err == nil {
+		if math.Mod(total*100, 25) == 0 {
+			points += 25
+		}
+	}
+
+	points += int64(len(receipt.Items) / 2 * 5)
+
+	for _, item := range receipt.Items {
+		if len(strings.TrimSpace(item.ShortDescription))%3 == 0 {
+			price, _ := strconv.ParseFloat(item.Price, 64)
+			points += int64(math.Ceil(price * 0.2))
+		}
+	}
+
+	if date, err := time.Parse("2006-01-02", receipt.PurchaseDate); err == nil {
+		if date.Day()%2 == 1 {
+			points += 6
+		}
+	}
+
+	if purchaseTime, err := time.Parse("15:04", receipt.PurchaseTime); err == nil {
+		afterTwo := time.Date(2000, 1, 1, 14, 0, 0, 0, time.UTC)
+		beforeFour := time.Date(2000, 1, 1, 16, 0, 0, 0, time.UTC)
+		compareTime := time.Date(2000, 1, 1, purchaseTime.Hour(), purchaseTime.Minute(), 0, 0, time.UTC)
+		if compareTime.After(afterTwo) && compareTime.Before(beforeFour) {
+			points += 10
+		}
+	}
+
+	return points
+}
+
+func fixtureReceipts(t *testing.T) map[string]models.Receipt {
+	fixtures := map[string]models.Receipt{
+		"target-afternoon": {
+			Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "14:30",
+			Items: []models.Item{{ShortDescription: "123", Price: "1.00"}, {ShortDescription: "456", Price: "2.00"}, {ShortDescription: "789", Price: "3.00"}},
+			Total: "6.00",
+		},
+		"m&m-corner-market": {
+			Retailer: "M&M Corner Market", PurchaseDate: "2022-03-20", PurchaseTime: "14:33",
+			Items: []models.Item{{ShortDescription: "Gatorade", Price: "2.25"}, {ShortDescription: "Gatorade", Price: "2.25"}, {ShortDescription: "Gatorade", Price: "2.25"}, {ShortDescription: "Gatorade", Price: "2.25"}},
+			Total: "9.00",
+		},
+		"target-readme": {
+			Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01",
+			Items: []models.Item{
+				{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+				{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+				{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+				{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+				{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+			},
+			Total: "35.35",
+		},
+	}
+
+	paths, err := filepath.Glob(filepath.Join("..", "..", "examples", "*-receipt.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no example receipts found: %v", err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		var receipt models.Receipt
+		if err := json.Unmarshal(data, &receipt); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		fixtures[filepath.Base(path)] = receipt
+	}
+	return fixtures
+}
+
+func TestExactArithmeticMatchesLegacyOnFixtures(t *testing.T) {
+	for name, receipt := range fixtureReceipts(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateReceipt(receipt); err != nil {
+				t.Fatalf("fixture is invalid: %v", err)
+			}
+			if got, want := CalculatePoints(receipt), legacyCalculatePoints(receipt); got != want {
+				t.Errorf("CalculatePoints() = %d, legacy = %d", got, want)
+			}
+		})
+	}
+}
+
+func TestExactArithmeticMatchesLegacyOnRepricedFixtures(t *testing.T) {
+	// Re-price every fixture with random amounts up to $1,000,000. With the
+	// default 0.2 multiplier the float64 rules never rounded wrong in that
+	// range, so both implementations must agree.
+	for name, fixture := range fixtureReceipts(t) {
+		t.Run(name, func(t *testing.T) {
+			agrees := func(total uint32, prices []uint32) bool {
+				receipt := fixture
+				receipt.Total = models.Money(total % 100000000).String()
+				receipt.Items = make([]models.Item, len(fixture.Items))
+				for i, item := range fixture.Items {
+					if i < len(prices) {
+						item.Price = models.Money(prices[i] % 100000000).String()
+					}
+					receipt.Items[i] = item
+				}
+				return CalculatePoints(receipt) == legacyCalculatePoints(receipt)
+			}
+			if err := quick.Check(agrees, &quick.Config{MaxCount: 2000}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestExactArithmeticFixesFloatRounding(t *testing.T) {
+	// With a 1.1 price multiplier, float64 arithmetic computed 50.00 * 1.1 as
+	// 55.00000000000001 and awarded 56 points. The exact product is 55.
+	receipt := models.Receipt{
+		Retailer: "Target", PurchaseDate: "2022-01-02", PurchaseTime: "13:01",
+		Items: []models.Item{{ShortDescription: "abc", Price: "50.00"}},
+		Total: "50.00",
+	}
+	registry, _ := NewRegistry("description-only", DescriptionLengthRule{LengthMultiple: 3, PriceMultiplier: 1.1})
+	if got := registry.CalculatePoints(receipt); got != 55 {
+		t.Errorf("CalculatePoints() = %d, want 55", got)
+	}
+}
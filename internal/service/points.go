@@ -1,102 +1,49 @@
 package service
 
 import (
-	"fmt"
-	"math"
 	"receipt-processor/internal/models"
-	"regexp"
-	"strconv"
-	"strings"
-	"time"
 )
 
-func CalculatePoints(receipt models.Receipt) int64 {
-	var points int64 = 0
-
-	// Rule 1: One point for every alphanumeric character in the retailer name
-	alphanumeric := regexp.MustCompile(`[a-zA-Z0-9]`)
-	matches := alphanumeric.FindAllString(receipt.Retailer, -1)
-	points += int64(len(matches))
-
-	// Rule 2: 50 points if the total is a round dollar amount
-	if strings.HasSuffix(receipt.Total, ".00") {
-		points += 50
-	}
+// DefaultRegistry holds the built-in rules plus any rules added through
+// Register. CalculatePoints scores receipts against it.
+var DefaultRegistry = mustRegistry(DefaultConfig().Name, BuiltinRules()...)
 
-	// Rule 3: 25 points if the total is a multiple of 0.25
-	if total, err := strconv.ParseFloat(receipt.Total, 64); err == nil {
-		if math.Mod(total*100, 25) == 0 {
-			points += 25
-		}
-	}
-
-	// Rule 4: 5 points for every two items
-	points += int64(len(receipt.Items) / 2 * 5)
-
-	// Rule 5: Points for items with description length multiple of 3
-	for _, item := range receipt.Items {
-		trimmedLen := len(strings.TrimSpace(item.ShortDescription))
-		if trimmedLen%3 == 0 {
-			price, _ := strconv.ParseFloat(item.Price, 64)
-			points += int64(math.Ceil(price * 0.2))
-		}
-	}
-
-	// Rule 6: 6 points if the day in the purchase date is odd
-	if date, err := time.Parse("2006-01-02", receipt.PurchaseDate); err == nil {
-		if date.Day()%2 == 1 {
-			points += 6
-		}
+func mustRegistry(name string, rules ...Rule) *Registry {
+	registry, err := NewRegistry(name, rules...)
+	if err != nil {
+		panic(err)
 	}
+	return registry
+}
 
-	// Rule 7: 10 points if the time of purchase is after 2:00pm and before 4:00pm
-	if purchaseTime, err := time.Parse("15:04", receipt.PurchaseTime); err == nil {
-		afterTwo := time.Date(2000, 1, 1, 14, 0, 0, 0, time.UTC)
-		beforeFour := time.Date(2000, 1, 1, 16, 0, 0, 0, time.UTC)
-		compareTime := time.Date(2000, 1, 1, purchaseTime.Hour(), purchaseTime.Minute(), 0, 0, time.UTC)
+// Register adds a rule to the default registry. The server scores with
+// the registered rules after the ones its config enables, from the next time
+// it loads its rules.
+func Register(rule Rule) error {
+	return DefaultRegistry.Register(rule)
+}
 
-		if compareTime.After(afterTwo) && compareTime.Before(beforeFour) {
-			points += 10
+// RegisteredRules returns the rules added through Register, in the order
+// they were added.
+func RegisteredRules() []Rule {
+	builtin := make(map[string]bool)
+	for _, rule := range BuiltinRules() {
+		builtin[rule.Name()] = true
+	}
+	var rules []Rule
+	for _, rule := range DefaultRegistry.Rules() {
+		if !builtin[rule.Name()] {
+			rules = append(rules, rule)
 		}
 	}
-
-	return points
+	return rules
 }
 
-func ValidateReceipt(receipt models.Receipt) error {
-	// Validate retailer
-	if !regexp.MustCompile(`^[\w\s\-&]+$`).MatchString(receipt.Retailer) {
-		return fmt.Errorf("invalid retailer name")
-	}
-
-	// Validate purchase date
-	if _, err := time.Parse("2006-01-02", receipt.PurchaseDate); err != nil {
-		return fmt.Errorf("invalid purchase date")
-	}
-
-	// Validate purchase time
-	if _, err := time.Parse("15:04", receipt.PurchaseTime); err != nil {
-		return fmt.Errorf("invalid purchase time")
-	}
-
-	// Validate total
-	if !regexp.MustCompile(`^\d+\.\d{2}$`).MatchString(receipt.Total) {
-		return fmt.Errorf("invalid total")
-	}
-
-	// Validate items
-	if len(receipt.Items) == 0 {
-		return fmt.Errorf("at least one item is required")
-	}
-
-	for _, item := range receipt.Items {
-		if !regexp.MustCompile(`^[\w\s\-]+$`).MatchString(item.ShortDescription) {
-			return fmt.Errorf("invalid item description")
-		}
-		if !regexp.MustCompile(`^\d+\.\d{2}$`).MatchString(item.Price) {
-			return fmt.Errorf("invalid item price")
-		}
-	}
+// Score evaluates the receipt against the default registry.
+func Score(receipt models.Receipt) models.Score {
+	return DefaultRegistry.Score(receipt)
+}
 
-	return nil
+func CalculatePoints(receipt models.Receipt) int64 {
+	return DefaultRegistry.CalculatePoints(receipt)
 }
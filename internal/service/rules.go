@@ -0,0 +1,316 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"receipt-processor/internal/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule awards points for a single aspect of a receipt. Implementations must be
+// safe for concurrent use, since one rule instance scores every receipt.
+type Rule interface {
+	// Name uniquely identifies the rule within a registry.
+	Name() string
+	// Description explains the rule in a sentence suitable for end users.
+	Description() string
+	// Evaluate returns the points the rule awards for the receipt along with
+	// the receipt values it looked at.
+	Evaluate(receipt models.Receipt) RuleResult
+}
+
+// RuleResult is the outcome of evaluating one rule against one receipt.
+type RuleResult struct {
+	Points int64
+	// Inputs records the receipt values the rule based its decision on, keyed
+	// by a short label, so the award can be explained later.
+	Inputs map[string]string
+}
+
+// Registry is an ordered set of rules that together score a receipt.
+type Registry struct {
+	name    string
+	rules   []Rule
+	version models.RuleVersion
+	mutex   sync.RWMutex
+}
+
+// NewRegistry returns a registry called name holding the given rules, in
+// order.
+func NewRegistry(name string, rules ...Rule) (*Registry, error) {
+	registry := &Registry{name: name}
+	registry.version = registry.computeVersion()
+	for _, rule := range rules {
+		if err := registry.Register(rule); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// Register appends a rule to the registry. Rule names must be unique.
+func (r *Registry) Register(rule Rule) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, existing := range r.rules {
+		if existing.Name() == rule.Name() {
+			return fmt.Errorf("rule %q is already registered", rule.Name())
+		}
+	}
+	r.rules = append(r.rules, rule)
+	r.version = r.computeVersion()
+	return nil
+}
+
+// Version identifies the registry's rule set by name and a hash of its
+// content, so a stored score can be traced back to the exact rules that
+// produced it.
+func (r *Registry) Version() models.RuleVersion {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.version
+}
+
+// computeVersion hashes each rule's name and parameters in evaluation order.
+// Callers must hold the write lock or own the registry exclusively.
+func (r *Registry) computeVersion() models.RuleVersion {
+	hash := sha256.New()
+	for _, rule := range r.rules {
+		params, err := json.Marshal(rule)
+		if err != nil {
+			params = []byte(fmt.Sprintf("%#v", rule))
+		}
+		fmt.Fprintf(hash, "%s %s\n", rule.Name(), params)
+	}
+	return models.RuleVersion{Name: r.name, Hash: hex.EncodeToString(hash.Sum(nil))}
+}
+
+// Rules returns a copy of the registered rules in evaluation order.
+func (r *Registry) Rules() []Rule {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	rules := make([]Rule, len(r.rules))
+	copy(rules, r.rules)
+	return rules
+}
+
+// Score evaluates every registered rule and returns the total together with
+// a per-rule itemization.
+func (r *Registry) Score(receipt models.Receipt) models.Score {
+	r.mutex.RLock()
+	rules := r.rules
+	version := r.version
+	r.mutex.RUnlock()
+
+	score := models.Score{
+		Breakdown: make([]models.RuleBreakdown, 0, len(rules)),
+		Version:   version,
+	}
+	for _, rule := range rules {
+		result := rule.Evaluate(receipt)
+		score.Points += result.Points
+		score.Breakdown = append(score.Breakdown, models.RuleBreakdown{
+			Rule:        rule.Name(),
+			Description: rule.Description(),
+			Points:      result.Points,
+			Inputs:      result.Inputs,
+		})
+	}
+	return score
+}
+
+// CalculatePoints sums the points awarded by every registered rule.
+func (r *Registry) CalculatePoints(receipt models.Receipt) int64 {
+	return r.Score(receipt).Points
+}
+
+// BuiltinRules returns the standard receipt processor rules with their
+// default parameters.
+func BuiltinRules() []Rule {
+	return DefaultConfig().BuildRules()
+}
+
+var alphanumeric = regexp.MustCompile(`[a-zA-Z0-9]`)
+
+// RetailerNameRule awards points for every alphanumeric character in the
+// retailer name.
+type RetailerNameRule struct {
+	PointsPerCharacter int64 `json:"pointsPerCharacter" yaml:"pointsPerCharacter"`
+}
+
+func (RetailerNameRule) Name() string { return "retailer-name" }
+
+func (r RetailerNameRule) Description() string {
+	return fmt.Sprintf("%s for every alphanumeric character in the retailer name", pointsPhrase(r.PointsPerCharacter))
+}
+
+func (r RetailerNameRule) Evaluate(receipt models.Receipt) RuleResult {
+	count := len(alphanumeric.FindAllString(receipt.Retailer, -1))
+	return RuleResult{
+		Points: int64(count) * r.PointsPerCharacter,
+		Inputs: map[string]string{
+			"retailer":               receipt.Retailer,
+			"alphanumericCharacters": strconv.Itoa(count),
+		},
+	}
+}
+
+// RoundTotalRule awards points if the total is a round dollar amount.
+type RoundTotalRule struct {
+	Points int64 `json:"points" yaml:"points"`
+}
+
+func (RoundTotalRule) Name() string { return "round-total" }
+
+func (r RoundTotalRule) Description() string {
+	return fmt.Sprintf("%s if the total is a round dollar amount with no cents", pointsPhrase(r.Points))
+}
+
+func (r RoundTotalRule) Evaluate(receipt models.Receipt) RuleResult {
+	result := RuleResult{Inputs: map[string]string{"total": receipt.Total}}
+	if total, err := models.ParseMoney(receipt.Total); err == nil && total.IsRoundDollar() {
+		result.Points = r.Points
+	}
+	return result
+}
+
+const quarter models.Money = 25
+
+// QuarterMultipleRule awards points if the total is a multiple of 0.25.
+type QuarterMultipleRule struct {
+	Points int64 `json:"points" yaml:"points"`
+}
+
+func (QuarterMultipleRule) Name() string { return "quarter-multiple" }
+
+func (r QuarterMultipleRule) Description() string {
+	return fmt.Sprintf("%s if the total is a multiple of 0.25", pointsPhrase(r.Points))
+}
+
+func (r QuarterMultipleRule) Evaluate(receipt models.Receipt) RuleResult {
+	result := RuleResult{Inputs: map[string]string{"total": receipt.Total}}
+	if total, err := models.ParseMoney(receipt.Total); err == nil && total.IsMultipleOf(quarter) {
+		result.Points = r.Points
+	}
+	return result
+}
+
+// ItemPairsRule awards points for every two items on the receipt.
+type ItemPairsRule struct {
+	PointsPerPair int64 `json:"pointsPerPair" yaml:"pointsPerPair"`
+}
+
+func (ItemPairsRule) Name() string { return "item-pairs" }
+
+func (r ItemPairsRule) Description() string {
+	return fmt.Sprintf("%s for every two items on the receipt", pointsPhrase(r.PointsPerPair))
+}
+
+func (r ItemPairsRule) Evaluate(receipt models.Receipt) RuleResult {
+	return RuleResult{
+		Points: int64(len(receipt.Items)/2) * r.PointsPerPair,
+		Inputs: map[string]string{"itemCount": strconv.Itoa(len(receipt.Items))},
+	}
+}
+
+// DescriptionLengthRule awards a share of the item price, rounded up, for
+// every item whose trimmed description length is a multiple of LengthMultiple.
+// A LengthMultiple below 1 matches no item.
+type DescriptionLengthRule struct {
+	LengthMultiple  int     `json:"lengthMultiple" yaml:"lengthMultiple"`
+	PriceMultiplier float64 `json:"priceMultiplier" yaml:"priceMultiplier"`
+}
+
+func (DescriptionLengthRule) Name() string { return "description-length" }
+
+func (r DescriptionLengthRule) Description() string {
+	return fmt.Sprintf("If the trimmed length of the item description is a multiple of %d, "+
+		"the price multiplied by %g and rounded up", r.LengthMultiple, r.PriceMultiplier)
+}
+
+func (r DescriptionLengthRule) Evaluate(receipt models.Receipt) RuleResult {
+	result := RuleResult{Inputs: map[string]string{}}
+	factor := models.DecimalFactor(r.PriceMultiplier)
+	qualifying := 0
+	for i, item := range receipt.Items {
+		trimmedLen := len(strings.TrimSpace(item.ShortDescription))
+		if r.LengthMultiple > 0 && trimmedLen%r.LengthMultiple == 0 {
+			qualifying++
+			if price, err := models.ParseMoney(item.Price); err == nil {
+				result.Points += price.CeilDollars(factor)
+			}
+			result.Inputs[fmt.Sprintf("items[%d].shortDescription", i)] = item.ShortDescription
+			result.Inputs[fmt.Sprintf("items[%d].price", i)] = item.Price
+		}
+	}
+	result.Inputs["qualifyingItems"] = strconv.Itoa(qualifying)
+	return result
+}
+
+// OddDayRule awards points if the day in the purchase date is odd.
+type OddDayRule struct {
+	Points int64 `json:"points" yaml:"points"`
+}
+
+func (OddDayRule) Name() string { return "odd-day" }
+
+func (r OddDayRule) Description() string {
+	return fmt.Sprintf("%s if the day in the purchase date is odd", pointsPhrase(r.Points))
+}
+
+func (r OddDayRule) Evaluate(receipt models.Receipt) RuleResult {
+	result := RuleResult{Inputs: map[string]string{"purchaseDate": receipt.PurchaseDate}}
+	if date, err := time.Parse("2006-01-02", receipt.PurchaseDate); err == nil {
+		if date.Day()%2 == 1 {
+			result.Points = r.Points
+		}
+	}
+	return result
+}
+
+// AfternoonRule awards points if the time of purchase is strictly after Start
+// and strictly before End. Both bounds are 24-hour "15:04" times.
+type AfternoonRule struct {
+	Points int64  `json:"points" yaml:"points"`
+	Start  string `json:"start" yaml:"start"`
+	End    string `json:"end" yaml:"end"`
+}
+
+func (AfternoonRule) Name() string { return "afternoon" }
+
+func (r AfternoonRule) Description() string {
+	return fmt.Sprintf("%s if the time of purchase is after %s and before %s", pointsPhrase(r.Points), r.Start, r.End)
+}
+
+func (r AfternoonRule) Evaluate(receipt models.Receipt) RuleResult {
+	result := RuleResult{Inputs: map[string]string{"purchaseTime": receipt.PurchaseTime}}
+	purchaseTime, err := time.Parse("15:04", receipt.PurchaseTime)
+	if err != nil {
+		return result
+	}
+	start, err := time.Parse("15:04", r.Start)
+	if err != nil {
+		return result
+	}
+	end, err := time.Parse("15:04", r.End)
+	if err != nil {
+		return result
+	}
+	if purchaseTime.After(start) && purchaseTime.Before(end) {
+		result.Points = r.Points
+	}
+	return result
+}
+
+func pointsPhrase(points int64) string {
+	if points == 1 {
+		return "One point"
+	}
+	return fmt.Sprintf("%d points", points)
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"receipt-processor/internal/models"
+	"testing"
+)
+
+type bonusRule struct {
+	name   string
+	points int64
+}
+
+func (r bonusRule) Name() string        { return r.name }
+func (r bonusRule) Description() string { return "flat bonus for testing" }
+func (r bonusRule) Evaluate(receipt models.Receipt) RuleResult {
+	return RuleResult{Points: r.points}
+}
+
+func TestRegistry(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "14:30",
+		Items: []models.Item{
+			{ShortDescription: "123", Price: "1.00"},
+			{ShortDescription: "456", Price: "2.00"},
+			{ShortDescription: "789", Price: "3.00"},
+		},
+		Total: "6.00",
+	}
+
+	t.Run("Builtin Rules Match CalculatePoints", func(t *testing.T) {
+		registry, err := NewRegistry("default", BuiltinRules()...)
+		if err != nil {
+			t.Fatalf("NewRegistry() error = %v", err)
+		}
+		if got, want := registry.CalculatePoints(receipt), CalculatePoints(receipt); got != want {
+			t.Errorf("CalculatePoints() = %d, want %d", got, want)
+		}
+	})
+
+	t.Run("Per Rule Points", func(t *testing.T) {
+		want := map[string]int64{
+			"retailer-name":      6,
+			"round-total":        50,
+			"quarter-multiple":   25,
+			"item-pairs":         5,
+			"description-length": 3,
+			"odd-day":            6,
+			"afternoon":          10,
+		}
+		for _, rule := range BuiltinRules() {
+			if got := rule.Evaluate(receipt).Points; got != want[rule.Name()] {
+				t.Errorf("%s awarded %d points, want %d", rule.Name(), got, want[rule.Name()])
+			}
+		}
+	})
+
+	t.Run("Description Length Without Multiple", func(t *testing.T) {
+		for _, multiple := range []int{0, -3} {
+			rule := DescriptionLengthRule{LengthMultiple: multiple, PriceMultiplier: 0.2}
+			if got := rule.Evaluate(receipt); got.Points != 0 || got.Inputs["qualifyingItems"] != "0" {
+				t.Errorf("LengthMultiple %d: Evaluate() = %+v, want no qualifying items", multiple, got)
+			}
+		}
+	})
+
+	t.Run("Score Breakdown", func(t *testing.T) {
+		registry, _ := NewRegistry("default", BuiltinRules()...)
+		score := registry.Score(receipt)
+		if score.Points != 105 {
+			t.Errorf("Score().Points = %d, want 105", score.Points)
+		}
+		if len(score.Breakdown) != len(BuiltinRules()) {
+			t.Fatalf("got %d breakdown entries, want %d", len(score.Breakdown), len(BuiltinRules()))
+		}
+		var sum int64
+		for _, entry := range score.Breakdown {
+			sum += entry.Points
+			if entry.Description == "" {
+				t.Errorf("%s has no description", entry.Rule)
+			}
+		}
+		if sum != score.Points {
+			t.Errorf("breakdown sums to %d, want %d", sum, score.Points)
+		}
+		retailer := score.Breakdown[0]
+		if retailer.Rule != "retailer-name" || retailer.Inputs["retailer"] != "Target" {
+			t.Errorf("unexpected retailer breakdown %+v", retailer)
+		}
+		description := score.Breakdown[4]
+		if description.Inputs["qualifyingItems"] != "3" || description.Inputs["items[2].price"] != "3.00" {
+			t.Errorf("unexpected description breakdown inputs %v", description.Inputs)
+		}
+	})
+
+	t.Run("Custom Rule", func(t *testing.T) {
+		registry, _ := NewRegistry("default", BuiltinRules()...)
+		if err := registry.Register(bonusRule{name: "partner-bonus", points: 7}); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		if got := registry.CalculatePoints(receipt); got != 112 {
+			t.Errorf("CalculatePoints() = %d, want 112", got)
+		}
+	})
+
+	t.Run("Duplicate Rule Name", func(t *testing.T) {
+		registry, _ := NewRegistry("default", BuiltinRules()...)
+		if err := registry.Register(bonusRule{name: "odd-day"}); err == nil {
+			t.Error("expected error registering a duplicate rule name")
+		}
+		if _, err := NewRegistry("default", OddDayRule{}, OddDayRule{}); err == nil {
+			t.Error("expected error creating a registry with duplicate rule names")
+		}
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		first, _ := NewRegistry("default", BuiltinRules()...)
+		second, _ := NewRegistry("default", BuiltinRules()...)
+		if first.Version() != second.Version() {
+			t.Errorf("identical rule sets have different versions %v and %v", first.Version(), second.Version())
+		}
+		if first.Version().Name != "default" || len(first.Version().Hash) != 64 {
+			t.Errorf("unexpected version %+v", first.Version())
+		}
+		if got := first.Score(receipt).Version; got != first.Version() {
+			t.Errorf("Score().Version = %v, want %v", got, first.Version())
+		}
+
+		config := DefaultConfig()
+		config.Rules.RoundTotal.Points = 51
+		changed, _ := NewRegistryFromConfig(config)
+		if changed.Version().Hash == first.Version().Hash {
+			t.Error("changing a rule parameter should change the version hash")
+		}
+
+		before := second.Version()
+		second.Register(bonusRule{name: "partner-bonus", points: 1})
+		if second.Version().Hash == before.Hash {
+			t.Error("registering a rule should change the version hash")
+		}
+	})
+
+	t.Run("Empty Registry", func(t *testing.T) {
+		registry, _ := NewRegistry("empty")
+		if got := registry.CalculatePoints(receipt); got != 0 {
+			t.Errorf("CalculatePoints() = %d, want 0", got)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"receipt-processor/internal/models"
+	"sync"
+	"sync/atomic"
+)
+
+// Scorer holds the active rule registry and lets it be replaced while the
+// server is running. Each Score call reads the registry once, so a reload
+// never changes the rules under a receipt that is already being scored.
+type Scorer struct {
+	current atomic.Pointer[Registry]
+	load    func() (*Registry, error)
+	// reloadMutex serializes reloads so two concurrent loads can't finish out
+	// of order and leave the older rules active. It also guards history.
+	reloadMutex sync.Mutex
+	// history holds every registry that has been active, oldest first, so
+	// receipts can be re-scored under an earlier rule set.
+	history []*Registry
+}
+
+// NewScorer returns a scorer starting with registry. load builds the
+// replacement registry on Reload; it may be nil if the rules never change.
+func NewScorer(registry *Registry, load func() (*Registry, error)) *Scorer {
+	s := &Scorer{load: load, history: []*Registry{registry}}
+	s.current.Store(registry)
+	return s
+}
+
+// Registry returns the active registry.
+func (s *Scorer) Registry() *Registry {
+	return s.current.Load()
+}
+
+// Score evaluates the receipt against the active registry.
+func (s *Scorer) Score(receipt models.Receipt) models.Score {
+	return s.Registry().Score(receipt)
+}
+
+// Reload builds a new registry and makes it active. On failure the previous
+// registry stays in place and the error is returned.
+func (s *Scorer) Reload() (*Registry, error) {
+	if s.load == nil {
+		return nil, errors.New("rule reloading is not configured")
+	}
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	registry, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(registry)
+	s.remember(registry)
+	return registry, nil
+}
+
+// remember adds registry to the history unless an identical rule set is
+// already there, so repeated reloads of an unchanged file don't pile up.
+// Callers must hold reloadMutex.
+func (s *Scorer) remember(registry *Registry) {
+	version := registry.Version()
+	for i, previous := range s.history {
+		if previous.Version() == version {
+			s.history = append(s.history[:i], s.history[i+1:]...)
+			break
+		}
+	}
+	s.history = append(s.history, registry)
+}
+
+// Lookup finds a rule set this scorer has had active by its version hash or,
+// failing that, by name. When several rule sets share a name the most
+// recently loaded one wins.
+func (s *Scorer) Lookup(version string) (*Registry, bool) {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Version().Hash == version {
+			return s.history[i], true
+		}
+	}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Version().Name == version {
+			return s.history[i], true
+		}
+	}
+	return nil, false
+}
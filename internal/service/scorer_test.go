@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"receipt-processor/internal/models"
+	"sync"
+	"testing"
+)
+
+func TestScorer(t *testing.T) {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []models.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+		},
+		Total: "6.49",
+	}
+
+	t.Run("Reload Swaps Rules", func(t *testing.T) {
+		config := DefaultConfig()
+		config.Rules.OddDay.Points = 100
+		scorer := NewScorer(DefaultRegistry, func() (*Registry, error) {
+			return NewRegistryFromConfig(config)
+		})
+
+		if got := scorer.Score(receipt).Points; got != 12 {
+			t.Fatalf("Score() before reload = %d, want 12", got)
+		}
+		if _, err := scorer.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if got := scorer.Score(receipt).Points; got != 106 {
+			t.Errorf("Score() after reload = %d, want 106", got)
+		}
+	})
+
+	t.Run("Failed Reload Keeps Previous Rules", func(t *testing.T) {
+		scorer := NewScorer(DefaultRegistry, func() (*Registry, error) {
+			return nil, errors.New("bad config")
+		})
+
+		if _, err := scorer.Reload(); err == nil {
+			t.Fatal("expected Reload() to fail")
+		}
+		if scorer.Registry() != DefaultRegistry {
+			t.Error("expected the previous registry to stay active")
+		}
+		if got := scorer.Score(receipt).Points; got != 12 {
+			t.Errorf("Score() after failed reload = %d, want 12", got)
+		}
+	})
+
+	t.Run("Lookup Previous Versions", func(t *testing.T) {
+		config := DefaultConfig()
+		config.Name = "promo"
+		config.Rules.OddDay.Points = 100
+		scorer := NewScorer(DefaultRegistry, func() (*Registry, error) {
+			return NewRegistryFromConfig(config)
+		})
+		promo, err := scorer.Reload()
+		if err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		// Reloading an unchanged config must not add a second history entry.
+		scorer.Reload()
+
+		tests := []struct {
+			version string
+			want    *Registry
+		}{
+			{"default", DefaultRegistry},
+			{DefaultRegistry.Version().Hash, DefaultRegistry},
+			{"promo", scorer.Registry()},
+			{promo.Version().Hash, scorer.Registry()},
+		}
+		for _, tt := range tests {
+			got, exists := scorer.Lookup(tt.version)
+			if !exists || got != tt.want {
+				t.Errorf("Lookup(%q) = %v, %v; want %v", tt.version, got.Version(), exists, tt.want.Version())
+			}
+		}
+		if len(scorer.history) != 2 {
+			t.Errorf("history holds %d rule sets, want 2", len(scorer.history))
+		}
+		if _, exists := scorer.Lookup("unknown"); exists {
+			t.Error("Lookup() found an unknown version")
+		}
+	})
+
+	t.Run("Reload Without Loader", func(t *testing.T) {
+		scorer := NewScorer(DefaultRegistry, nil)
+		if _, err := scorer.Reload(); err == nil {
+			t.Error("expected Reload() to fail without a loader")
+		}
+	})
+
+	t.Run("Concurrent Score And Reload", func(t *testing.T) {
+		config := DefaultConfig()
+		config.Rules.OddDay.Points = 100
+		scorer := NewScorer(DefaultRegistry, func() (*Registry, error) {
+			return NewRegistryFromConfig(config)
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				if got := scorer.Score(receipt).Points; got != 12 && got != 106 {
+					t.Errorf("Score() = %d, want 12 or 106", got)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				scorer.Reload()
+			}()
+		}
+		wg.Wait()
+	})
+}
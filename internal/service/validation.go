@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"receipt-processor/internal/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Validation error codes reported in models.FieldError.Code.
+const (
+	CodeInvalidCharacters = "invalid_characters"
+	CodeInvalidDate       = "invalid_date"
+	CodeInvalidTime       = "invalid_time"
+	CodeInvalidAmount     = "invalid_amount"
+	CodeItemsRequired     = "items_required"
+)
+
+var (
+	retailerPattern    = regexp.MustCompile(`^[\w\s\-&]+$`)
+	descriptionPattern = regexp.MustCompile(`^[\w\s\-]+$`)
+)
+
+// ValidationErrors lists every problem found in a receipt.
+type ValidationErrors []models.FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateReceipt checks every field of the receipt and returns a
+// ValidationErrors holding all problems found, or nil if there are none.
+func ValidateReceipt(receipt models.Receipt) error {
+	var errs ValidationErrors
+	add := func(path, code, message string) {
+		errs = append(errs, models.FieldError{Path: path, Code: code, Message: message})
+	}
+
+	// Validate retailer
+	if !retailerPattern.MatchString(receipt.Retailer) {
+		add("/retailer", CodeInvalidCharacters, "invalid retailer name")
+	}
+
+	// Validate purchase date
+	if _, err := time.Parse("2006-01-02", receipt.PurchaseDate); err != nil {
+		add("/purchaseDate", CodeInvalidDate, "invalid purchase date")
+	}
+
+	// Validate purchase time
+	if _, err := time.Parse("15:04", receipt.PurchaseTime); err != nil {
+		add("/purchaseTime", CodeInvalidTime, "invalid purchase time")
+	}
+
+	// Validate total
+	if _, err := models.ParseMoney(receipt.Total); err != nil {
+		add("/total", CodeInvalidAmount, "invalid total")
+	}
+
+	// Validate items
+	if len(receipt.Items) == 0 {
+		add("/items", CodeItemsRequired, "at least one item is required")
+	}
+
+	for i, item := range receipt.Items {
+		if !descriptionPattern.MatchString(item.ShortDescription) {
+			add(fmt.Sprintf("/items/%d/shortDescription", i), CodeInvalidCharacters, "invalid item description")
+		}
+		if _, err := models.ParseMoney(item.Price); err != nil {
+			add(fmt.Sprintf("/items/%d/price", i), CodeInvalidAmount, "invalid item price")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CodeTotalMismatch reports item prices that don't add up to the total.
+const CodeTotalMismatch = "total_mismatch"
+
+// ConsistencyMode controls what happens to a receipt whose item prices don't
+// add up to its total.
+type ConsistencyMode string
+
+const (
+	// ConsistencyOff skips the check.
+	ConsistencyOff ConsistencyMode = "off"
+	// ConsistencyReject fails validation on a mismatch.
+	ConsistencyReject ConsistencyMode = "reject"
+	// ConsistencyFlag accepts the receipt but reports the mismatch as a flag.
+	ConsistencyFlag ConsistencyMode = "flag"
+)
+
+// ParseConsistencyMode parses "off", "reject" or "flag".
+func ParseConsistencyMode(s string) (ConsistencyMode, error) {
+	switch mode := ConsistencyMode(s); mode {
+	case ConsistencyOff, ConsistencyReject, ConsistencyFlag:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid consistency mode %q: want off, reject or flag", s)
+}
+
+// Validator runs ValidateReceipt plus the optional cross-field checks.
+// The zero value behaves exactly like ValidateReceipt.
+type Validator struct {
+	Consistency ConsistencyMode
+	// Tolerance is how far the total may differ from the sum of the item
+	// prices, in either direction, to allow for tax and rounding.
+	Tolerance models.Money
+}
+
+// Validate checks the receipt. It returns an error if the receipt must be
+// rejected, and otherwise any flags to record alongside it.
+func (v Validator) Validate(receipt models.Receipt) ([]models.FieldError, error) {
+	if err := ValidateReceipt(receipt); err != nil {
+		return nil, err
+	}
+
+	if v.Consistency == "" || v.Consistency == ConsistencyOff {
+		return nil, nil
+	}
+
+	mismatch, ok := v.checkConsistency(receipt)
+	if ok {
+		return nil, nil
+	}
+	if v.Consistency == ConsistencyReject {
+		return nil, ValidationErrors{mismatch}
+	}
+	return []models.FieldError{mismatch}, nil
+}
+
+// checkConsistency compares the total with the sum of the item prices. It
+// expects a receipt that has already passed ValidateReceipt.
+func (v Validator) checkConsistency(receipt models.Receipt) (models.FieldError, bool) {
+	total, _ := models.ParseMoney(receipt.Total)
+	var sum models.Money
+	for _, item := range receipt.Items {
+		price, _ := models.ParseMoney(item.Price)
+		sum += price
+	}
+
+	difference := total - sum
+	if difference < 0 {
+		difference = -difference
+	}
+	if difference <= v.Tolerance {
+		return models.FieldError{}, true
+	}
+	return models.FieldError{
+		Path: "/total",
+		Code: CodeTotalMismatch,
+		Message: fmt.Sprintf("total %s does not match the item prices, which sum to %s (tolerance %s)",
+			total, sum, v.Tolerance),
+	}, false
+}
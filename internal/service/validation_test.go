@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"receipt-processor/internal/models"
+	"testing"
+)
+
+func TestValidateReceiptCollectsAllErrors(t *testing.T) {
+	items := make([]models.Item, 40)
+	for i := range items {
+		items[i] = models.Item{ShortDescription: fmt.Sprintf("Item %d", i), Price: "1.00"}
+	}
+	items[12].Price = "1"
+	items[30].ShortDescription = "Item!!!"
+	items[30].Price = "abc"
+
+	receipt := models.Receipt{
+		Retailer:     "Target!!!",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "25:00",
+		Items:        items,
+		Total:        "40.00",
+	}
+
+	err := ValidateReceipt(receipt)
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Fatalf("ValidateReceipt() error = %v, want ValidationErrors", err)
+	}
+
+	want := ValidationErrors{
+		{Path: "/retailer", Code: CodeInvalidCharacters, Message: "invalid retailer name"},
+		{Path: "/purchaseTime", Code: CodeInvalidTime, Message: "invalid purchase time"},
+		{Path: "/items/12/price", Code: CodeInvalidAmount, Message: "invalid item price"},
+		{Path: "/items/30/shortDescription", Code: CodeInvalidCharacters, Message: "invalid item description"},
+		{Path: "/items/30/price", Code: CodeInvalidAmount, Message: "invalid item price"},
+	}
+	if len(validationErrors) != len(want) {
+		t.Fatalf("got %d errors %v, want %d", len(validationErrors), validationErrors, len(want))
+	}
+	for i := range want {
+		if validationErrors[i] != want[i] {
+			t.Errorf("error %d = %+v, want %+v", i, validationErrors[i], want[i])
+		}
+	}
+
+	if got := err.Error(); got != "invalid retailer name; invalid purchase time; invalid item price; invalid item description; invalid item price" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestValidateReceiptEmptyReceipt(t *testing.T) {
+	err := ValidateReceipt(models.Receipt{})
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Fatalf("ValidateReceipt() error = %v, want ValidationErrors", err)
+	}
+
+	paths := map[string]bool{}
+	for _, fieldError := range validationErrors {
+		paths[fieldError.Path] = true
+	}
+	for _, path := range []string{"/retailer", "/purchaseDate", "/purchaseTime", "/total", "/items"} {
+		if !paths[path] {
+			t.Errorf("missing error for %s in %v", path, validationErrors)
+		}
+	}
+}
+
+func TestValidatorConsistency(t *testing.T) {
+	receipt := func(total string, prices ...string) models.Receipt {
+		items := make([]models.Item, len(prices))
+		for i, price := range prices {
+			items[i] = models.Item{ShortDescription: "Item", Price: price}
+		}
+		return models.Receipt{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Items:        items,
+			Total:        total,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		validator Validator
+		receipt   models.Receipt
+		wantError bool
+		wantFlag  bool
+	}{
+		{"zero value skips check", Validator{}, receipt("500.00", "1.00", "2.00"), false, false},
+		{"off skips check", Validator{Consistency: ConsistencyOff}, receipt("500.00", "3.00"), false, false},
+		{"reject exact match", Validator{Consistency: ConsistencyReject}, receipt("3.00", "1.00", "2.00"), false, false},
+		{"reject mismatch", Validator{Consistency: ConsistencyReject}, receipt("500.00", "1.00", "2.00"), true, false},
+		{"reject within tolerance", Validator{Consistency: ConsistencyReject, Tolerance: 25}, receipt("3.25", "1.00", "2.00"), false, false},
+		{"reject total below items", Validator{Consistency: ConsistencyReject, Tolerance: 25}, receipt("2.70", "1.00", "2.00"), true, false},
+		{"flag mismatch", Validator{Consistency: ConsistencyFlag}, receipt("500.00", "1.00", "2.00"), false, true},
+		{"flag within tolerance", Validator{Consistency: ConsistencyFlag, Tolerance: 50}, receipt("3.50", "3.00"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, err := tt.validator.Validate(tt.receipt)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Validate() error = %v, wantError %v", err, tt.wantError)
+			}
+			if (len(flags) > 0) != tt.wantFlag {
+				t.Fatalf("Validate() flags = %v, wantFlag %v", flags, tt.wantFlag)
+			}
+
+			var mismatch models.FieldError
+			var validationErrors ValidationErrors
+			if errors.As(err, &validationErrors) {
+				mismatch = validationErrors[0]
+			} else if len(flags) > 0 {
+				mismatch = flags[0]
+			} else {
+				return
+			}
+			if mismatch.Path != "/total" || mismatch.Code != CodeTotalMismatch {
+				t.Errorf("unexpected mismatch error %+v", mismatch)
+			}
+		})
+	}
+
+	t.Run("field errors take precedence", func(t *testing.T) {
+		validator := Validator{Consistency: ConsistencyFlag}
+		flags, err := validator.Validate(receipt("500.00", "1"))
+		if err == nil || len(flags) != 0 {
+			t.Errorf("Validate() = %v, %v; want only a field error", flags, err)
+		}
+	})
+}
+
+func TestParseConsistencyMode(t *testing.T) {
+	for _, mode := range []string{"off", "reject", "flag"} {
+		if got, err := ParseConsistencyMode(mode); err != nil || string(got) != mode {
+			t.Errorf("ParseConsistencyMode(%q) = %q, %v", mode, got, err)
+		}
+	}
+	if _, err := ParseConsistencyMode("warn"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
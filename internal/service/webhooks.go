@@ -0,0 +1,547 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"receipt-processor/internal/models"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers sent with every webhook delivery. The delivery ID stays the same
+// across retries, so receivers can drop events they have already handled.
+const (
+	WebhookIDHeader        = "Webhook-Id"
+	WebhookEventHeader     = "Webhook-Event"
+	WebhookSignatureHeader = "Webhook-Signature"
+)
+
+// Webhook validation error codes reported in models.FieldError.Code.
+const (
+	CodeInvalidURL   = "invalid_url"
+	CodeUnknownEvent = "unknown_event"
+)
+
+const (
+	// webhookQueueSize is how many deliveries may wait for a worker before
+	// further ones are dead-lettered straight away.
+	webhookQueueSize = 1000
+	webhookWorkers   = 4
+	// webhookTimeout bounds one delivery attempt when no client is given.
+	webhookTimeout = 10 * time.Second
+	// maxBackoff caps the wait between two attempts.
+	maxBackoff = time.Hour
+	// deliveryLogSize is how many attempts are kept per webhook, and
+	// deadLetterLimit how many dead letters are kept in all.
+	deliveryLogSize = 1000
+	deadLetterLimit = 1000
+)
+
+// webhookEvents lists the event types a webhook can subscribe to.
+var webhookEvents = []string{models.EventReceiptScored}
+
+var (
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	// ErrWebhookQueueFull is returned by Redeliver when too many deliveries
+	// are already waiting.
+	ErrWebhookQueueFull = errors.New("webhook delivery queue is full")
+	// ErrPrivateTarget is returned for a delivery whose receiver resolved to
+	// an address webhooks may not reach.
+	ErrPrivateTarget = errors.New("webhook receiver has a private, loopback or link-local address")
+)
+
+// RetryPolicy says how often a failed delivery is retried.
+type RetryPolicy struct {
+	// Attempts is how many times a delivery is tried before it is
+	// dead-lettered.
+	Attempts int
+	// Backoff is the wait before the first retry. Each later retry waits
+	// twice as long as the one before, up to an hour.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy tries a delivery 6 times over about five minutes.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 6, Backoff: 10 * time.Second}
+
+// delay returns how long to wait after the given attempt failed.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for n := 1; n < attempt && delay < maxBackoff; n++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// Webhooks holds webhook subscriptions and delivers events to them in the
+// background, so Publish never waits on a receiver. Subscriptions, the
+// delivery log and dead letters are kept in memory.
+type Webhooks struct {
+	client *http.Client
+	retry  RetryPolicy
+	// allowPrivate lets webhooks reach private, loopback and link-local
+	// addresses.
+	allowPrivate bool
+	start        sync.Once
+	pending      chan *delivery
+
+	mutex    sync.Mutex
+	webhooks map[string]*webhook
+	// order holds webhook IDs in the order they were created.
+	order       []string
+	deadLetters []models.DeadLetter
+}
+
+type webhook struct {
+	models.Webhook
+	// deliveries holds the latest attempts, oldest first.
+	deliveries []models.WebhookDelivery
+}
+
+// delivery is one event on its way to one webhook.
+type delivery struct {
+	id        string
+	webhookID string
+	event     models.ReceiptEvent
+	body      []byte
+	// attempts counts the attempts made so far.
+	attempts int
+}
+
+// WebhookOption configures Webhooks.
+type WebhookOption func(*Webhooks)
+
+// AllowPrivateWebhooks lets webhooks point at private, loopback and
+// link-local addresses, such as receivers on the same host or network.
+// Without it, anyone who can create a webhook can make the server send
+// requests into its own network.
+func AllowPrivateWebhooks() WebhookOption {
+	return func(w *Webhooks) {
+		w.allowPrivate = true
+	}
+}
+
+// NewWebhooks returns a dispatcher that sends deliveries with client, or if
+// client is nil with one that times out after 10 seconds and, unless
+// AllowPrivateWebhooks is given, refuses to connect to private addresses. A
+// client passed in is used as is.
+func NewWebhooks(client *http.Client, retry RetryPolicy, opts ...WebhookOption) *Webhooks {
+	w := &Webhooks{
+		client:   client,
+		retry:    retry,
+		pending:  make(chan *delivery, webhookQueueSize),
+		webhooks: make(map[string]*webhook),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.client == nil {
+		w.client = &http.Client{Timeout: webhookTimeout, Transport: w.transport()}
+	}
+	return w
+}
+
+// transport returns the transport of the default client. The address check
+// runs when connecting, after DNS, so it also covers redirects and host
+// names that resolve to a private address.
+func (w *Webhooks) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if w.allowPrivate {
+		return transport
+	}
+	// A proxy would be dialed instead of the receiver, hiding its address.
+	transport.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return ErrPrivateTarget
+			}
+			return nil
+		},
+	}
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// privateIP reports whether ip is an address webhooks may only reach with
+// AllowPrivateWebhooks: loopback, private, link-local, multicast or
+// unspecified.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// privateHost reports whether host is an address or name that obviously
+// points at a private address. Other names are checked when connecting.
+func privateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && privateIP(ip)
+}
+
+// Subscribe validates request and adds a webhook for it. Unless
+// AllowPrivateWebhooks was given, URLs naming a private address are
+// refused. It returns a ValidationErrors naming every invalid field. The returned webhook is the
+// only one that carries the secret.
+func (w *Webhooks) Subscribe(request models.WebhookRequest) (models.Webhook, error) {
+	var errs ValidationErrors
+	if target, err := url.Parse(request.URL); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		errs = append(errs, models.FieldError{Path: "/url", Code: CodeInvalidURL, Message: "url must be an absolute http or https URL"})
+	} else if !w.allowPrivate && privateHost(target.Hostname()) {
+		errs = append(errs, models.FieldError{Path: "/url", Code: CodeInvalidURL, Message: "url must not point at a private, loopback or link-local address"})
+	}
+	events := request.Events
+	if len(events) == 0 {
+		events = webhookEvents
+	}
+	for i, event := range events {
+		if !knownEvent(event) {
+			errs = append(errs, models.FieldError{
+				Path:    fmt.Sprintf("/events/%d", i),
+				Code:    CodeUnknownEvent,
+				Message: fmt.Sprintf("unknown event type %q", event),
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return models.Webhook{}, errs
+	}
+
+	secret := request.Secret
+	if secret == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return models.Webhook{}, fmt.Errorf("generate webhook secret: %w", err)
+		}
+		secret = hex.EncodeToString(random)
+	}
+
+	hook := &webhook{Webhook: models.Webhook{
+		ID:        uuid.New().String(),
+		URL:       request.URL,
+		Events:    append([]string(nil), events...),
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.webhooks[hook.ID] = hook
+	w.order = append(w.order, hook.ID)
+	return hook.Webhook, nil
+}
+
+func knownEvent(event string) bool {
+	for _, known := range webhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhooks lists the subscriptions in the order they were created, without
+// their secrets.
+func (w *Webhooks) Webhooks() []models.Webhook {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	webhooks := make([]models.Webhook, 0, len(w.order))
+	for _, id := range w.order {
+		webhooks = append(webhooks, w.webhooks[id].public())
+	}
+	return webhooks
+}
+
+// Webhook returns one subscription without its secret.
+func (w *Webhooks) Webhook(id string) (models.Webhook, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	hook, ok := w.webhooks[id]
+	if !ok {
+		return models.Webhook{}, ErrWebhookNotFound
+	}
+	return hook.public(), nil
+}
+
+func (h *webhook) public() models.Webhook {
+	public := h.Webhook
+	public.Secret = ""
+	return public
+}
+
+// Unsubscribe removes a webhook with its delivery log and dead letters.
+// Deliveries still being retried are dropped.
+func (w *Webhooks) Unsubscribe(id string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, ok := w.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(w.webhooks, id)
+	for i, ordered := range w.order {
+		if ordered == id {
+			w.order = append(w.order[:i], w.order[i+1:]...)
+			break
+		}
+	}
+	kept := w.deadLetters[:0]
+	for _, letter := range w.deadLetters {
+		if letter.WebhookID != id {
+			kept = append(kept, letter)
+		}
+	}
+	w.deadLetters = kept
+	return nil
+}
+
+// Deliveries returns the latest delivery attempts to a webhook, oldest
+// first.
+func (w *Webhooks) Deliveries(id string) ([]models.WebhookDelivery, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	hook, ok := w.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return append([]models.WebhookDelivery{}, hook.deliveries...), nil
+}
+
+// DeadLetters returns the events that couldn't be delivered, oldest first.
+// A non-empty webhookID limits them to one webhook.
+func (w *Webhooks) DeadLetters(webhookID string) []models.DeadLetter {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	letters := []models.DeadLetter{}
+	for _, letter := range w.deadLetters {
+		if webhookID == "" || letter.WebhookID == webhookID {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
+
+// Redeliver takes a dead letter off the list and queues its event for
+// delivery again, with a fresh set of attempts.
+func (w *Webhooks) Redeliver(id string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for i, letter := range w.deadLetters {
+		if letter.ID != id {
+			continue
+		}
+		if _, ok := w.webhooks[letter.WebhookID]; !ok {
+			return ErrWebhookNotFound
+		}
+		body, err := json.Marshal(letter.Event)
+		if err != nil {
+			return fmt.Errorf("encode event %s: %w", letter.Event.ID, err)
+		}
+		if !w.enqueue(&delivery{id: letter.ID, webhookID: letter.WebhookID, event: letter.Event, body: body}) {
+			return ErrWebhookQueueFull
+		}
+		w.deadLetters = append(w.deadLetters[:i], w.deadLetters[i+1:]...)
+		return nil
+	}
+	return ErrDeadLetterNotFound
+}
+
+// Publish queues event for every webhook subscribed to its type. If the
+// queue is full the delivery is dead-lettered instead of waiting.
+func (w *Webhooks) Publish(event models.ReceiptEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event %s: %v", event.Type, event.ID, err)
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, id := range w.order {
+		if !w.webhooks[id].subscribed(event.Type) {
+			continue
+		}
+		d := &delivery{id: uuid.New().String(), webhookID: id, event: event, body: body}
+		if !w.enqueue(d) {
+			w.deadLetter(d, ErrWebhookQueueFull.Error())
+		}
+	}
+}
+
+func (h *webhook) subscribed(eventType string) bool {
+	for _, event := range h.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue queues d for a worker, starting the workers with the first
+// delivery, and reports false if the queue is full.
+func (w *Webhooks) enqueue(d *delivery) bool {
+	w.start.Do(func() {
+		for n := 0; n < webhookWorkers; n++ {
+			go w.work()
+		}
+	})
+	select {
+	case w.pending <- d:
+		return true
+	default:
+		return false
+	}
+}
+
+// work delivers queued events until the process exits. A failed delivery is
+// put back on the queue after its backoff rather than holding up the worker.
+func (w *Webhooks) work() {
+	for d := range w.pending {
+		w.mutex.Lock()
+		hook, ok := w.webhooks[d.webhookID]
+		var target, secret string
+		if ok {
+			target, secret = hook.URL, hook.Secret
+		}
+		w.mutex.Unlock()
+		if !ok {
+			// Unsubscribed since the event was published.
+			continue
+		}
+
+		d.attempts++
+		attempted := time.Now().UTC()
+		status, err := w.post(target, secret, d)
+		attempt := models.WebhookDelivery{
+			ID:          d.id,
+			WebhookID:   d.webhookID,
+			EventID:     d.event.ID,
+			EventType:   d.event.Type,
+			Attempt:     d.attempts,
+			Succeeded:   err == nil,
+			StatusCode:  status,
+			AttemptedAt: attempted,
+			DurationMs:  time.Since(attempted).Milliseconds(),
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		if !w.record(attempt) || err == nil {
+			continue
+		}
+
+		if d.attempts >= w.retry.Attempts {
+			w.mutex.Lock()
+			w.deadLetter(d, err.Error())
+			w.mutex.Unlock()
+			continue
+		}
+		w.retryLater(d)
+	}
+}
+
+// retryLater queues d again after its backoff. If the queue is full by then,
+// d is dead-lettered rather than waiting for room.
+func (w *Webhooks) retryLater(d *delivery) {
+	time.AfterFunc(w.retry.delay(d.attempts), func() {
+		if w.enqueue(d) {
+			return
+		}
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if _, ok := w.webhooks[d.webhookID]; ok {
+			w.deadLetter(d, ErrWebhookQueueFull.Error())
+		}
+	})
+}
+
+// post sends one attempt of d and returns the receiver's status code, if it
+// answered, and an error unless it answered with a 2xx status.
+func (w *Webhooks) post(target, secret string, d *delivery) (int, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, d.id)
+	req.Header.Set(WebhookEventHeader, d.event.Type)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, time.Now(), d.body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// Drain some of the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record adds attempt to its webhook's delivery log, and reports false if
+// the webhook no longer exists.
+func (w *Webhooks) record(attempt models.WebhookDelivery) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	hook, ok := w.webhooks[attempt.WebhookID]
+	if !ok {
+		return false
+	}
+	if len(hook.deliveries) == deliveryLogSize {
+		hook.deliveries = append(hook.deliveries[:0], hook.deliveries[1:]...)
+	}
+	hook.deliveries = append(hook.deliveries, attempt)
+	return true
+}
+
+// deadLetter gives up on d. Callers must hold mutex.
+func (w *Webhooks) deadLetter(d *delivery, reason string) {
+	log.Printf("Giving up on delivering %s event %s to webhook %s after %d attempts: %s",
+		d.event.Type, d.event.ID, d.webhookID, d.attempts, reason)
+	if len(w.deadLetters) == deadLetterLimit {
+		w.deadLetters = append(w.deadLetters[:0], w.deadLetters[1:]...)
+	}
+	w.deadLetters = append(w.deadLetters, models.DeadLetter{
+		ID:        d.id,
+		WebhookID: d.webhookID,
+		Event:     d.event,
+		Attempts:  d.attempts,
+		LastError: reason,
+		FailedAt:  time.Now().UTC(),
+	})
+}
+
+// SignWebhook returns the Webhook-Signature header for body sent at
+// timestamp: "t=<unix seconds>,v1=<hex HMAC-SHA256>", where the HMAC is
+// keyed with the webhook's secret and covers "<unix seconds>.<body>".
+// Receivers should recompute it and reject old timestamps.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	unix := fmt.Sprint(timestamp.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
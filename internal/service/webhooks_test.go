@@ -0,0 +1,302 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"receipt-processor/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is an httptest webhook receiver that answers with the queued
+// statuses, then 200, and keeps what it was sent.
+type receiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses, received: make(chan struct{}, 100)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mutex.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mutex.Unlock()
+		w.WriteHeader(status)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// wait blocks until the receiver has been sent n more requests.
+func (r *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("receiver got %d of %d requests", i, n)
+		}
+	}
+}
+
+// eventually polls condition until it holds.
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met after 5s")
+		}
+	}
+}
+
+func scoredEvent(id string) models.ReceiptEvent {
+	return models.ReceiptEvent{
+		ID:        id,
+		Type:      models.EventReceiptScored,
+		CreatedAt: time.Now().UTC(),
+		Data:      models.ReceiptEventData{ReceiptID: "receipt-" + id, Revision: 1, Points: 28},
+	}
+}
+
+var fastRetry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Run("Signed Payload", func(t *testing.T) {
+		receiver := newReceiver(t)
+		webhooks := NewWebhooks(nil, fastRetry, AllowPrivateWebhooks())
+		hook, err := webhooks.Subscribe(models.WebhookRequest{URL: receiver.URL, Secret: "s3cret"})
+		if err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+
+		webhooks.Publish(scoredEvent("evt-1"))
+		receiver.wait(t, 1)
+
+		req, body := receiver.requests[0], receiver.bodies[0]
+		var event models.ReceiptEvent
+		if err := json.Unmarshal(body, &event); err != nil || event.ID != "evt-1" || event.Data.Points != 28 {
+			t.Errorf("unexpected payload %s", body)
+		}
+		if req.Header.Get(WebhookEventHeader) != models.EventReceiptScored || req.Header.Get(WebhookIDHeader) == "" {
+			t.Errorf("unexpected headers %v", req.Header)
+		}
+
+		// Verify the signature the way a receiver would.
+		signature := req.Header.Get(WebhookSignatureHeader)
+		fields := strings.SplitN(strings.TrimPrefix(signature, "t="), ",", 2)
+		unix, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			t.Fatalf("malformed signature %q", signature)
+		}
+		if want := SignWebhook("s3cret", time.Unix(unix, 0), body); signature != want {
+			t.Errorf("signature = %q, want %q", signature, want)
+		}
+		if SignWebhook("other", time.Unix(unix, 0), body) == signature {
+			t.Error("signature doesn't depend on the secret")
+		}
+
+		eventually(t, func() bool {
+			deliveries, _ := webhooks.Deliveries(hook.ID)
+			return len(deliveries) == 1
+		})
+		deliveries, _ := webhooks.Deliveries(hook.ID)
+		if d := deliveries[0]; !d.Succeeded || d.StatusCode != http.StatusOK || d.Attempt != 1 || d.EventID != "evt-1" {
+			t.Errorf("unexpected delivery log entry %+v", d)
+		}
+	})
+
+	t.Run("Retries Until Success", func(t *testing.T) {
+		receiver := newReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+		webhooks := NewWebhooks(nil, fastRetry, AllowPrivateWebhooks())
+		hook, _ := webhooks.Subscribe(models.WebhookRequest{URL: receiver.URL})
+
+		webhooks.Publish(scoredEvent("evt-1"))
+		receiver.wait(t, 3)
+
+		eventually(t, func() bool {
+			deliveries, _ := webhooks.Deliveries(hook.ID)
+			return len(deliveries) == 3
+		})
+		deliveries, _ := webhooks.Deliveries(hook.ID)
+		for i, d := range deliveries {
+			if d.Attempt != i+1 || d.Succeeded != (i == 2) || d.ID != deliveries[0].ID {
+				t.Errorf("unexpected attempt %d: %+v", i+1, d)
+			}
+		}
+		if deliveries[0].StatusCode != http.StatusInternalServerError || deliveries[0].Error == "" {
+			t.Errorf("first attempt %+v doesn't record the failure", deliveries[0])
+		}
+		if ids := receiver.requests[0].Header.Get(WebhookIDHeader); ids != receiver.requests[2].Header.Get(WebhookIDHeader) {
+			t.Error("retries were sent with a different Webhook-Id")
+		}
+		if letters := webhooks.DeadLetters(""); len(letters) != 0 {
+			t.Errorf("unexpected dead letters %+v", letters)
+		}
+	})
+
+	t.Run("Dead Letter And Redeliver", func(t *testing.T) {
+		receiver := newReceiver(t, 500, 500, 500)
+		webhooks := NewWebhooks(nil, fastRetry, AllowPrivateWebhooks())
+		hook, _ := webhooks.Subscribe(models.WebhookRequest{URL: receiver.URL})
+		other, _ := webhooks.Subscribe(models.WebhookRequest{URL: receiver.URL})
+
+		webhooks.Publish(scoredEvent("evt-1"))
+		webhooks.Unsubscribe(other.ID)
+		receiver.wait(t, 3)
+
+		eventually(t, func() bool { return len(webhooks.DeadLetters("")) == 1 })
+		letter := webhooks.DeadLetters(hook.ID)[0]
+		if letter.Attempts != 3 || letter.Event.ID != "evt-1" || letter.LastError == "" {
+			t.Errorf("unexpected dead letter %+v", letter)
+		}
+		if letters := webhooks.DeadLetters("someone-else"); len(letters) != 0 {
+			t.Errorf("filtering by webhook returned %+v", letters)
+		}
+
+		if err := webhooks.Redeliver(letter.ID); err != nil {
+			t.Fatalf("Redeliver() error = %v", err)
+		}
+		receiver.wait(t, 1)
+		if len(webhooks.DeadLetters("")) != 0 {
+			t.Error("redelivered event is still a dead letter")
+		}
+		if err := webhooks.Redeliver(letter.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+			t.Errorf("second Redeliver() error = %v, want ErrDeadLetterNotFound", err)
+		}
+	})
+
+	t.Run("Retry Finds Queue Full", func(t *testing.T) {
+		webhooks := NewWebhooks(nil, fastRetry, AllowPrivateWebhooks())
+		hook, _ := webhooks.Subscribe(models.WebhookRequest{URL: newReceiver(t).URL})
+		// No workers and a queue without room.
+		webhooks.start.Do(func() {})
+		webhooks.pending = make(chan *delivery)
+
+		webhooks.retryLater(&delivery{id: "d-1", webhookID: hook.ID, event: scoredEvent("evt-1"), attempts: 1})
+		eventually(t, func() bool { return len(webhooks.DeadLetters("")) == 1 })
+		if letter := webhooks.DeadLetters("")[0]; letter.ID != "d-1" || letter.LastError != ErrWebhookQueueFull.Error() {
+			t.Errorf("unexpected dead letter %+v", letter)
+		}
+	})
+
+	t.Run("Only Subscribed Events", func(t *testing.T) {
+		receiver := newReceiver(t)
+		webhooks := NewWebhooks(nil, fastRetry, AllowPrivateWebhooks())
+		webhooks.Subscribe(models.WebhookRequest{URL: receiver.URL})
+
+		event := scoredEvent("evt-1")
+		event.Type = "receipt.other"
+		webhooks.Publish(event)
+		webhooks.Publish(scoredEvent("evt-2"))
+		receiver.wait(t, 1)
+		if body := string(receiver.bodies[0]); !strings.Contains(body, "evt-2") {
+			t.Errorf("receiver got %s, want only evt-2", body)
+		}
+	})
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	webhooks := NewWebhooks(nil, DefaultRetryPolicy)
+
+	_, err := webhooks.Subscribe(models.WebhookRequest{URL: "ftp://example.com", Events: []string{models.EventReceiptScored, "receipt.eaten"}})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Code != CodeInvalidURL || errs[1].Path != "/events/1" {
+		t.Fatalf("Subscribe() of an invalid webhook error = %v", err)
+	}
+
+	first, _ := webhooks.Subscribe(models.WebhookRequest{URL: "https://example.com/a"})
+	second, _ := webhooks.Subscribe(models.WebhookRequest{URL: "https://example.com/b", Secret: "given"})
+	if len(first.Secret) != 64 || second.Secret != "given" {
+		t.Errorf("secrets = %q, %q; want a generated one and the given one", first.Secret, second.Secret)
+	}
+	if len(first.Events) != 1 || first.Events[0] != models.EventReceiptScored {
+		t.Errorf("default events = %v", first.Events)
+	}
+
+	list := webhooks.Webhooks()
+	if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID || list[0].Secret != "" {
+		t.Errorf("Webhooks() = %+v, want both in order without secrets", list)
+	}
+	if got, err := webhooks.Webhook(second.ID); err != nil || got.URL != second.URL || got.Secret != "" {
+		t.Errorf("Webhook() = %+v, %v", got, err)
+	}
+
+	if err := webhooks.Unsubscribe(first.ID); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	if _, err := webhooks.Webhook(first.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Webhook() after Unsubscribe() error = %v, want ErrWebhookNotFound", err)
+	}
+	if err := webhooks.Unsubscribe(first.ID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("second Unsubscribe() error = %v, want ErrWebhookNotFound", err)
+	}
+}
+
+func TestWebhookPrivateTargets(t *testing.T) {
+	t.Run("Refused By Default", func(t *testing.T) {
+		webhooks := NewWebhooks(nil, DefaultRetryPolicy)
+		for _, target := range []string{
+			"http://127.0.0.1:8080/hook",
+			"http://localhost/hook",
+			"http://api.localhost/hook",
+			"http://10.1.2.3/hook",
+			"http://192.168.0.1/hook",
+			"http://169.254.169.254/latest/meta-data",
+			"http://[::1]/hook",
+			"http://[fd00::1]/hook",
+			"http://0.0.0.0/hook",
+		} {
+			_, err := webhooks.Subscribe(models.WebhookRequest{URL: target})
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Code != CodeInvalidURL {
+				t.Errorf("Subscribe(%s) error = %v, want an invalid URL", target, err)
+			}
+		}
+		if _, err := webhooks.Subscribe(models.WebhookRequest{URL: "https://93.184.216.34/hook"}); err != nil {
+			t.Errorf("Subscribe() of a public address error = %v", err)
+		}
+
+		// Names that resolve to a private address are caught when
+		// connecting.
+		receiver := newReceiver(t)
+		if _, err := webhooks.client.Post(receiver.URL, "application/json", nil); !errors.Is(err, ErrPrivateTarget) {
+			t.Errorf("delivering to %s error = %v, want ErrPrivateTarget", receiver.URL, err)
+		}
+	})
+
+	t.Run("Allowed By Option", func(t *testing.T) {
+		receiver := newReceiver(t)
+		webhooks := NewWebhooks(nil, fastRetry, AllowPrivateWebhooks())
+		if _, err := webhooks.Subscribe(models.WebhookRequest{URL: receiver.URL}); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+		webhooks.Publish(scoredEvent("evt-1"))
+		receiver.wait(t, 1)
+	})
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Attempts: 100, Backoff: time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 90: time.Hour} {
+		if got := policy.delay(attempt); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
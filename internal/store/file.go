@@ -0,0 +1,445 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"receipt-processor/internal/models"
+	"sync"
+	"time"
+)
+
+// FileStore is a Store that survives restarts. Records are served from
+// memory, and every change is first appended to a write-ahead log on disk and
+// fsynced. Opening the store replays the log.
+//
+// Each log entry is framed as a 4-byte big-endian payload length, a 4-byte
+// CRC-32C of the payload, then the JSON payload. A crash mid-append leaves a
+// short or mismatched final frame, which OpenFileStore discards. Damage
+// anywhere else is reported instead.
+//
+// Every entry carries a sequence number. After a number of appends the store
+// writes a snapshot of all records and truncates the log, so startup loads the
+// newest snapshot and replays only the entries after it. Erase snapshots
+// straight away, so the erased data doesn't linger in the log.
+type FileStore struct {
+	memory *ReceiptStore
+	file   *os.File
+	path   string
+	// offset is the end of the last complete entry in the log.
+	offset int64
+	// seq is the sequence number of the last applied entry.
+	seq uint64
+	// snapshotEvery is how many appends trigger a snapshot; 0 disables them.
+	snapshotEvery int
+	// sinceSnapshot counts appends since the last snapshot attempt.
+	sinceSnapshot int
+	// mutex serializes appends so the log order matches the order changes
+	// are applied in memory.
+	mutex sync.Mutex
+}
+
+// FileOption configures a FileStore.
+type FileOption func(*FileStore)
+
+// defaultSnapshotEvery is how many log entries accumulate before a snapshot
+// unless WithSnapshotEvery says otherwise.
+const defaultSnapshotEvery = 1000
+
+// WithSnapshotEvery snapshots the store and truncates the log after every n
+// appends. Zero or less turns automatic snapshots off.
+func WithSnapshotEvery(n int) FileOption {
+	return func(s *FileStore) {
+		s.snapshotEvery = n
+	}
+}
+
+var _ Store = (*FileStore)(nil)
+
+const (
+	frameHeaderSize = 8
+	// maxEntrySize bounds a single entry so a corrupt length field can't
+	// trigger a huge allocation during replay.
+	maxEntrySize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type logOp string
+
+const (
+	opSave   logOp = "save"
+	opDelete logOp = "delete"
+	opErase  logOp = "erase"
+	opAmend  logOp = "amend"
+	opKey    logOp = "key"
+	// opRescore carries the record as rescored, like opSave, but only
+	// replaces its score.
+	opRescore logOp = "rescore"
+)
+
+// logEntry is the payload of one log frame. Seq is zero in logs written before
+// entries were numbered; replay numbers those in order.
+type logEntry struct {
+	Seq    uint64  `json:"seq,omitempty"`
+	Op     logOp   `json:"op"`
+	Record *Record `json:"record,omitempty"`
+	ID     string  `json:"id,omitempty"`
+	// Action and At describe an erase.
+	Action models.AuditAction `json:"action,omitempty"`
+	At     *time.Time         `json:"at,omitempty"`
+	// Key is a saved idempotency key.
+	Key *IdempotencyKey `json:"key,omitempty"`
+}
+
+// OpenFileStore opens the log at path, creating it if needed, loads the newest
+// snapshot next to it and replays the log entries after that snapshot. A torn
+// final entry left by a crash is truncated away, but a damaged entry followed
+// by intact ones makes it fail.
+func OpenFileStore(path string, opts ...FileOption) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open receipt log: %w", err)
+	}
+
+	s := &FileStore{memory: NewStore(), file: file, path: path, snapshotEvery: defaultSnapshotEvery}
+	for _, opt := range opts {
+		opt(s)
+	}
+	damaged, err := s.loadSnapshot()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if s.seq < damaged {
+		file.Close()
+		return nil, fmt.Errorf("receipt snapshot %s is damaged and the log no longer holds its entries", s.snapshotPath(damaged))
+	}
+	return s, nil
+}
+
+// replay applies every intact entry in the log that the snapshot doesn't
+// already cover, and truncates anything after the last one.
+func (s *FileStore) replay() error {
+	reader := bufio.NewReader(s.file)
+	snapshotSeq := s.seq
+	var offset int64
+	for {
+		entry, size, err := readFrame(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Only a damaged tail can be a crash mid-append. Damage with
+			// intact entries after it is corruption, and truncating there
+			// would throw committed receipts away.
+			intact, scanErr := s.intactFrameAfter(offset)
+			if scanErr != nil {
+				return scanErr
+			}
+			if intact >= 0 {
+				return fmt.Errorf("receipt log %s is corrupt at offset %d (%v) but has intact entries from offset %d; refusing to truncate it",
+					s.path, offset, err, intact)
+			}
+			log.Printf("Receipt log %s: discarding torn entry at offset %d: %v", s.file.Name(), offset, err)
+			if err := s.file.Truncate(offset); err != nil {
+				return fmt.Errorf("truncate receipt log: %w", err)
+			}
+			if err := s.file.Sync(); err != nil {
+				return fmt.Errorf("sync receipt log: %w", err)
+			}
+			break
+		}
+		offset += size
+
+		if entry.Seq == 0 {
+			entry.Seq = s.seq + 1
+		}
+		if entry.Seq <= snapshotSeq {
+			continue
+		}
+		if entry.Seq != s.seq+1 {
+			return fmt.Errorf("receipt log %s: entry %d follows %d, entries are missing", s.path, entry.Seq, s.seq)
+		}
+		s.apply(entry)
+	}
+
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek receipt log: %w", err)
+	}
+	s.offset = offset
+	return nil
+}
+
+// intactFrameAfter returns the offset of the first intact frame that starts
+// after the damaged one at offset, or -1 if there is none.
+func (s *FileStore) intactFrameAfter(offset int64) (int64, error) {
+	info, err := s.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat receipt log: %w", err)
+	}
+	rest := make([]byte, info.Size()-offset)
+	if _, err := s.file.ReadAt(rest, offset); err != nil {
+		return 0, fmt.Errorf("read receipt log: %w", err)
+	}
+	for i := 1; i+frameHeaderSize < len(rest); i++ {
+		// Every payload is a JSON object, which rules out most offsets
+		// before the checksum has to be computed.
+		length := int(binary.BigEndian.Uint32(rest[i : i+4]))
+		start := i + frameHeaderSize
+		if length == 0 || length > len(rest)-start || rest[start] != '{' {
+			continue
+		}
+		payload := rest[start : start+length]
+		if crc32.Checksum(payload, crcTable) == binary.BigEndian.Uint32(rest[i+4:i+8]) && json.Valid(payload) {
+			return offset + int64(i), nil
+		}
+	}
+	return -1, nil
+}
+
+// readFrame reads one framed entry and returns it with its size on disk. It
+// returns io.EOF only when the log ends cleanly between frames.
+func readFrame(reader io.Reader) (logEntry, int64, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF {
+			return logEntry{}, 0, io.EOF
+		}
+		return logEntry{}, 0, fmt.Errorf("short header: %w", err)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxEntrySize {
+		return logEntry{}, 0, fmt.Errorf("entry length %d exceeds limit", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return logEntry{}, 0, fmt.Errorf("short payload: %w", err)
+	}
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return logEntry{}, 0, errors.New("checksum mismatch")
+	}
+
+	var entry logEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return logEntry{}, 0, fmt.Errorf("decode entry: %w", err)
+	}
+	return entry, int64(frameHeaderSize + len(payload)), nil
+}
+
+func (s *FileStore) apply(entry logEntry) {
+	switch entry.Op {
+	case opSave:
+		if entry.Record != nil {
+			s.memory.Save(*entry.Record)
+		}
+	case opDelete:
+		s.memory.Delete(entry.ID)
+	case opAmend:
+		if entry.Record != nil {
+			s.memory.Amend(*entry.Record)
+		}
+	case opErase:
+		if entry.At != nil {
+			s.memory.Erase(entry.ID, entry.Action, *entry.At)
+		}
+	case opRescore:
+		if entry.Record != nil {
+			s.memory.Rescore(entry.Record.ID, entry.Record.Revision, entry.Record.Score)
+		}
+	case opKey:
+		if entry.Key != nil {
+			s.memory.SaveIdempotencyKey(*entry.Key)
+		}
+	}
+	s.seq = entry.Seq
+}
+
+// frame prefixes payload with its length and checksum.
+func frame(payload []byte) []byte {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[frameHeaderSize:], payload)
+	return frame
+}
+
+// append numbers entry, writes it to the log and fsyncs it. If either step
+// fails the partial entry is cut off again, so later appends don't land behind
+// a torn frame that replay would stop at. Callers must hold mutex.
+func (s *FileStore) append(entry *logEntry) error {
+	entry.Seq = s.seq + 1
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode log entry: %w", err)
+	}
+
+	frame := frame(payload)
+	if _, err := s.file.Write(frame); err != nil {
+		s.rollback()
+		return fmt.Errorf("append to receipt log: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		s.rollback()
+		return fmt.Errorf("sync receipt log: %w", err)
+	}
+	s.offset += int64(len(frame))
+	return nil
+}
+
+// rollback discards anything written after the last complete entry.
+func (s *FileStore) rollback() {
+	if err := s.file.Truncate(s.offset); err != nil {
+		log.Printf("Receipt log %s: failed to discard partial entry: %v", s.file.Name(), err)
+	}
+	s.file.Seek(s.offset, io.SeekStart)
+}
+
+func (s *FileStore) Save(record Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := logEntry{Op: opSave, Record: &record}
+	if err := s.append(&entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *FileStore) Get(id string) (Record, error) {
+	return s.memory.Get(id)
+}
+
+func (s *FileStore) List() ([]Record, error) {
+	return s.memory.List()
+}
+
+func (s *FileStore) Query(q Query) (Page, error) {
+	return s.memory.Query(q)
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.memory.Get(id); err != nil {
+		return err
+	}
+	entry := logEntry{Op: opDelete, ID: id}
+	if err := s.append(&entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *FileStore) Erase(id string, action models.AuditAction, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.memory.Get(id); err != nil {
+		return err
+	}
+	if action != models.AuditDelete && action != models.AuditRedact {
+		return fmt.Errorf("unknown audit action %q", action)
+	}
+	entry := logEntry{Op: opErase, ID: id, Action: action, At: &at}
+	if err := s.append(&entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+	// The entries that saved the receipt still hold what was erased, so
+	// snapshot now rather than leave them in the log until the next one.
+	if err := s.snapshot(); err != nil {
+		log.Printf("Receipt log %s: snapshot after erasing %s failed, erased data stays in the log until the next one: %v", s.path, id, err)
+	}
+	return nil
+}
+
+func (s *FileStore) AuditLog(receiptID string) ([]models.AuditEntry, error) {
+	return s.memory.AuditLog(receiptID)
+}
+
+func (s *FileStore) Amend(record Record) (Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, err := s.memory.Get(record.ID)
+	if err != nil {
+		return Record{}, err
+	}
+	// Log the record as it will be stored, so replay doesn't depend on
+	// recomputing the revision.
+	record = amended(current, record)
+	entry := logEntry{Op: opAmend, Record: &record}
+	if err := s.append(&entry); err != nil {
+		return Record{}, err
+	}
+	s.apply(entry)
+	s.maybeSnapshot()
+	return record, nil
+}
+
+func (s *FileStore) Rescore(id string, revision int, score models.Score) (Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, err := s.memory.Get(id)
+	if err != nil {
+		return Record{}, err
+	}
+	record, err := rescored(current, revision, score)
+	if err != nil {
+		return Record{}, err
+	}
+	// Log only what replay needs; the record's receipt is already in the
+	// log, and writing it again would leave another copy to purge.
+	entry := logEntry{Op: opRescore, Record: &Record{ID: id, Revision: current.Revision, Score: score}}
+	if err := s.append(&entry); err != nil {
+		return Record{}, err
+	}
+	s.apply(entry)
+	s.maybeSnapshot()
+	return record, nil
+}
+
+func (s *FileStore) History(id string) ([]Record, error) {
+	return s.memory.History(id)
+}
+
+func (s *FileStore) FindByFingerprint(fingerprint string) (Record, error) {
+	return s.memory.FindByFingerprint(fingerprint)
+}
+
+func (s *FileStore) SaveIdempotencyKey(key IdempotencyKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := logEntry{Op: opKey, Key: &key}
+	if err := s.append(&entry); err != nil {
+		return err
+	}
+	s.apply(entry)
+	s.maybeSnapshot()
+	return nil
+}
+
+func (s *FileStore) GetIdempotencyKey(key string, now time.Time) (IdempotencyKey, error) {
+	return s.memory.GetIdempotencyKey(key, now)
+}
+
+// Close closes the log file.
+func (s *FileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
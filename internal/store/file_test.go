@@ -0,0 +1,208 @@
+package store_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"receipt-processor/internal/store/storetest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openFileStore(t *testing.T, path string) *store.FileStore {
+	t.Helper()
+	s, err := store.OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return openFileStore(t, filepath.Join(t.TempDir(), "receipts.log"))
+	})
+}
+
+func TestFileStoreDurability(t *testing.T) {
+	t.Run("Replays Log On Reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openFileStore(t, path)
+		s.Save(storetest.Record("a"))
+		s.Save(storetest.Record("b"))
+		replaced := storetest.Record("a")
+		replaced.Score.Points = 7
+		s.Save(replaced)
+		s.Delete("b")
+		s.Close()
+
+		reopened := openFileStore(t, path)
+		records, err := reopened.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(records) != 1 || !reflect.DeepEqual(records[0], replaced) {
+			t.Errorf("List() after reopen = %+v, want only %+v", records, replaced)
+		}
+	})
+
+	t.Run("Replays Rescore", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openFileStore(t, path)
+		s.Save(storetest.Record("a"))
+		score := storetest.Record("a").Score
+		score.Points = 7
+		rescored, err := s.Rescore("a", 1, score)
+		if err != nil {
+			t.Fatalf("Rescore() error = %v", err)
+		}
+		s.Close()
+
+		if got, err := openFileStore(t, path).Get("a"); err != nil || !reflect.DeepEqual(got, rescored) {
+			t.Errorf("Get() after reopen = %+v, %v; want %+v", got, err, rescored)
+		}
+	})
+
+	t.Run("Recovers From Torn Last Record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openFileStore(t, path)
+		s.Save(storetest.Record("a"))
+		info, _ := os.Stat(path)
+		intact := info.Size()
+		s.Save(storetest.Record("b"))
+		s.Close()
+
+		// Simulate a crash part way through writing the second record.
+		info, _ = os.Stat(path)
+		if err := os.Truncate(path, intact+(info.Size()-intact)/2); err != nil {
+			t.Fatalf("Truncate() error = %v", err)
+		}
+
+		reopened := openFileStore(t, path)
+		if _, err := reopened.Get("a"); err != nil {
+			t.Errorf("Get(a) after recovery error = %v", err)
+		}
+		if _, err := reopened.Get("b"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get(b) after recovery error = %v, want ErrNotFound", err)
+		}
+		if info, _ := os.Stat(path); info.Size() != intact {
+			t.Errorf("log size after recovery = %d, want %d", info.Size(), intact)
+		}
+
+		// New writes must land after the last intact record and survive
+		// another restart.
+		if err := reopened.Save(storetest.Record("c")); err != nil {
+			t.Fatalf("Save() after recovery error = %v", err)
+		}
+		reopened.Close()
+		again := openFileStore(t, path)
+		records, _ := again.List()
+		if len(records) != 2 || records[0].ID != "a" || records[1].ID != "c" {
+			t.Errorf("List() after second reopen = %+v, want a and c", records)
+		}
+	})
+
+	t.Run("Discards Corrupt Last Record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openFileStore(t, path)
+		s.Save(storetest.Record("a"))
+		s.Save(storetest.Record("b"))
+		s.Close()
+
+		// Flip a byte in the final payload so its checksum no longer matches.
+		data, _ := os.ReadFile(path)
+		data[len(data)-2] ^= 0xff
+		os.WriteFile(path, data, 0o644)
+
+		reopened := openFileStore(t, path)
+		records, _ := reopened.List()
+		if len(records) != 1 || records[0].ID != "a" {
+			t.Errorf("List() after recovery = %+v, want only a", records)
+		}
+	})
+
+	t.Run("Open Fails For Corrupt Middle Record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openFileStore(t, path)
+		s.Save(storetest.Record("a"))
+		info, _ := os.Stat(path)
+		first := info.Size()
+		s.Save(storetest.Record("b"))
+		s.Save(storetest.Record("c"))
+		s.Close()
+
+		// Flip a byte inside the first payload; the two entries after it
+		// are intact, so this can't be a crash mid-append.
+		data, _ := os.ReadFile(path)
+		data[first/2] ^= 0xff
+		os.WriteFile(path, data, 0o644)
+
+		if reopened, err := store.OpenFileStore(path); err == nil {
+			reopened.Close()
+			t.Fatal("OpenFileStore() of a log corrupt in the middle succeeded")
+		}
+		if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+			t.Errorf("log size after failed open = %d, want it left at %d", info.Size(), len(data))
+		}
+	})
+
+	t.Run("Open Fails For Bad Path", func(t *testing.T) {
+		if _, err := store.OpenFileStore(filepath.Join(t.TempDir(), "missing", "receipts.log")); err == nil {
+			t.Error("expected error opening a log in a missing directory")
+		}
+	})
+}
+
+func TestEraseLeavesNoTrace(t *testing.T) {
+	// A retailer that appears nowhere else in the files.
+	const retailer = "Erased Corner Market"
+	erase := func(t *testing.T, s store.Store, action models.AuditAction) {
+		t.Helper()
+		record := storetest.Record("a")
+		record.Receipt.Retailer = retailer
+		if err := s.Save(record); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if err := s.Erase("a", action, time.Now().UTC()); err != nil {
+			t.Fatalf("Erase() error = %v", err)
+		}
+	}
+	// assertGone fails if any file in dir still contains the retailer.
+	assertGone := func(t *testing.T, dir string) {
+		t.Helper()
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if bytes.Contains(data, []byte(retailer)) {
+				t.Errorf("%s still contains the erased retailer", filepath.Base(file))
+			}
+		}
+	}
+
+	for _, action := range []models.AuditAction{models.AuditDelete, models.AuditRedact} {
+		t.Run("File "+string(action), func(t *testing.T) {
+			dir := t.TempDir()
+			erase(t, openFileStore(t, filepath.Join(dir, "receipts.log")), action)
+			assertGone(t, dir)
+		})
+
+		t.Run("SQLite "+string(action), func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := store.OpenSQLiteStore(filepath.Join(dir, "receipts.db"))
+			if err != nil {
+				t.Fatalf("OpenSQLiteStore() error = %v", err)
+			}
+			defer s.Close()
+			erase(t, s, action)
+			assertGone(t, dir)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long an idempotency key is kept unless the
+// server is configured otherwise.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// IdempotencyKey is a response saved under a client's Idempotency-Key, so a
+// retried request can be answered with it instead of being applied again.
+type IdempotencyKey struct {
+	Key string `json:"key"`
+	// RequestHash identifies the request body the key was first used with.
+	RequestHash string          `json:"requestHash"`
+	Status      int             `json:"status"`
+	Response    json.RawMessage `json:"response"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	// ExpiresAt is when the key is forgotten and may be used again.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// expired reports whether the key has expired at now.
+func (k IdempotencyKey) expired(now time.Time) bool {
+	return !now.Before(k.ExpiresAt)
+}
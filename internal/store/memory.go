@@ -1,33 +1,259 @@
 package store
 
 import (
+	"fmt"
 	"receipt-processor/internal/models"
+	"sort"
 	"sync"
+	"time"
 )
 
+// ReceiptStore is a Store that keeps records in memory. Everything is lost
+// when the process exits.
 type ReceiptStore struct {
-	receipts map[string]models.Receipt
-	points   map[string]int64
+	records map[string]Record
+	// sorted holds every record's position in each query order, so a query
+	// can seek to its cursor or range instead of sorting all records.
+	sorted map[SortField]*skipList
+	// byRetailer holds the IDs of each retailer's records.
+	byRetailer map[string]map[string]struct{}
+	// byFingerprint holds the IDs of the records with each fingerprint.
+	byFingerprint map[string]map[string]struct{}
+	audit         []models.AuditEntry
+	// history holds the replaced versions of amended records, oldest first.
+	history map[string][]Record
+	keys    map[string]IdempotencyKey
+	// keyQueue holds the saved keys in the order they were saved, which is
+	// the order they expire in while the TTL stays the same, so expired keys
+	// can be purged from the front.
+	keyQueue []IdempotencyKey
 	mutex    sync.RWMutex
 }
 
+var _ Store = (*ReceiptStore)(nil)
+
 func NewStore() *ReceiptStore {
-	return &ReceiptStore{
-		receipts: make(map[string]models.Receipt),
-		points:   make(map[string]int64),
+	s := &ReceiptStore{
+		records:       make(map[string]Record),
+		sorted:        make(map[SortField]*skipList),
+		byRetailer:    make(map[string]map[string]struct{}),
+		byFingerprint: make(map[string]map[string]struct{}),
+		history:       make(map[string][]Record),
+		keys:          make(map[string]IdempotencyKey),
+	}
+	for _, field := range SortFields {
+		s.sorted[field] = newSkipList()
 	}
+	return s
 }
 
-func (s *ReceiptStore) SaveReceipt(id string, receipt models.Receipt, points int64) {
+func (s *ReceiptStore) Save(record Record) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.receipts[id] = receipt
-	s.points[id] = points
+	if old, exists := s.records[record.ID]; exists {
+		s.unindex(old)
+	}
+	s.records[record.ID] = record
+	s.index(record)
+	return nil
 }
 
-func (s *ReceiptStore) GetPoints(id string) (int64, bool) {
+func (s *ReceiptStore) Get(id string) (Record, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	record, exists := s.records[id]
+	if !exists {
+		return Record{}, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *ReceiptStore) List() ([]Record, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	points, exists := s.points[id]
-	return points, exists
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+func (s *ReceiptStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.records[id]; !exists {
+		return ErrNotFound
+	}
+	s.unindex(s.records[id])
+	delete(s.records, id)
+	delete(s.history, id)
+	return nil
+}
+
+func (s *ReceiptStore) Erase(id string, action models.AuditAction, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record, exists := s.records[id]
+	if !exists {
+		return ErrNotFound
+	}
+	s.unindex(record)
+	switch action {
+	case models.AuditDelete:
+		delete(s.records, id)
+		delete(s.history, id)
+	case models.AuditRedact:
+		record = record.Redacted(at)
+		s.records[id] = record
+		s.index(record)
+		history := make([]Record, len(s.history[id]))
+		for i, revision := range s.history[id] {
+			history[i] = revision.Redacted(at)
+		}
+		if len(history) > 0 {
+			s.history[id] = history
+		}
+	default:
+		s.index(record)
+		return fmt.Errorf("unknown audit action %q", action)
+	}
+	s.audit = append(s.audit, models.AuditEntry{ReceiptID: id, Action: action, At: at})
+	return nil
+}
+
+func (s *ReceiptStore) AuditLog(receiptID string) ([]models.AuditEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries := []models.AuditEntry{}
+	for _, entry := range s.audit {
+		if receiptID == "" || entry.ReceiptID == receiptID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *ReceiptStore) Amend(record Record) (Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, exists := s.records[record.ID]
+	if !exists {
+		return Record{}, ErrNotFound
+	}
+	record = amended(current, record)
+	s.history[record.ID] = append(s.history[record.ID], current)
+	s.unindex(current)
+	s.records[record.ID] = record
+	s.index(record)
+	return record, nil
+}
+
+func (s *ReceiptStore) Rescore(id string, revision int, score models.Score) (Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, exists := s.records[id]
+	if !exists {
+		return Record{}, ErrNotFound
+	}
+	record, err := rescored(current, revision, score)
+	if err != nil {
+		return Record{}, err
+	}
+	s.unindex(current)
+	s.records[id] = record
+	s.index(record)
+	return record, nil
+}
+
+func (s *ReceiptStore) History(id string) ([]Record, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	current, exists := s.records[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	history := append([]Record{}, s.history[id]...)
+	return append(history, current), nil
+}
+
+func (s *ReceiptStore) FindByFingerprint(fingerprint string) (Record, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var (
+		earliest Record
+		found    bool
+	)
+	for id := range s.byFingerprint[fingerprint] {
+		record := s.records[id]
+		if !found || before(timeKey(record.SubmittedAt), record.ID, Cursor{Key: timeKey(earliest.SubmittedAt), ID: earliest.ID}) {
+			earliest, found = record, true
+		}
+	}
+	if !found {
+		return Record{}, ErrNotFound
+	}
+	return earliest, nil
+}
+
+func (s *ReceiptStore) SaveIdempotencyKey(key IdempotencyKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.keyQueue) > 0 && s.keyQueue[0].expired(key.CreatedAt) {
+		oldest := s.keyQueue[0]
+		// Only purge the key if it wasn't saved again since.
+		if current, ok := s.keys[oldest.Key]; ok && current.ExpiresAt.Equal(oldest.ExpiresAt) {
+			delete(s.keys, oldest.Key)
+		}
+		s.keyQueue = s.keyQueue[1:]
+	}
+	s.keys[key.Key] = key
+	s.keyQueue = append(s.keyQueue, key)
+	return nil
+}
+
+func (s *ReceiptStore) GetIdempotencyKey(key string, now time.Time) (IdempotencyKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	saved, exists := s.keys[key]
+	if !exists || saved.expired(now) {
+		return IdempotencyKey{}, ErrNotFound
+	}
+	return saved, nil
+}
+
+// idempotencyKeys returns every key that hasn't expired at now, in the order
+// they were saved.
+func (s *ReceiptStore) idempotencyKeys(now time.Time) []IdempotencyKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var keys []IdempotencyKey
+	for _, key := range s.keyQueue {
+		if current := s.keys[key.Key]; current.ExpiresAt.Equal(key.ExpiresAt) && !key.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// pastRevisions returns a copy of every record's replaced versions.
+func (s *ReceiptStore) pastRevisions() map[string][]Record {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	history := make(map[string][]Record, len(s.history))
+	for id, revisions := range s.history {
+		history[id] = append([]Record{}, revisions...)
+	}
+	return history
+}
+
+// SaveReceipt stores a receipt and its score under id.
+func (s *ReceiptStore) SaveReceipt(id string, receipt models.Receipt, score models.Score) {
+	s.Save(Record{ID: id, Receipt: receipt, Score: score})
+}
+
+// GetPoints returns the points saved for a receipt.
+func (s *ReceiptStore) GetPoints(id string) (int64, bool) {
+	record, err := s.Get(id)
+	return record.Score.Points, err == nil
 }
@@ -0,0 +1,13 @@
+package store_test
+
+import (
+	"receipt-processor/internal/store"
+	"receipt-processor/internal/store/storetest"
+	"testing"
+)
+
+func TestReceiptStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewStore()
+	})
+}
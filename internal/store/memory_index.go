@@ -0,0 +1,188 @@
+package store
+
+import (
+	"sort"
+)
+
+// indexEntry is one record's position in a sorted index.
+type indexEntry struct {
+	key, id string
+}
+
+// search returns the index of the first entry at or after (key, id).
+func search(entries []indexEntry, key, id string) int {
+	return sort.Search(len(entries), func(i int) bool {
+		return !before(entries[i].key, entries[i].id, Cursor{Key: key, ID: id})
+	})
+}
+
+// index adds record to the secondary indexes. Callers must hold the write
+// lock.
+func (s *ReceiptStore) index(record Record) {
+	for _, field := range SortFields {
+		s.sorted[field].insert(indexEntry{key: sortKey(record, field), id: record.ID})
+	}
+
+	ids := s.byRetailer[record.Receipt.Retailer]
+	if ids == nil {
+		ids = make(map[string]struct{})
+		s.byRetailer[record.Receipt.Retailer] = ids
+	}
+	ids[record.ID] = struct{}{}
+
+	if fingerprint := record.fingerprint(); fingerprint != "" {
+		ids := s.byFingerprint[fingerprint]
+		if ids == nil {
+			ids = make(map[string]struct{})
+			s.byFingerprint[fingerprint] = ids
+		}
+		ids[record.ID] = struct{}{}
+	}
+}
+
+// unindex removes record from the secondary indexes. Callers must hold the
+// write lock.
+func (s *ReceiptStore) unindex(record Record) {
+	for _, field := range SortFields {
+		s.sorted[field].remove(indexEntry{key: sortKey(record, field), id: record.ID})
+	}
+
+	ids := s.byRetailer[record.Receipt.Retailer]
+	delete(ids, record.ID)
+	if len(ids) == 0 {
+		delete(s.byRetailer, record.Receipt.Retailer)
+	}
+
+	if fingerprint := record.fingerprint(); fingerprint != "" {
+		ids := s.byFingerprint[fingerprint]
+		delete(ids, record.ID)
+		if len(ids) == 0 {
+			delete(s.byFingerprint, fingerprint)
+		}
+	}
+}
+
+// Query returns a page of records matching q. The records are read from
+// whichever index holds the fewest candidates: the sorted index for q's
+// order, walked from the cursor between any bounds on the sort field, or the
+// retailer index or the range of another field's sorted index, whose records
+// are sorted into q's order first. So a selective filter stays cheap in any
+// order, at the cost of sorting its matches for every page.
+func (s *ReceiptStore) Query(q Query) (Page, error) {
+	if err := q.check(); err != nil {
+		return Page{}, err
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entries := s.candidates(q)
+
+	// Narrow entries to the range on the sort field and the cursor.
+	lower, upper := fieldRange(q, q.Sort)
+	start, end := bounds(entries, lower, upper)
+	if q.After != nil {
+		if q.Descending {
+			end = min(end, entries.search(q.After.Key, q.After.ID))
+		} else {
+			start = max(start, entries.search(q.After.Key, q.After.ID+"\x00"))
+		}
+	}
+
+	page := Page{Records: []Record{}}
+	entries.walk(start, end, q.Descending, func(entry indexEntry) bool {
+		record := s.records[entry.id]
+		if !q.matches(record) {
+			return true
+		}
+		if len(page.Records) == q.Limit {
+			page.Next = q.cursorFor(page.Records[len(page.Records)-1])
+			return false
+		}
+		page.Records = append(page.Records, record)
+		return true
+	})
+	return page, nil
+}
+
+// candidates returns the records Query walks for q as a sorted index for q's
+// order: the whole sorted index, unless the retailer or a range on another
+// field selects fewer records than the range on the sort field does.
+// Callers must hold the read lock.
+func (s *ReceiptStore) candidates(q Query) entryList {
+	lower, upper := fieldRange(q, q.Sort)
+	start, end := bounds(s.sorted[q.Sort], lower, upper)
+	fewest := end - start
+	narrowed := false
+	var ids []string
+	if q.Retailer != "" && len(s.byRetailer[q.Retailer]) < fewest {
+		fewest, narrowed = len(s.byRetailer[q.Retailer]), true
+		ids = make([]string, 0, fewest)
+		for id := range s.byRetailer[q.Retailer] {
+			ids = append(ids, id)
+		}
+	}
+	for _, field := range SortFields {
+		lower, upper := fieldRange(q, field)
+		if field == q.Sort || (lower == "" && upper == "") {
+			continue
+		}
+		start, end := bounds(s.sorted[field], lower, upper)
+		if end-start >= fewest {
+			continue
+		}
+		fewest, narrowed = end-start, true
+		ids = ids[:0]
+		s.sorted[field].walk(start, end, false, func(entry indexEntry) bool {
+			ids = append(ids, entry.id)
+			return true
+		})
+	}
+	if !narrowed {
+		return s.sorted[q.Sort]
+	}
+
+	entries := make(sortedEntries, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, indexEntry{key: sortKey(s.records[id], q.Sort), id: id})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return before(entries[i].key, entries[i].id, Cursor{Key: entries[j].key, ID: entries[j].id})
+	})
+	return entries
+}
+
+// bounds returns the part of entries whose keys lie between lower and upper,
+// inclusive, as a half-open range of positions. An empty upper bound means
+// none.
+func bounds(entries entryList, lower, upper string) (start, end int) {
+	start, end = entries.search(lower, ""), entries.len()
+	if upper != "" {
+		end = entries.search(upper+"\x00", "")
+	}
+	return start, end
+}
+
+// fieldRange returns the inclusive bounds q puts on field as sort keys. An
+// empty upper bound means none.
+func fieldRange(q Query, field SortField) (lower, upper string) {
+	switch field {
+	case SortByPurchaseDate:
+		lower = q.PurchasedFrom
+		if q.PurchasedTo != "" {
+			// Keys are "date time", so every time on the last day sorts
+			// before the date followed by a higher byte.
+			upper = q.PurchasedTo + "~"
+		}
+	case SortByPoints:
+		if q.MinPoints != nil {
+			lower = pointsKey(*q.MinPoints)
+		}
+		if q.MaxPoints != nil {
+			upper = pointsKey(*q.MaxPoints)
+		}
+	case SortBySubmittedAt:
+		lower = timeKey(q.SubmittedFrom)
+		upper = timeKey(q.SubmittedTo)
+	}
+	return lower, upper
+}
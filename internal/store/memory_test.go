@@ -1,6 +1,8 @@
 package store
 
 import (
+	"fmt"
+	"math/rand"
 	"receipt-processor/internal/models"
 	"testing"
 )
@@ -23,7 +25,7 @@ func TestReceiptStore(t *testing.T) {
 		testID := "test-id-1"
 		testPoints := int64(50)
 
-		store.SaveReceipt(testID, testReceipt, testPoints)
+		store.SaveReceipt(testID, testReceipt, models.Score{Points: testPoints})
 
 		points, exists := store.GetPoints(testID)
 		if !exists {
@@ -35,6 +37,32 @@ func TestReceiptStore(t *testing.T) {
 		}
 	})
 
+	// Test storing the per-rule breakdown alongside the total
+	t.Run("Save and Retrieve Score", func(t *testing.T) {
+		testID := "test-id-2"
+		score := models.Score{
+			Points: 56,
+			Breakdown: []models.RuleBreakdown{
+				{Rule: "retailer-name", Points: 6},
+				{Rule: "round-total", Points: 50},
+			},
+		}
+
+		store.SaveReceipt(testID, testReceipt, score)
+
+		record, err := store.Get(testID)
+		if err != nil {
+			t.Fatalf("Receipt not found in store: %v", err)
+		}
+		got := record.Score
+		if got.Points != score.Points || len(got.Breakdown) != 2 || got.Breakdown[1].Rule != "round-total" {
+			t.Errorf("Got score %+v, want %+v", got, score)
+		}
+		if record.Receipt.Retailer != testReceipt.Retailer {
+			t.Errorf("Got receipt %+v, want %+v", record.Receipt, testReceipt)
+		}
+	})
+
 	// Test retrieving non-existent receipt
 	t.Run("Get Non-existent Receipt", func(t *testing.T) {
 		_, exists := store.GetPoints("non-existent-id")
@@ -51,7 +79,7 @@ func TestReceiptStore(t *testing.T) {
 		for i := 0; i < 10; i++ {
 			go func(index int) {
 				id := string(rune('A' + index))
-				store.SaveReceipt(id, testReceipt, int64(index))
+				store.SaveReceipt(id, testReceipt, models.Score{Points: int64(index)})
 				points, _ := store.GetPoints(id)
 				if points != int64(index) {
 					t.Errorf("Got points %d, want %d", points, index)
@@ -66,3 +94,50 @@ func TestReceiptStore(t *testing.T) {
 		}
 	})
 }
+
+func TestQueryCandidates(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 100; i++ {
+		retailer := "Target"
+		if i%10 == 0 {
+			retailer = "Walgreens"
+		}
+		s.Save(Record{
+			ID:      fmt.Sprintf("r%03d", i),
+			Receipt: models.Receipt{Retailer: retailer, PurchaseDate: fmt.Sprintf("2024-04-%02d", 1+i%28), PurchaseTime: "12:00"},
+			Score:   models.Score{Points: int64(i)},
+		})
+	}
+	points := func(n int64) *int64 { return &n }
+
+	for _, tt := range []struct {
+		name  string
+		query Query
+		want  int
+	}{
+		{"No Filters", Query{Sort: SortBySubmittedAt}, 100},
+		{"Points Range", Query{MinPoints: points(90), Sort: SortBySubmittedAt}, 10},
+		{"Retailer", Query{Retailer: "Walgreens", Sort: SortByPoints}, 10},
+		{"Narrowest Filter Wins", Query{Retailer: "Target", MinPoints: points(95), Sort: SortByPurchaseDate}, 5},
+		{"Sort Field Range Is Walked", Query{MinPoints: points(50), PurchasedFrom: "2024-04-01", Sort: SortByPoints}, 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.candidates(tt.query).len(); got != tt.want {
+				t.Errorf("candidates() = %d records, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkSave saves b.N new receipts, as a bulk import does, so the cost of
+// each save must not grow with the number already stored.
+func BenchmarkSave(b *testing.B) {
+	s := NewStore()
+	for i := 0; i < b.N; i++ {
+		s.Save(Record{
+			ID:      fmt.Sprintf("r%d", i),
+			Receipt: models.Receipt{Retailer: "Target", PurchaseDate: fmt.Sprintf("2024-%02d-%02d", 1+i%12, 1+i%28), PurchaseTime: "12:00"},
+			Score:   models.Score{Points: int64(rand.Intn(1000))},
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidCursor is returned by Query for a cursor that wasn't produced by
+// an earlier page of the same query order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortField is an order Query can return records in. Records with the same
+// value are ordered by ID, so every order is total and stable across pages.
+type SortField string
+
+const (
+	SortBySubmittedAt  SortField = "submittedAt"
+	SortByPurchaseDate SortField = "purchaseDate"
+	SortByPoints       SortField = "points"
+)
+
+// SortFields lists every supported order.
+var SortFields = []SortField{SortBySubmittedAt, SortByPurchaseDate, SortByPoints}
+
+// Query selects a page of records. Zero-valued filters match everything, and
+// all ranges are inclusive.
+type Query struct {
+	// Retailer matches the retailer name exactly.
+	Retailer string
+	// PurchasedFrom and PurchasedTo bound the purchase date, as YYYY-MM-DD.
+	PurchasedFrom, PurchasedTo string
+	MinPoints, MaxPoints       *int64
+	SubmittedFrom, SubmittedTo time.Time
+
+	Sort       SortField
+	Descending bool
+	// After continues from the last record of a previous page.
+	After *Cursor
+	// Limit is the maximum number of records returned. It must be positive.
+	Limit int
+}
+
+// Page is one page of Query results. Next is set when more records follow.
+type Page struct {
+	Records []Record
+	Next    *Cursor
+}
+
+// Cursor marks a position in a query order: just after the record with the
+// given sort key and ID. Because it holds values rather than an offset, a
+// page boundary stays put when records are added or removed before it.
+type Cursor struct {
+	Sort       SortField `json:"sort"`
+	Descending bool      `json:"desc,omitempty"`
+	Key        string    `json:"key"`
+	ID         string    `json:"id"`
+}
+
+// String encodes the cursor as an opaque, URL-safe token.
+func (c Cursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a token returned by Cursor.String.
+func ParseCursor(token string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// check reports whether q can be run, filling in the default order.
+func (q *Query) check() error {
+	if q.Sort == "" {
+		q.Sort = SortBySubmittedAt
+	}
+	if !validSort(q.Sort) {
+		return fmt.Errorf("unknown sort field %q", q.Sort)
+	}
+	if q.Limit <= 0 {
+		return fmt.Errorf("query limit must be positive, got %d", q.Limit)
+	}
+	if q.After != nil && (q.After.Sort != q.Sort || q.After.Descending != q.Descending) {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+func validSort(field SortField) bool {
+	for _, known := range SortFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether record passes every filter in q.
+func (q Query) matches(record Record) bool {
+	receipt := record.Receipt
+	switch {
+	case q.Retailer != "" && receipt.Retailer != q.Retailer,
+		q.PurchasedFrom != "" && receipt.PurchaseDate < q.PurchasedFrom,
+		q.PurchasedTo != "" && receipt.PurchaseDate > q.PurchasedTo,
+		q.MinPoints != nil && record.Score.Points < *q.MinPoints,
+		q.MaxPoints != nil && record.Score.Points > *q.MaxPoints,
+		!q.SubmittedFrom.IsZero() && record.SubmittedAt.Before(q.SubmittedFrom),
+		!q.SubmittedTo.IsZero() && record.SubmittedAt.After(q.SubmittedTo):
+		return false
+	}
+	return true
+}
+
+// cursorFor returns the cursor just after record in q's order.
+func (q Query) cursorFor(record Record) *Cursor {
+	return &Cursor{Sort: q.Sort, Descending: q.Descending, Key: sortKey(record, q.Sort), ID: record.ID}
+}
+
+// sortKey returns a string that sorts the same way as record's value of
+// field, so every order can share one comparison.
+func sortKey(record Record, field SortField) string {
+	switch field {
+	case SortByPurchaseDate:
+		return record.Receipt.PurchaseDate + " " + record.Receipt.PurchaseTime
+	case SortByPoints:
+		return pointsKey(record.Score.Points)
+	default:
+		return timeKey(record.SubmittedAt)
+	}
+}
+
+// pointsKey writes points in offset binary, zero padded, so negative values
+// sort before positive ones as text.
+func pointsKey(points int64) string {
+	return fmt.Sprintf("%020d", uint64(points)^(1<<63))
+}
+
+// parsePointsKey reverses pointsKey.
+func parsePointsKey(key string) (int64, error) {
+	n, err := strconv.ParseUint(key, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return int64(n ^ (1 << 63)), nil
+}
+
+// timeLayout is RFC 3339 with a fixed number of fractional digits, so times
+// in UTC sort correctly as text.
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// timeKey formats t with timeLayout in UTC, or returns "" for the zero time
+// so receipts without a submission time sort first.
+func timeKey(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timeLayout)
+}
+
+// before reports whether the position (key, id) comes before cursor in
+// ascending order.
+func before(key, id string, cursor Cursor) bool {
+	if key != cursor.Key {
+		return key < cursor.Key
+	}
+	return id < cursor.ID
+}
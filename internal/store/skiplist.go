@@ -0,0 +1,174 @@
+package store
+
+import "math/rand"
+
+// maxLevel bounds the height of a skipList's towers. With one node in four
+// promoted to each next level, 16 levels keep searches logarithmic well past
+// a billion entries.
+const maxLevel = 16
+
+// entryList is a sorted run of index entries that a query can seek in and
+// walk.
+type entryList interface {
+	len() int
+	// search returns the position of the first entry at or after (key, id).
+	search(key, id string) int
+	// walk calls fn with the entries at positions start to end-1, or end-1
+	// down to start if descending, until fn returns false.
+	walk(start, end int, descending bool, fn func(indexEntry) bool)
+}
+
+// sortedEntries is an entryList held in a slice, for the candidates of one
+// query.
+type sortedEntries []indexEntry
+
+func (entries sortedEntries) len() int {
+	return len(entries)
+}
+
+func (entries sortedEntries) search(key, id string) int {
+	return search(entries, key, id)
+}
+
+func (entries sortedEntries) walk(start, end int, descending bool, fn func(indexEntry) bool) {
+	for n := 0; n < end-start; n++ {
+		i := start + n
+		if descending {
+			i = end - 1 - n
+		}
+		if !fn(entries[i]) {
+			return
+		}
+	}
+}
+
+// skipList is an entryList that stays sorted as entries are added and
+// removed, each in logarithmic time, so the store's indexes don't copy every
+// entry on each write. Each link records how many entries it skips, so an
+// entry's position can be found on the way down as well.
+type skipList struct {
+	head   skipNode
+	level  int
+	length int
+}
+
+func newSkipList() *skipList {
+	return &skipList{head: skipNode{next: make([]skipLink, maxLevel)}}
+}
+
+type skipNode struct {
+	entry indexEntry
+	next  []skipLink
+	// prev is the node before this one, or nil for the first.
+	prev *skipNode
+}
+
+// skipLink points at the next node on one level. width is the number of
+// positions it moves forward, and is meaningless when node is nil.
+type skipLink struct {
+	node  *skipNode
+	width int
+}
+
+func (l *skipList) len() int {
+	return l.length
+}
+
+// seek returns, for each level, the last node before (key, id) and its
+// position, counting the head as 0 and the first entry as 1.
+func (l *skipList) seek(key, id string) (last [maxLevel]*skipNode, positions [maxLevel]int) {
+	node, position := &l.head, 0
+	for level := maxLevel - 1; level >= 0; level-- {
+		for next := node.next[level]; next.node != nil && before(next.node.entry.key, next.node.entry.id, Cursor{Key: key, ID: id}); next = node.next[level] {
+			node, position = next.node, position+next.width
+		}
+		last[level], positions[level] = node, position
+	}
+	return last, positions
+}
+
+func (l *skipList) search(key, id string) int {
+	_, positions := l.seek(key, id)
+	return positions[0]
+}
+
+// insert adds entry, which must not already be in l.
+func (l *skipList) insert(entry indexEntry) {
+	last, positions := l.seek(entry.key, entry.id)
+	height := 1
+	for height < maxLevel && rand.Intn(4) == 0 {
+		height++
+	}
+	l.level = max(l.level, height)
+
+	node := &skipNode{entry: entry, next: make([]skipLink, height)}
+	position := positions[0] + 1
+	for level := 0; level < l.level; level++ {
+		link := &last[level].next[level]
+		if level >= height {
+			link.width++
+			continue
+		}
+		skipped := position - positions[level]
+		node.next[level] = skipLink{node: link.node, width: link.width - skipped + 1}
+		*link = skipLink{node: node, width: skipped}
+	}
+	if last[0] != &l.head {
+		node.prev = last[0]
+	}
+	if next := node.next[0].node; next != nil {
+		next.prev = node
+	}
+	l.length++
+}
+
+// remove removes entry from l, if it is there.
+func (l *skipList) remove(entry indexEntry) {
+	last, _ := l.seek(entry.key, entry.id)
+	node := last[0].next[0].node
+	if node == nil || node.entry != entry {
+		return
+	}
+	for level := 0; level < l.level; level++ {
+		link := &last[level].next[level]
+		if link.node != node {
+			link.width--
+			continue
+		}
+		*link = skipLink{node: node.next[level].node, width: link.width + node.next[level].width - 1}
+	}
+	if next := node.next[0].node; next != nil {
+		next.prev = node.prev
+	}
+	l.length--
+}
+
+// at returns the node at position i, counting the first entry as 0.
+func (l *skipList) at(i int) *skipNode {
+	node, position := &l.head, 0
+	for level := l.level - 1; level >= 0; level-- {
+		for next := node.next[level]; next.node != nil && position+next.width <= i+1; next = node.next[level] {
+			node, position = next.node, position+next.width
+		}
+	}
+	return node
+}
+
+func (l *skipList) walk(start, end int, descending bool, fn func(indexEntry) bool) {
+	if start >= end {
+		return
+	}
+	if descending {
+		for node, n := l.at(end-1), end-start; n > 0; node, n = node.prev, n-1 {
+			if !fn(node.entry) {
+				return
+			}
+		}
+		return
+	}
+	for node, n := l.at(start), end-start; n > 0; node, n = node.next[0].node, n-1 {
+		if !fn(node.entry) {
+			return
+		}
+	}
+}
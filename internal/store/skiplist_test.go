@@ -0,0 +1,73 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSkipList(t *testing.T) {
+	l := newSkipList()
+	var want sortedEntries
+	for i := 0; i < 2000; i++ {
+		// Mostly inserts, with removals of present and absent entries, so the
+		// list grows while links are rewired in both directions.
+		entry := indexEntry{key: fmt.Sprintf("%03d", rand.Intn(200)), id: fmt.Sprintf("r%d", rand.Intn(500))}
+		i := want.search(entry.key, entry.id)
+		present := i < len(want) && want[i] == entry
+		switch {
+		case rand.Intn(3) == 0:
+			l.remove(entry)
+			if present {
+				want = append(want[:i], want[i+1:]...)
+			}
+		case !present:
+			l.insert(entry)
+			want = append(want[:i], append(sortedEntries{entry}, want[i:]...)...)
+		}
+	}
+	if !sort.SliceIsSorted(want, func(i, j int) bool {
+		return before(want[i].key, want[i].id, Cursor{Key: want[j].key, ID: want[j].id})
+	}) {
+		t.Fatal("reference entries are out of order")
+	}
+
+	if l.len() != len(want) {
+		t.Fatalf("len() = %d, want %d", l.len(), len(want))
+	}
+	for _, descending := range []bool{false, true} {
+		var got sortedEntries
+		l.walk(0, l.len(), descending, func(entry indexEntry) bool {
+			got = append(got, entry)
+			return true
+		})
+		for i := range want {
+			j := i
+			if descending {
+				j = len(want) - 1 - i
+			}
+			if got[j] != want[i] {
+				t.Fatalf("walk(descending=%v) entry %d = %v, want %v", descending, j, got[j], want[i])
+			}
+		}
+	}
+	for i, entry := range want {
+		if got := l.search(entry.key, entry.id); got != i {
+			t.Fatalf("search(%v) = %d, want %d", entry, got, i)
+		}
+		if got := l.search(entry.key, entry.id+"\x00"); got != i+1 {
+			t.Fatalf("search(%v after) = %d, want %d", entry, got, i+1)
+		}
+	}
+
+	start, end := len(want)/3, 2*len(want)/3
+	var got sortedEntries
+	l.walk(start, end, false, func(entry indexEntry) bool {
+		got = append(got, entry)
+		return len(got) < 5
+	})
+	if len(got) != 5 || got[0] != want[start] || got[4] != want[start+4] {
+		t.Errorf("walk(%d, %d) stopped early = %v, want %v", start, end, got, want[start:start+5])
+	}
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"receipt-processor/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A snapshot holds every record as of one log sequence number. It is stored
+// next to the log as <log>.snapshot.<seq>, framed like a log entry so a
+// damaged file is detected rather than half loaded.
+type snapshot struct {
+	Seq     uint64              `json:"seq"`
+	Records []Record            `json:"records"`
+	Audit   []models.AuditEntry `json:"audit,omitempty"`
+	// History holds the replaced versions of amended records.
+	History map[string][]Record `json:"history,omitempty"`
+	// Keys holds the idempotency keys that hadn't expired yet.
+	Keys []IdempotencyKey `json:"keys,omitempty"`
+}
+
+const snapshotInfix = ".snapshot."
+
+// snapshotPath returns the file name of the snapshot taken at seq. The
+// sequence number is zero padded so names sort in sequence order.
+func (s *FileStore) snapshotPath(seq uint64) string {
+	return fmt.Sprintf("%s%s%020d", s.path, snapshotInfix, seq)
+}
+
+// snapshotFiles returns the snapshots next to the log, newest first, and
+// removes temp files left by a snapshot that crashed before its rename.
+func (s *FileStore) snapshotFiles() ([]string, error) {
+	dir, base := filepath.Split(s.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list receipt snapshots: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base+snapshotInfix) {
+			continue
+		}
+		suffix := strings.TrimPrefix(name, base+snapshotInfix)
+		if strings.HasSuffix(suffix, ".tmp") {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if _, err := strconv.ParseUint(suffix, 10, 64); err == nil {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files, nil
+}
+
+// loadSnapshot loads the newest snapshot that reads back intact. Damaged
+// snapshots are skipped with a warning, and the sequence number of the newest
+// one is returned so the caller can check that the log still covers it.
+func (s *FileStore) loadSnapshot() (damaged uint64, err error) {
+	files, err := s.snapshotFiles()
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		snap, err := readSnapshot(file)
+		if err != nil {
+			log.Printf("Receipt snapshot %s: skipping: %v", file, err)
+			if damaged == 0 {
+				damaged, _ = strconv.ParseUint(strings.TrimPrefix(filepath.Base(file), filepath.Base(s.path)+snapshotInfix), 10, 64)
+			}
+			continue
+		}
+		for _, record := range snap.Records {
+			s.memory.Save(record)
+		}
+		s.memory.audit = snap.Audit
+		for id, history := range snap.History {
+			s.memory.history[id] = history
+		}
+		for _, key := range snap.Keys {
+			s.memory.SaveIdempotencyKey(key)
+		}
+		s.seq = snap.Seq
+		return damaged, nil
+	}
+	return damaged, nil
+}
+
+func readSnapshot(path string) (snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	if len(data) < frameHeaderSize {
+		return snapshot{}, errors.New("short header")
+	}
+	payload := data[frameHeaderSize:]
+	if binary.BigEndian.Uint32(data[0:4]) != uint32(len(payload)) {
+		return snapshot{}, errors.New("length mismatch")
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(data[4:8]) {
+		return snapshot{}, errors.New("checksum mismatch")
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(payload, &snap); err != nil {
+		return snapshot{}, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// Snapshot writes every record to a new snapshot and truncates the log. The
+// snapshot is fsynced and renamed into place before the log is touched, so a
+// crash at any point leaves either the old snapshot and full log or the new
+// snapshot, whose sequence number tells replay which entries to skip.
+func (s *FileStore) Snapshot() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.snapshot()
+}
+
+// maybeSnapshot takes a snapshot once enough entries have been appended. The
+// entry that triggered it is already durable, so a failure is only logged and
+// retried after another round of appends. Callers must hold mutex.
+func (s *FileStore) maybeSnapshot() {
+	if s.snapshotEvery <= 0 {
+		return
+	}
+	s.sinceSnapshot++
+	if s.sinceSnapshot < s.snapshotEvery {
+		return
+	}
+	s.sinceSnapshot = 0
+	if err := s.snapshot(); err != nil {
+		log.Printf("Receipt log %s: snapshot failed, log keeps growing: %v", s.path, err)
+	}
+}
+
+// snapshot does the work of Snapshot. Callers must hold mutex.
+func (s *FileStore) snapshot() error {
+	records, err := s.memory.List()
+	if err != nil {
+		return err
+	}
+	audit, err := s.memory.AuditLog("")
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(snapshot{
+		Seq:     s.seq,
+		Records: records,
+		Audit:   audit,
+		History: s.memory.pastRevisions(),
+		Keys:    s.memory.idempotencyKeys(time.Now()),
+	})
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	path := s.snapshotPath(s.seq)
+	if err := writeFileAtomic(path, frame(payload)); err != nil {
+		return err
+	}
+
+	if err := s.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate receipt log: %w", err)
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seek receipt log: %w", err)
+	}
+	s.offset = 0
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("sync receipt log: %w", err)
+	}
+	s.sinceSnapshot = 0
+
+	// Older snapshots can't be used any more now that the log they would
+	// replay from is gone.
+	if files, err := s.snapshotFiles(); err == nil {
+		for _, file := range files {
+			if file != filepath.Clean(path) {
+				os.Remove(file)
+			}
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file next to path, fsyncs it and
+// renames it over path, then fsyncs the directory so the rename survives a
+// crash.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("sync snapshot directory: %w", err)
+	}
+	defer dirFile.Close()
+	if err := dirFile.Sync(); err != nil {
+		return fmt.Errorf("sync snapshot directory: %w", err)
+	}
+	return nil
+}
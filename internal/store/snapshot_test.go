@@ -0,0 +1,234 @@
+package store_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"receipt-processor/internal/store/storetest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openSnapshotStore(t *testing.T, path string, opts ...store.FileOption) *store.FileStore {
+	t.Helper()
+	s, err := store.OpenFileStore(path, opts...)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func listIDs(t *testing.T, s store.Store) []string {
+	t.Helper()
+	records, err := s.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	ids := []string{}
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+func snapshots(t *testing.T, path string) []string {
+	t.Helper()
+	files, err := filepath.Glob(path + ".snapshot.*")
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	return files
+}
+
+func TestFileStoreSnapshots(t *testing.T) {
+	t.Run("Periodic Snapshot Truncates Log", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(3))
+		for _, id := range []string{"a", "b", "c", "d"} {
+			s.Save(storetest.Record(id))
+		}
+		s.Delete("b")
+
+		// The third append snapshotted and emptied the log, so only d and
+		// the delete are left in it.
+		if files := snapshots(t, path); len(files) != 1 {
+			t.Fatalf("snapshot files = %v, want exactly one", files)
+		}
+		if info, _ := os.Stat(path); info.Size() == 0 {
+			t.Error("expected the entries after the snapshot to stay in the log")
+		}
+		s.Close()
+
+		reopened := openSnapshotStore(t, path, store.WithSnapshotEvery(3))
+		if ids := listIDs(t, reopened); !reflect.DeepEqual(ids, []string{"a", "c", "d"}) {
+			t.Errorf("List() after reopen = %v, want [a c d]", ids)
+		}
+		if got, err := reopened.Get("a"); err != nil || !reflect.DeepEqual(got, storetest.Record("a")) {
+			t.Errorf("Get(a) from snapshot = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Old Snapshots Are Removed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		s.Save(storetest.Record("a"))
+		s.Snapshot()
+		s.Save(storetest.Record("b"))
+		if err := s.Snapshot(); err != nil {
+			t.Fatalf("Snapshot() error = %v", err)
+		}
+		if files := snapshots(t, path); len(files) != 1 {
+			t.Errorf("snapshot files = %v, want only the newest", files)
+		}
+		if info, _ := os.Stat(path); info.Size() != 0 {
+			t.Errorf("log size after snapshot = %d, want 0", info.Size())
+		}
+		s.Close()
+
+		if ids := listIDs(t, openSnapshotStore(t, path)); !reflect.DeepEqual(ids, []string{"a", "b"}) {
+			t.Errorf("List() after reopen = %v, want [a b]", ids)
+		}
+	})
+
+	t.Run("Erase And Audit Survive Reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		s.Save(storetest.Record("a"))
+		s.Save(storetest.Record("b"))
+		s.Erase("a", models.AuditRedact, at)
+		s.Snapshot()
+		s.Erase("b", models.AuditDelete, at)
+		s.Close()
+
+		// a's redaction comes from the snapshot, b's deletion from the log.
+		reopened := openSnapshotStore(t, path)
+		if got, _ := reopened.Get("a"); !reflect.DeepEqual(got, storetest.Record("a").Redacted(at)) {
+			t.Errorf("Get(a) after reopen = %+v, want it redacted", got)
+		}
+		if ids := listIDs(t, reopened); !reflect.DeepEqual(ids, []string{"a"}) {
+			t.Errorf("List() after reopen = %v, want [a]", ids)
+		}
+		entries, _ := reopened.AuditLog("")
+		want := []models.AuditEntry{
+			{ReceiptID: "a", Action: models.AuditRedact, At: at},
+			{ReceiptID: "b", Action: models.AuditDelete, At: at},
+		}
+		if !reflect.DeepEqual(entries, want) {
+			t.Errorf("AuditLog() after reopen = %+v, want %+v", entries, want)
+		}
+	})
+
+	t.Run("History Survives Reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		s.Save(storetest.Record("a"))
+		amendment := storetest.Record("a")
+		amendment.Score.Points = 40
+		s.Amend(amendment)
+		s.Snapshot()
+		amendment.Score.Points = 50
+		s.Amend(amendment)
+		want, _ := s.History("a")
+		s.Close()
+
+		// The first amendment comes from the snapshot, the second from the
+		// log.
+		history, err := openSnapshotStore(t, path).History("a")
+		if err != nil || !reflect.DeepEqual(history, want) {
+			t.Errorf("History() after reopen = %+v, %v; want %+v", history, err, want)
+		}
+		if len(history) != 3 || history[2].Revision != 3 {
+			t.Errorf("History() after reopen has %d versions, want revisions 1 to 3", len(history))
+		}
+	})
+
+	t.Run("Idempotency Keys Survive Reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		now := time.Now().UTC()
+		key := func(name string, created time.Time) store.IdempotencyKey {
+			return store.IdempotencyKey{Key: name, RequestHash: "hash", Status: 200,
+				Response: []byte(`{"id":"a"}`), CreatedAt: created, ExpiresAt: created.Add(time.Hour)}
+		}
+		s.SaveIdempotencyKey(key("expired", now.Add(-2*time.Hour)))
+		s.SaveIdempotencyKey(key("snapshotted", now))
+		s.Snapshot()
+		s.SaveIdempotencyKey(key("logged", now))
+		s.Close()
+
+		reopened := openSnapshotStore(t, path)
+		for _, name := range []string{"snapshotted", "logged"} {
+			if got, err := reopened.GetIdempotencyKey(name, now); err != nil || !reflect.DeepEqual(got, key(name, now)) {
+				t.Errorf("GetIdempotencyKey(%s) after reopen = %+v, %v", name, got, err)
+			}
+		}
+		if _, err := reopened.GetIdempotencyKey("expired", now.Add(-2*time.Hour)); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expired key was kept in the snapshot: %v", err)
+		}
+	})
+
+	t.Run("Crash Before Log Truncation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		s.Save(storetest.Record("a"))
+		s.Save(storetest.Record("b"))
+		s.Delete("a")
+		full, _ := os.ReadFile(path)
+		s.Snapshot()
+		s.Close()
+
+		// Put back the entries the snapshot already covers, as if the
+		// process died between writing the snapshot and truncating the log.
+		os.WriteFile(path, full, 0o644)
+
+		reopened := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		if ids := listIDs(t, reopened); !reflect.DeepEqual(ids, []string{"b"}) {
+			t.Fatalf("List() after reopen = %v, want [b]", ids)
+		}
+		reopened.Save(storetest.Record("c"))
+		reopened.Close()
+
+		if ids := listIDs(t, openSnapshotStore(t, path)); !reflect.DeepEqual(ids, []string{"b", "c"}) {
+			t.Errorf("List() after second reopen = %v, want [b c]", ids)
+		}
+	})
+
+	t.Run("Leftover Temp File Is Ignored", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		s.Save(storetest.Record("a"))
+		s.Close()
+
+		tmp := path + ".snapshot.00000000000000000009.123.tmp"
+		os.WriteFile(tmp, []byte("half written"), 0o644)
+
+		if ids := listIDs(t, openSnapshotStore(t, path)); !reflect.DeepEqual(ids, []string{"a"}) {
+			t.Errorf("List() = %v, want [a]", ids)
+		}
+		if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+			t.Errorf("expected the temp file to be removed, stat error = %v", err)
+		}
+	})
+
+	t.Run("Damaged Snapshot Without Log Refuses To Open", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.log")
+		s := openSnapshotStore(t, path, store.WithSnapshotEvery(0))
+		s.Save(storetest.Record("a"))
+		s.Snapshot()
+		s.Close()
+
+		file := snapshots(t, path)[0]
+		data, _ := os.ReadFile(file)
+		data[len(data)-2] ^= 0xff
+		os.WriteFile(file, data, 0o644)
+
+		if _, err := store.OpenFileStore(path); err == nil {
+			t.Error("expected an error rather than an empty store")
+		}
+	})
+}
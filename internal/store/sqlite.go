@@ -0,0 +1,867 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"receipt-processor/internal/models"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is a Store backed by an embedded SQLite database, so receipts
+// can be queried with SQL as well as looked up by ID. Receipts and their items
+// are kept in the receipts and items tables; the score breakdown and flags
+// are stored as JSON alongside the receipt.
+//
+// The driver is pure Go, so the server still builds without cgo.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// migrations are applied in order to bring a database up to date. The index
+// of a migration plus one is the schema version it produces, recorded in
+// PRAGMA user_version. Append new migrations; never edit released ones.
+var migrations = []string{
+	`CREATE TABLE receipts (
+		id                TEXT PRIMARY KEY,
+		retailer          TEXT NOT NULL,
+		purchase_date     TEXT NOT NULL,
+		purchase_time     TEXT NOT NULL,
+		total             TEXT NOT NULL,
+		total_cents       INTEGER,
+		points            INTEGER NOT NULL,
+		rule_version_name TEXT NOT NULL,
+		rule_version_hash TEXT NOT NULL,
+		breakdown         TEXT NOT NULL,
+		flags             TEXT NOT NULL
+	);
+	CREATE TABLE items (
+		receipt_id        TEXT NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+		position          INTEGER NOT NULL,
+		short_description TEXT NOT NULL,
+		price             TEXT NOT NULL,
+		price_cents       INTEGER,
+		PRIMARY KEY (receipt_id, position)
+	);
+	CREATE INDEX receipts_retailer ON receipts(retailer);
+	CREATE INDEX receipts_purchase_date ON receipts(purchase_date);`,
+	// Submission times are RFC 3339 in UTC with all nine fractional digits,
+	// so they sort as text. Receipts stored before this column existed keep
+	// an empty string.
+	`ALTER TABLE receipts ADD COLUMN submitted_at TEXT NOT NULL DEFAULT '';`,
+	// One index per Query order, each ending in id like the order itself.
+	`DROP INDEX receipts_purchase_date;
+	CREATE INDEX receipts_purchase ON receipts(purchase_date, purchase_time, id);
+	CREATE INDEX receipts_points ON receipts(points, id);
+	CREATE INDEX receipts_submitted_at ON receipts(submitted_at, id);`,
+	`ALTER TABLE receipts ADD COLUMN redacted_at TEXT NOT NULL DEFAULT '';
+	CREATE TABLE audit_log (
+		seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+		receipt_id TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		at         TEXT NOT NULL
+	);
+	CREATE INDEX audit_log_receipt ON audit_log(receipt_id, seq);`,
+	// Replaced versions of amended receipts are kept whole, as JSON, since
+	// they are only ever read back as a history.
+	`ALTER TABLE receipts ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE receipts ADD COLUMN amended_at TEXT NOT NULL DEFAULT '';
+	CREATE TABLE receipt_revisions (
+		receipt_id TEXT NOT NULL REFERENCES receipts(id) ON DELETE CASCADE,
+		revision   INTEGER NOT NULL,
+		record     TEXT NOT NULL,
+		PRIMARY KEY (receipt_id, revision)
+	);`,
+	// Fingerprints are computed in Go, so rows stored before this column
+	// existed are filled in by backfillFingerprints. Redacted receipts keep
+	// an empty string.
+	`ALTER TABLE receipts ADD COLUMN fingerprint TEXT NOT NULL DEFAULT '';
+	CREATE INDEX receipts_fingerprint ON receipts(fingerprint, submitted_at, id);`,
+	`CREATE TABLE idempotency_keys (
+		key          TEXT PRIMARY KEY,
+		request_hash TEXT NOT NULL,
+		status       INTEGER NOT NULL,
+		response     TEXT NOT NULL,
+		created_at   TEXT NOT NULL,
+		expires_at   TEXT NOT NULL
+	);
+	CREATE INDEX idempotency_keys_expires_at ON idempotency_keys(expires_at);`,
+}
+
+// OpenSQLiteStore opens the database at path, creating it if needed, and
+// applies any pending migrations. A database written by a newer version of
+// the server is refused rather than guessed at.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	// Overwrite deleted content with zeros, so erased receipts don't linger
+	// in free pages.
+	params.Add("_pragma", "secure_delete(1)")
+	params.Set("_txlock", "immediate")
+	// SQLite decodes the path of a file: URI, so characters such as ?, # and
+	// % in it must be escaped. The path goes in the opaque part, so a
+	// relative one isn't taken for a host.
+	escaped := (&url.URL{Path: path}).EscapedPath()
+	dsn := url.URL{Scheme: "file", Opaque: escaped, RawQuery: params.Encode()}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("open receipt database: %w", err)
+	}
+
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := s.backfillFingerprints(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies every migration newer than the database's schema version,
+// each in its own transaction.
+func (s *SQLiteStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read receipt database schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("receipt database schema version %d is newer than this server supports (%d)", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("migrate receipt database: %w", err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate receipt database to version %d: %w", i+1, err)
+		}
+		// PRAGMA doesn't take bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate receipt database to version %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrate receipt database to version %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// backfillFingerprints fingerprints the receipts stored before fingerprints
+// were, in one transaction. It finds nothing to do once they all have one.
+func (s *SQLiteStore) backfillFingerprints() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("fingerprint receipts: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id FROM receipts WHERE fingerprint = '' AND redacted_at = ''`)
+	if err != nil {
+		return fmt.Errorf("fingerprint receipts: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("fingerprint receipts: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fingerprint receipts: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	for _, id := range ids {
+		record, err := getRecord(tx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE receipts SET fingerprint = ? WHERE id = ?`, record.fingerprint(), id); err != nil {
+			return fmt.Errorf("fingerprint receipt %s: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("fingerprint receipts: %w", err)
+	}
+	return nil
+}
+
+// cents returns the amount in cents for the *_cents columns, or NULL if it
+// doesn't parse.
+func cents(amount string) sql.NullInt64 {
+	money, err := models.ParseMoney(amount)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: money.Cents(), Valid: true}
+}
+
+// parseTime reverses timeKey.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *SQLiteStore) Save(record Record) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("save receipt: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := writeRecord(tx, record); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("save receipt: %w", err)
+	}
+	return nil
+}
+
+// writeRecord inserts or replaces record and its items within tx. The
+// receipts row is updated in place rather than replaced, so its revisions
+// aren't deleted along with it.
+func writeRecord(tx *sql.Tx, record Record) error {
+	breakdown, err := json.Marshal(record.Score.Breakdown)
+	if err != nil {
+		return fmt.Errorf("encode score breakdown: %w", err)
+	}
+	flags, err := json.Marshal(record.Flags)
+	if err != nil {
+		return fmt.Errorf("encode flags: %w", err)
+	}
+
+	receipt := record.Receipt
+	_, err = tx.Exec(`INSERT INTO receipts (id, retailer, purchase_date, purchase_time, total, total_cents,
+		points, rule_version_name, rule_version_hash, breakdown, flags, submitted_at, redacted_at,
+		revision, amended_at, fingerprint)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET retailer = excluded.retailer, purchase_date = excluded.purchase_date,
+		purchase_time = excluded.purchase_time, total = excluded.total, total_cents = excluded.total_cents,
+		points = excluded.points, rule_version_name = excluded.rule_version_name,
+		rule_version_hash = excluded.rule_version_hash, breakdown = excluded.breakdown, flags = excluded.flags,
+		submitted_at = excluded.submitted_at, redacted_at = excluded.redacted_at,
+		revision = excluded.revision, amended_at = excluded.amended_at, fingerprint = excluded.fingerprint`,
+		record.ID, receipt.Retailer, receipt.PurchaseDate, receipt.PurchaseTime, receipt.Total, cents(receipt.Total),
+		record.Score.Points, record.Score.Version.Name, record.Score.Version.Hash, string(breakdown), string(flags),
+		timeKey(record.SubmittedAt), timeKey(record.RedactedAt), record.Revision, timeKey(record.AmendedAt),
+		record.fingerprint())
+	if err != nil {
+		return fmt.Errorf("save receipt: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM items WHERE receipt_id = ?`, record.ID); err != nil {
+		return fmt.Errorf("save receipt items: %w", err)
+	}
+	for i, item := range receipt.Items {
+		_, err := tx.Exec(`INSERT INTO items (receipt_id, position, short_description, price, price_cents)
+			VALUES (?, ?, ?, ?, ?)`,
+			record.ID, i, item.ShortDescription, item.Price, cents(item.Price))
+		if err != nil {
+			return fmt.Errorf("save receipt item %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+const selectReceipts = `SELECT id, retailer, purchase_date, purchase_time, total,
+	points, rule_version_name, rule_version_hash, breakdown, flags, submitted_at, redacted_at,
+	revision, amended_at FROM receipts`
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecord(row scanner) (Record, error) {
+	var (
+		record                                         Record
+		breakdown, flags, submitted, redacted, amended string
+	)
+	err := row.Scan(&record.ID, &record.Receipt.Retailer, &record.Receipt.PurchaseDate,
+		&record.Receipt.PurchaseTime, &record.Receipt.Total, &record.Score.Points,
+		&record.Score.Version.Name, &record.Score.Version.Hash, &breakdown, &flags, &submitted, &redacted,
+		&record.Revision, &amended)
+	if err != nil {
+		return Record{}, err
+	}
+	if record.SubmittedAt, err = parseTime(submitted); err != nil {
+		return Record{}, fmt.Errorf("decode submission time of %s: %w", record.ID, err)
+	}
+	if record.RedactedAt, err = parseTime(redacted); err != nil {
+		return Record{}, fmt.Errorf("decode redaction time of %s: %w", record.ID, err)
+	}
+	if record.AmendedAt, err = parseTime(amended); err != nil {
+		return Record{}, fmt.Errorf("decode amendment time of %s: %w", record.ID, err)
+	}
+	if err := json.Unmarshal([]byte(breakdown), &record.Score.Breakdown); err != nil {
+		return Record{}, fmt.Errorf("decode score breakdown of %s: %w", record.ID, err)
+	}
+	if err := json.Unmarshal([]byte(flags), &record.Flags); err != nil {
+		return Record{}, fmt.Errorf("decode flags of %s: %w", record.ID, err)
+	}
+	return record, nil
+}
+
+func (s *SQLiteStore) Get(id string) (Record, error) {
+	return getRecord(s.db, id)
+}
+
+func getRecord(q querier, id string) (Record, error) {
+	record, err := scanRecord(q.QueryRow(selectReceipts+` WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, ErrNotFound
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("get receipt: %w", err)
+	}
+
+	items, err := loadItems(q, `WHERE receipt_id = ?`, id)
+	if err != nil {
+		return Record{}, err
+	}
+	record.Receipt.Items = items[id]
+	return record, nil
+}
+
+func (s *SQLiteStore) List() ([]Record, error) {
+	rows, err := s.db.Query(selectReceipts + ` ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("list receipts: %w", err)
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list receipts: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list receipts: %w", err)
+	}
+	rows.Close()
+
+	items, err := loadItems(s.db, ``)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		records[i].Receipt.Items = items[records[i].ID]
+	}
+	return records, nil
+}
+
+// loadItems loads the items matching where, grouped by receipt ID in their
+// original order.
+func loadItems(q querier, where string, args ...any) (map[string][]models.Item, error) {
+	rows, err := q.Query(`SELECT receipt_id, short_description, price FROM items `+where+
+		` ORDER BY receipt_id, position`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load receipt items: %w", err)
+	}
+	defer rows.Close()
+
+	items := make(map[string][]models.Item)
+	for rows.Next() {
+		var (
+			id   string
+			item models.Item
+		)
+		if err := rows.Scan(&id, &item.ShortDescription, &item.Price); err != nil {
+			return nil, fmt.Errorf("load receipt items: %w", err)
+		}
+		items[id] = append(items[id], item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load receipt items: %w", err)
+	}
+	return items, nil
+}
+
+// sortColumns are the columns each Query order sorts by before id.
+var sortColumns = map[SortField][]string{
+	SortBySubmittedAt:  {"submitted_at"},
+	SortByPurchaseDate: {"purchase_date", "purchase_time"},
+	SortByPoints:       {"points"},
+}
+
+// cursorValues returns the sort column values a cursor points after.
+func cursorValues(cursor Cursor) ([]any, error) {
+	switch cursor.Sort {
+	case SortByPurchaseDate:
+		date, time, ok := strings.Cut(cursor.Key, " ")
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		return []any{date, time}, nil
+	case SortByPoints:
+		points, err := parsePointsKey(cursor.Key)
+		if err != nil {
+			return nil, err
+		}
+		return []any{points}, nil
+	}
+	return []any{cursor.Key}, nil
+}
+
+func (s *SQLiteStore) Query(q Query) (Page, error) {
+	if err := q.check(); err != nil {
+		return Page{}, err
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+	filter := func(clause string, value any) {
+		where = append(where, clause)
+		args = append(args, value)
+	}
+	if q.Retailer != "" {
+		filter("retailer = ?", q.Retailer)
+	}
+	if q.PurchasedFrom != "" {
+		filter("purchase_date >= ?", q.PurchasedFrom)
+	}
+	if q.PurchasedTo != "" {
+		filter("purchase_date <= ?", q.PurchasedTo)
+	}
+	if q.MinPoints != nil {
+		filter("points >= ?", *q.MinPoints)
+	}
+	if q.MaxPoints != nil {
+		filter("points <= ?", *q.MaxPoints)
+	}
+	if !q.SubmittedFrom.IsZero() {
+		filter("submitted_at >= ?", timeKey(q.SubmittedFrom))
+	}
+	if !q.SubmittedTo.IsZero() {
+		filter("submitted_at <= ?", timeKey(q.SubmittedTo))
+	}
+
+	columns := append(append([]string{}, sortColumns[q.Sort]...), "id")
+	direction, comparison := "ASC", ">"
+	if q.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if q.After != nil {
+		values, err := cursorValues(*q.After)
+		if err != nil {
+			return Page{}, err
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+		where = append(where, fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), comparison, placeholders))
+		args = append(append(args, values...), q.After.ID)
+	}
+
+	statement := selectReceipts
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	order := make([]string, len(columns))
+	for i, column := range columns {
+		order[i] = column + " " + direction
+	}
+	statement += " ORDER BY " + strings.Join(order, ", ") + " LIMIT ?"
+	// Fetch one extra record to learn whether another page follows.
+	args = append(args, q.Limit+1)
+
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		return Page{}, fmt.Errorf("query receipts: %w", err)
+	}
+	defer rows.Close()
+
+	page := Page{Records: []Record{}}
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return Page{}, fmt.Errorf("query receipts: %w", err)
+		}
+		page.Records = append(page.Records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, fmt.Errorf("query receipts: %w", err)
+	}
+	rows.Close()
+
+	if len(page.Records) > q.Limit {
+		page.Records = page.Records[:q.Limit]
+		page.Next = q.cursorFor(page.Records[q.Limit-1])
+	}
+	if len(page.Records) == 0 {
+		return page, nil
+	}
+
+	ids := make([]any, len(page.Records))
+	for i, record := range page.Records {
+		ids[i] = record.ID
+	}
+	items, err := loadItems(s.db, `WHERE receipt_id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+`)`, ids...)
+	if err != nil {
+		return Page{}, err
+	}
+	for i := range page.Records {
+		page.Records[i].Receipt.Items = items[page.Records[i].ID]
+	}
+	return page, nil
+}
+
+func (s *SQLiteStore) Delete(id string) error {
+	result, err := s.db.Exec(`DELETE FROM receipts WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete receipt: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete receipt: %w", err)
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Erase(id string, action models.AuditAction, at time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("erase receipt: %w", err)
+	}
+	defer tx.Rollback()
+
+	switch action {
+	case models.AuditDelete:
+		result, err := tx.Exec(`DELETE FROM receipts WHERE id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("delete receipt: %w", err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("delete receipt: %w", err)
+		}
+		if deleted == 0 {
+			return ErrNotFound
+		}
+	case models.AuditRedact:
+		if err := redactRow(tx, id, at); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown audit action %q", action)
+	}
+
+	_, err = tx.Exec(`INSERT INTO audit_log (receipt_id, action, at) VALUES (?, ?, ?)`, id, string(action), timeKey(at))
+	if err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("erase receipt: %w", err)
+	}
+	// The write-ahead log still holds the pages as they were before the
+	// erase. Copy it into the database and empty it.
+	var busy, pages, checkpointed int
+	err = s.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &pages, &checkpointed)
+	if err == nil && busy != 0 {
+		err = errors.New("database busy")
+	}
+	if err != nil {
+		log.Printf("Receipt database: checkpoint after erasing %s failed, erased data stays in the WAL until the next one: %v", id, err)
+	}
+	return nil
+}
+
+// redactRow applies Record.Redacted to the stored receipt id within tx.
+func redactRow(tx *sql.Tx, id string, at time.Time) error {
+	var encoded string
+	err := tx.QueryRow(`SELECT breakdown FROM receipts WHERE id = ?`, id).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("redact receipt: %w", err)
+	}
+	var record Record
+	if err := json.Unmarshal([]byte(encoded), &record.Score.Breakdown); err != nil {
+		return fmt.Errorf("decode score breakdown of %s: %w", id, err)
+	}
+	breakdown, err := json.Marshal(record.Redacted(at).Score.Breakdown)
+	if err != nil {
+		return fmt.Errorf("encode score breakdown: %w", err)
+	}
+
+	_, err = tx.Exec(`UPDATE receipts SET retailer = '', breakdown = ?, redacted_at = ?, fingerprint = ''
+		WHERE id = ?`,
+		string(breakdown), timeKey(at), id)
+	if err != nil {
+		return fmt.Errorf("redact receipt: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE items SET short_description = '' WHERE receipt_id = ?`, id); err != nil {
+		return fmt.Errorf("redact receipt items: %w", err)
+	}
+
+	revisions, err := loadRevisions(tx, id)
+	if err != nil {
+		return err
+	}
+	for _, revision := range revisions {
+		if err := writeRevision(tx, revision.Redacted(at), `UPDATE receipt_revisions SET record = ?
+			WHERE receipt_id = ? AND revision = ?`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRevisions returns the replaced versions of the receipt id, oldest
+// first.
+func loadRevisions(q querier, id string) ([]Record, error) {
+	rows, err := q.Query(`SELECT record FROM receipt_revisions WHERE receipt_id = ? ORDER BY revision`, id)
+	if err != nil {
+		return nil, fmt.Errorf("load receipt revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []Record
+	for rows.Next() {
+		var (
+			encoded  string
+			revision Record
+		)
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, fmt.Errorf("load receipt revisions: %w", err)
+		}
+		if err := json.Unmarshal([]byte(encoded), &revision); err != nil {
+			return nil, fmt.Errorf("decode revision of %s: %w", id, err)
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load receipt revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+// writeRevision runs statement, which takes the encoded record, its ID and
+// its revision number, to store a replaced version.
+func writeRevision(tx *sql.Tx, revision Record, statement string) error {
+	encoded, err := json.Marshal(revision)
+	if err != nil {
+		return fmt.Errorf("encode revision: %w", err)
+	}
+	if _, err := tx.Exec(statement, string(encoded), revision.ID, revision.revision()); err != nil {
+		return fmt.Errorf("write revision %d of %s: %w", revision.revision(), revision.ID, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Amend(record Record) (Record, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Record{}, fmt.Errorf("amend receipt: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := getRecord(tx, record.ID)
+	if err != nil {
+		return Record{}, err
+	}
+	err = writeRevision(tx, current, `INSERT INTO receipt_revisions (record, receipt_id, revision) VALUES (?, ?, ?)`)
+	if err != nil {
+		return Record{}, err
+	}
+	record = amended(current, record)
+	if err := writeRecord(tx, record); err != nil {
+		return Record{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Record{}, fmt.Errorf("amend receipt: %w", err)
+	}
+	return record, nil
+}
+
+func (s *SQLiteStore) Rescore(id string, revision int, score models.Score) (Record, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Record{}, fmt.Errorf("rescore receipt: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := getRecord(tx, id)
+	if err != nil {
+		return Record{}, err
+	}
+	record, err := rescored(current, revision, score)
+	if err != nil {
+		return Record{}, err
+	}
+	breakdown, err := json.Marshal(score.Breakdown)
+	if err != nil {
+		return Record{}, fmt.Errorf("encode score breakdown: %w", err)
+	}
+	_, err = tx.Exec(`UPDATE receipts SET points = ?, rule_version_name = ?, rule_version_hash = ?, breakdown = ?
+		WHERE id = ?`,
+		score.Points, score.Version.Name, score.Version.Hash, string(breakdown), id)
+	if err != nil {
+		return Record{}, fmt.Errorf("rescore receipt: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Record{}, fmt.Errorf("rescore receipt: %w", err)
+	}
+	return record, nil
+}
+
+func (s *SQLiteStore) History(id string) ([]Record, error) {
+	// Read both in one transaction so an amendment in between can't show up
+	// twice.
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("load receipt history: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := getRecord(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := loadRevisions(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return append(revisions, current), nil
+}
+
+func (s *SQLiteStore) AuditLog(receiptID string) ([]models.AuditEntry, error) {
+	statement := `SELECT receipt_id, action, at FROM audit_log`
+	var args []any
+	if receiptID != "" {
+		statement += ` WHERE receipt_id = ?`
+		args = append(args, receiptID)
+	}
+	rows, err := s.db.Query(statement+` ORDER BY seq`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var (
+			entry models.AuditEntry
+			at    string
+		)
+		if err := rows.Scan(&entry.ReceiptID, &entry.Action, &at); err != nil {
+			return nil, fmt.Errorf("read audit log: %w", err)
+		}
+		if entry.At, err = parseTime(at); err != nil {
+			return nil, fmt.Errorf("read audit log: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *SQLiteStore) FindByFingerprint(fingerprint string) (Record, error) {
+	// Redacted receipts are stored with an empty fingerprint.
+	if fingerprint == "" {
+		return Record{}, ErrNotFound
+	}
+	var id string
+	err := s.db.QueryRow(`SELECT id FROM receipts WHERE fingerprint = ? ORDER BY submitted_at, id LIMIT 1`,
+		fingerprint).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, ErrNotFound
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("find receipt by fingerprint: %w", err)
+	}
+	return getRecord(s.db, id)
+}
+
+func (s *SQLiteStore) SaveIdempotencyKey(key IdempotencyKey) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("save idempotency key: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, timeKey(key.CreatedAt)); err != nil {
+		return fmt.Errorf("purge idempotency keys: %w", err)
+	}
+	_, err = tx.Exec(`INSERT INTO idempotency_keys (key, request_hash, status, response, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET request_hash = excluded.request_hash, status = excluded.status,
+		response = excluded.response, created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		key.Key, key.RequestHash, key.Status, string(key.Response), timeKey(key.CreatedAt), timeKey(key.ExpiresAt))
+	if err != nil {
+		return fmt.Errorf("save idempotency key: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("save idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetIdempotencyKey(key string, now time.Time) (IdempotencyKey, error) {
+	var (
+		saved                IdempotencyKey
+		response             string
+		createdAt, expiresAt string
+	)
+	err := s.db.QueryRow(`SELECT key, request_hash, status, response, created_at, expires_at
+		FROM idempotency_keys WHERE key = ? AND expires_at > ?`, key, timeKey(now)).
+		Scan(&saved.Key, &saved.RequestHash, &saved.Status, &response, &createdAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IdempotencyKey{}, ErrNotFound
+	}
+	if err != nil {
+		return IdempotencyKey{}, fmt.Errorf("get idempotency key: %w", err)
+	}
+	saved.Response = json.RawMessage(response)
+	if saved.CreatedAt, err = parseTime(createdAt); err != nil {
+		return IdempotencyKey{}, fmt.Errorf("decode idempotency key %s: %w", key, err)
+	}
+	if saved.ExpiresAt, err = parseTime(expiresAt); err != nil {
+		return IdempotencyKey{}, fmt.Errorf("decode idempotency key %s: %w", key, err)
+	}
+	return saved, nil
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,162 @@
+package store_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"receipt-processor/internal/store"
+	"receipt-processor/internal/store/storetest"
+	"testing"
+)
+
+func openSQLiteStore(t *testing.T, path string) *store.SQLiteStore {
+	t.Helper()
+	s, err := store.OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return openSQLiteStore(t, filepath.Join(t.TempDir(), "receipts.db"))
+	})
+}
+
+func TestSQLiteStoreSchema(t *testing.T) {
+	t.Run("Items Are Normalized", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.db")
+		s := openSQLiteStore(t, path)
+		s.Save(storetest.Record("a"))
+		s.Save(storetest.Record("b"))
+		s.Delete("b")
+
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("sql.Open() error = %v", err)
+		}
+		defer db.Close()
+
+		var items, cents int64
+		err = db.QueryRow(`SELECT COUNT(*), SUM(price_cents) FROM items WHERE receipt_id = 'a'`).Scan(&items, &cents)
+		if err != nil {
+			t.Fatalf("query items: %v", err)
+		}
+		if items != 2 || cents != 350 {
+			t.Errorf("items for a = %d totalling %d cents, want 2 totalling 350", items, cents)
+		}
+		if err := db.QueryRow(`SELECT COUNT(*) FROM items WHERE receipt_id = 'b'`).Scan(&items); err != nil || items != 0 {
+			t.Errorf("items left for deleted receipt = %d, %v; want 0", items, err)
+		}
+	})
+
+	t.Run("Reopen Keeps Data", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.db")
+		s := openSQLiteStore(t, path)
+		s.Save(storetest.Record("a"))
+		s.Close()
+
+		if _, err := openSQLiteStore(t, path).Get("a"); err != nil {
+			t.Errorf("Get() after reopen error = %v", err)
+		}
+	})
+
+	t.Run("Path Needing Escapes", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "odd?name#with %20 and spaces")
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatalf("Mkdir() error = %v", err)
+		}
+		path := filepath.Join(dir, "receipts.db")
+		s := openSQLiteStore(t, path)
+		s.Save(storetest.Record("a"))
+		s.Close()
+
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("database not created at %s: %v", path, err)
+		}
+		if _, err := openSQLiteStore(t, path).Get("a"); err != nil {
+			t.Errorf("Get() after reopen error = %v", err)
+		}
+	})
+
+	t.Run("Relative Path", func(t *testing.T) {
+		dir := t.TempDir()
+		wd, _ := os.Getwd()
+		if err := os.Chdir(dir); err != nil {
+			t.Fatalf("Chdir() error = %v", err)
+		}
+		defer os.Chdir(wd)
+
+		s := openSQLiteStore(t, "receipts.db")
+		s.Save(storetest.Record("a"))
+		s.Close()
+		if _, err := os.Stat(filepath.Join(dir, "receipts.db")); err != nil {
+			t.Errorf("database not created in the working directory: %v", err)
+		}
+	})
+
+	t.Run("Upgrades Existing Database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.db")
+		s := openSQLiteStore(t, path)
+		s.Save(storetest.Record("a"))
+		s.Close()
+
+		// Roll the database back to schema version 1, from before
+		// submission times were stored and queries had their own indexes.
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("sql.Open() error = %v", err)
+		}
+		for _, statement := range []string{
+			`DROP TABLE idempotency_keys`,
+			`DROP INDEX receipts_fingerprint`,
+			`ALTER TABLE receipts DROP COLUMN fingerprint`,
+			`DROP TABLE receipt_revisions`,
+			`ALTER TABLE receipts DROP COLUMN revision`,
+			`ALTER TABLE receipts DROP COLUMN amended_at`,
+			`DROP TABLE audit_log`,
+			`ALTER TABLE receipts DROP COLUMN redacted_at`,
+			`DROP INDEX receipts_purchase`,
+			`DROP INDEX receipts_points`,
+			`DROP INDEX receipts_submitted_at`,
+			`CREATE INDEX receipts_purchase_date ON receipts(purchase_date)`,
+			`ALTER TABLE receipts DROP COLUMN submitted_at`,
+			`PRAGMA user_version = 1`,
+		} {
+			if _, err := db.Exec(statement); err != nil {
+				t.Fatalf("%s: %v", statement, err)
+			}
+		}
+		db.Close()
+
+		upgraded := openSQLiteStore(t, path)
+		record, err := upgraded.Get("a")
+		if err != nil {
+			t.Fatalf("Get() after upgrade error = %v", err)
+		}
+		if !record.SubmittedAt.IsZero() || record.Score.Points != storetest.Record("a").Score.Points {
+			t.Errorf("Get() after upgrade = %+v, want the old record without a submission time", record)
+		}
+		if found, err := upgraded.FindByFingerprint(record.Receipt.Fingerprint()); err != nil || found.ID != "a" {
+			t.Errorf("FindByFingerprint() after upgrade = %+v, %v; want the old record", found, err)
+		}
+	})
+
+	t.Run("Refuses Newer Schema", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "receipts.db")
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("sql.Open() error = %v", err)
+		}
+		if _, err := db.Exec(`PRAGMA user_version = 1000`); err != nil {
+			t.Fatalf("set user_version: %v", err)
+		}
+		db.Close()
+
+		if _, err := store.OpenSQLiteStore(path); err == nil {
+			t.Error("expected an error opening a database with a newer schema")
+		}
+	})
+}
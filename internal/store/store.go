@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"receipt-processor/internal/models"
+	"time"
+)
+
+// ErrNotFound is returned when no receipt is stored under the requested ID.
+var ErrNotFound = errors.New("receipt not found")
+
+// ErrConflict is returned by Rescore when the record changed after the
+// caller read it.
+var ErrConflict = errors.New("receipt changed since it was read")
+
+// Record is everything stored about one submitted receipt.
+type Record struct {
+	ID      string         `json:"id"`
+	Receipt models.Receipt `json:"receipt"`
+	Score   models.Score   `json:"score"`
+	// SubmittedAt is when the receipt was first accepted. It is zero for
+	// records written before it was tracked.
+	SubmittedAt time.Time `json:"submittedAt"`
+	// RedactedAt is when the receipt's identifying details were wiped, or
+	// zero if they never were.
+	RedactedAt time.Time `json:"redactedAt"`
+	// Revision numbers the versions of an amended receipt from 1. It is zero
+	// for records written before receipts could be amended, which count as
+	// revision 1.
+	Revision int `json:"revision,omitempty"`
+	// AmendedAt is when this revision replaced the previous one, or zero for
+	// the original submission.
+	AmendedAt time.Time `json:"amendedAt"`
+	// Flags lists problems that were noted but did not stop the receipt from
+	// being accepted, such as item prices that don't add up to the total.
+	Flags []models.FieldError `json:"flags,omitempty"`
+}
+
+// Store persists receipt records. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Save stores the record, replacing any record with the same ID.
+	Save(record Record) error
+	// Get returns the record stored under id, or ErrNotFound.
+	Get(id string) (Record, error)
+	// List returns every stored record ordered by ID.
+	List() ([]Record, error)
+	// Delete removes the record stored under id, or returns ErrNotFound.
+	Delete(id string) error
+	// Query returns the records matching q, in q's order, one page at a
+	// time. It returns ErrInvalidCursor if q.After belongs to another order.
+	Query(q Query) (Page, error)
+	// Erase deletes or redacts the record stored under id and appends the
+	// matching audit entry, as one change. It returns ErrNotFound, without
+	// auditing anything, if there is no such record.
+	Erase(id string, action models.AuditAction, at time.Time) error
+	// AuditLog returns the audit entries for receiptID, or every entry if
+	// receiptID is empty, oldest first.
+	AuditLog(receiptID string) ([]models.AuditEntry, error)
+	// Amend replaces the record stored under record.ID and keeps the version
+	// it replaces in the record's history. The new version gets the next
+	// revision number and keeps the original SubmittedAt; the stored record
+	// is returned. Amend returns ErrNotFound if there is no such record.
+	Amend(record Record) (Record, error)
+	// Rescore replaces the score of the record stored under id, but only if
+	// it is still the revision that was scored, so a re-score computed from
+	// an old copy never overwrites a newer one. A revision of 0 counts as 1.
+	// Redacted records keep their points and are never re-scored. It returns
+	// the stored record, ErrNotFound if there is no such record, or
+	// ErrConflict if it has changed or been redacted.
+	Rescore(id string, revision int, score models.Score) (Record, error)
+	// History returns every version of the record stored under id, oldest
+	// first and ending with the current one, or ErrNotFound. Deleting a
+	// record deletes its history, and redacting it redacts every version.
+	History(id string) ([]Record, error)
+	// FindByFingerprint returns the earliest submitted record whose receipt
+	// has the given models.Receipt.Fingerprint, or ErrNotFound. Redacted
+	// records never match.
+	FindByFingerprint(fingerprint string) (Record, error)
+	// SaveIdempotencyKey stores key until key.ExpiresAt, replacing any key
+	// with the same name. Keys that have expired by key.CreatedAt may be
+	// purged.
+	SaveIdempotencyKey(key IdempotencyKey) error
+	// GetIdempotencyKey returns the key named key if it hasn't expired at
+	// now, or ErrNotFound.
+	GetIdempotencyKey(key string, now time.Time) (IdempotencyKey, error)
+}
+
+// fingerprint returns the fingerprint the record is indexed under, or "" for
+// a redacted record, whose identifying details are gone.
+func (r Record) fingerprint() string {
+	if !r.RedactedAt.IsZero() {
+		return ""
+	}
+	return r.Receipt.Fingerprint()
+}
+
+// amended returns record as the revision that replaces current.
+func amended(current, record Record) Record {
+	record.Revision = current.revision() + 1
+	record.SubmittedAt = current.SubmittedAt
+	return record
+}
+
+// rescored returns current with score, or ErrConflict if current is no longer
+// the given revision or has been redacted.
+func rescored(current Record, revision int, score models.Score) (Record, error) {
+	if current.revision() != max(revision, 1) || !current.RedactedAt.IsZero() {
+		return Record{}, ErrConflict
+	}
+	current.Score = score
+	return current, nil
+}
+
+// revision returns the record's revision number, counting records from
+// before revisions were numbered as revision 1.
+func (r Record) revision() int {
+	if r.Revision == 0 {
+		return 1
+	}
+	return r.Revision
+}
+
+// Redacted returns a copy of the record with the retailer, item
+// descriptions and rule inputs wiped. The rule inputs are dropped because
+// they quote the receipt, including the wiped fields. Points, prices and
+// dates are kept.
+func (r Record) Redacted(at time.Time) Record {
+	r.Receipt.Retailer = ""
+	// Copy rather than edit in place; the slices may be shared with the
+	// caller's record.
+	var items []models.Item
+	for _, item := range r.Receipt.Items {
+		items = append(items, models.Item{Price: item.Price})
+	}
+	r.Receipt.Items = items
+
+	var breakdown []models.RuleBreakdown
+	for _, rule := range r.Score.Breakdown {
+		rule.Inputs = nil
+		breakdown = append(breakdown, rule)
+	}
+	r.Score.Breakdown = breakdown
+	r.RedactedAt = at
+	return r
+}
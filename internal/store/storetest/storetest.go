@@ -0,0 +1,827 @@
+// Package storetest is a conformance suite that every store.Store
+// implementation must pass.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"receipt-processor/internal/models"
+	"receipt-processor/internal/store"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Record returns a fully populated record for id, so backends are checked for
+// round-tripping every field.
+func Record(id string) store.Record {
+	return store.Record{
+		ID: id,
+		Receipt: models.Receipt{
+			Retailer:     "M&M Corner Market",
+			PurchaseDate: "2022-03-20",
+			PurchaseTime: "14:33",
+			Items: []models.Item{
+				{ShortDescription: "Gatorade", Price: "2.25"},
+				{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+			},
+			Total: "3.50",
+		},
+		Score: models.Score{
+			Points: 31,
+			Breakdown: []models.RuleBreakdown{
+				{
+					Rule:        "retailer-name",
+					Description: "One point for every alphanumeric character in the retailer name",
+					Points:      14,
+					Inputs:      map[string]string{"retailer": "M&M Corner Market", "alphanumericCharacters": "14"},
+				},
+				{
+					Rule:        "afternoon",
+					Description: "10 points if the time of purchase is after 14:00 and before 16:00",
+					Points:      10,
+					Inputs:      map[string]string{"purchaseTime": "14:33"},
+				},
+			},
+			Version: models.RuleVersion{Name: "default", Hash: "0123456789abcdef"},
+		},
+		SubmittedAt: time.Date(2022, 3, 20, 15, 2, 7, 123456789, time.UTC),
+		Revision:    1,
+		Flags: []models.FieldError{
+			{Path: "/total", Code: "total_mismatch", Message: "total 3.50 does not match the item prices"},
+		},
+	}
+}
+
+// Run checks that the stores returned by open behave like store.Store
+// promises. open must return a new, empty store on every call.
+func Run(t *testing.T, open func(t *testing.T) store.Store) {
+	t.Run("Save and Get", func(t *testing.T) {
+		s := open(t)
+		want := Record("receipt-1")
+		if err := s.Save(want); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		got, err := s.Get("receipt-1")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Get() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("Get Missing", func(t *testing.T) {
+		s := open(t)
+		if _, err := s.Get("missing"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Save Replaces", func(t *testing.T) {
+		s := open(t)
+		record := Record("receipt-1")
+		s.Save(record)
+
+		record.Score.Points = 99
+		record.Flags = nil
+		if err := s.Save(record); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+
+		got, err := s.Get("receipt-1")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if got.Score.Points != 99 || len(got.Flags) != 0 {
+			t.Errorf("Get() = %+v, want the replacement record", got)
+		}
+		records, _ := s.List()
+		if len(records) != 1 {
+			t.Errorf("List() returned %d records, want 1", len(records))
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		s := open(t)
+		if records, err := s.List(); err != nil || len(records) != 0 {
+			t.Fatalf("List() on empty store = %v, %v", records, err)
+		}
+
+		for _, id := range []string{"c", "a", "b"} {
+			s.Save(Record(id))
+		}
+		records, err := s.List()
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		var ids []string
+		for _, record := range records {
+			ids = append(ids, record.ID)
+		}
+		if !reflect.DeepEqual(ids, []string{"a", "b", "c"}) {
+			t.Errorf("List() IDs = %v, want [a b c]", ids)
+		}
+		if !reflect.DeepEqual(records[0], Record("a")) {
+			t.Errorf("List()[0] = %+v, want %+v", records[0], Record("a"))
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Save(Record("b"))
+
+		if err := s.Delete("a"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := s.Get("a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Get("b"); err != nil {
+			t.Errorf("Delete() removed the wrong record: %v", err)
+		}
+		if err := s.Delete("a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Concurrent Access", func(t *testing.T) {
+		s := open(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id := fmt.Sprintf("receipt-%02d", i)
+				record := Record(id)
+				record.Score.Points = int64(i)
+				if err := s.Save(record); err != nil {
+					t.Errorf("Save() error = %v", err)
+					return
+				}
+				got, err := s.Get(id)
+				if err != nil || got.Score.Points != int64(i) {
+					t.Errorf("Get(%s) = %d, %v; want %d", id, got.Score.Points, err, i)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		records, _ := s.List()
+		if len(records) != 20 {
+			t.Errorf("List() returned %d records, want 20", len(records))
+		}
+	})
+	t.Run("Query", func(t *testing.T) {
+		runQuery(t, open)
+	})
+
+	t.Run("Erase", func(t *testing.T) {
+		runErase(t, open)
+	})
+
+	t.Run("Amend", func(t *testing.T) {
+		runAmend(t, open)
+	})
+
+	t.Run("Rescore", func(t *testing.T) {
+		runRescore(t, open)
+	})
+
+	t.Run("Fingerprint", func(t *testing.T) {
+		runFingerprint(t, open)
+	})
+
+	t.Run("Idempotency Keys", func(t *testing.T) {
+		runIdempotency(t, open)
+	})
+}
+
+func runRescore(t *testing.T, open func(t *testing.T) store.Store) {
+	score := models.Score{
+		Points:    99,
+		Breakdown: []models.RuleBreakdown{{Rule: "flat", Description: "99 points", Points: 99}},
+		Version:   models.RuleVersion{Name: "next", Hash: "fedcba9876543210"},
+	}
+
+	t.Run("Replaces Score", func(t *testing.T) {
+		s := open(t)
+		original := Record("a")
+		s.Save(original)
+
+		rescored, err := s.Rescore("a", 1, score)
+		if err != nil {
+			t.Fatalf("Rescore() error = %v", err)
+		}
+		want := original
+		want.Score = score
+		if got, err := s.Get("a"); err != nil || !reflect.DeepEqual(got, want) || !reflect.DeepEqual(rescored, want) {
+			t.Errorf("Get() after Rescore() = %+v, %v; want %+v", got, err, want)
+		}
+		floor := int64(90)
+		if page, _ := s.Query(store.Query{MinPoints: &floor, Limit: 10}); len(page.Records) != 1 {
+			t.Errorf("Query() by the new points = %+v, want the rescored record", page.Records)
+		}
+	})
+
+	t.Run("Stale Revision", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		amendment := Record("a")
+		amendment.Receipt.Retailer = "Target"
+		latest, _ := s.Amend(amendment)
+
+		if _, err := s.Rescore("a", 1, score); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("Rescore() of a replaced revision error = %v, want ErrConflict", err)
+		}
+		if got, _ := s.Get("a"); !reflect.DeepEqual(got, latest) {
+			t.Errorf("Get() after a conflicting Rescore() = %+v, want %+v", got, latest)
+		}
+		if _, err := s.Rescore("a", 2, score); err != nil {
+			t.Errorf("Rescore() of the current revision error = %v", err)
+		}
+	})
+
+	t.Run("Redacted Record", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Erase("a", models.AuditRedact, time.Now().UTC())
+		redacted, _ := s.Get("a")
+		if _, err := s.Rescore("a", 1, score); !errors.Is(err, store.ErrConflict) {
+			t.Errorf("Rescore() of a redacted record error = %v, want ErrConflict", err)
+		}
+		if got, _ := s.Get("a"); !reflect.DeepEqual(got, redacted) {
+			t.Errorf("Get() after Rescore() of a redacted record = %+v, want %+v", got, redacted)
+		}
+	})
+
+	t.Run("Missing Record", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Erase("a", models.AuditDelete, time.Now().UTC())
+		if _, err := s.Rescore("a", 1, score); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Rescore() of a deleted record error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Get("a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Rescore() brought back a deleted record: Get() error = %v", err)
+		}
+	})
+}
+
+func runIdempotency(t *testing.T, open func(t *testing.T) store.Store) {
+	start := time.Date(2024, 8, 1, 9, 0, 0, 0, time.UTC)
+	key := func(name string, created time.Time) store.IdempotencyKey {
+		return store.IdempotencyKey{
+			Key:         name,
+			RequestHash: "hash-" + name,
+			Status:      200,
+			Response:    []byte(`{"id":"receipt-` + name + `"}`),
+			CreatedAt:   created,
+			ExpiresAt:   created.Add(time.Hour),
+		}
+	}
+
+	t.Run("Round Trip", func(t *testing.T) {
+		s := open(t)
+		want := key("k1", start)
+		if err := s.SaveIdempotencyKey(want); err != nil {
+			t.Fatalf("SaveIdempotencyKey() error = %v", err)
+		}
+		got, err := s.GetIdempotencyKey("k1", start.Add(time.Minute))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("GetIdempotencyKey() = %+v, %v; want %+v", got, err, want)
+		}
+		if _, err := s.GetIdempotencyKey("k2", start); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("GetIdempotencyKey() of an unknown key error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		s := open(t)
+		s.SaveIdempotencyKey(key("k1", start))
+		if _, err := s.GetIdempotencyKey("k1", start.Add(time.Hour)); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("GetIdempotencyKey() at expiry error = %v, want ErrNotFound", err)
+		}
+
+		// A later save purges the expired key, so it can't come back.
+		s.SaveIdempotencyKey(key("k2", start.Add(2*time.Hour)))
+		if _, err := s.GetIdempotencyKey("k1", start); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("GetIdempotencyKey() of a purged key error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.GetIdempotencyKey("k2", start.Add(2*time.Hour)); err != nil {
+			t.Errorf("GetIdempotencyKey() error = %v", err)
+		}
+	})
+
+	t.Run("Reused After Expiry", func(t *testing.T) {
+		s := open(t)
+		s.SaveIdempotencyKey(key("k1", start))
+		reused := key("k1", start.Add(2*time.Hour))
+		reused.RequestHash = "another body"
+		s.SaveIdempotencyKey(reused)
+
+		got, err := s.GetIdempotencyKey("k1", start.Add(2*time.Hour))
+		if err != nil || got.RequestHash != "another body" {
+			t.Errorf("GetIdempotencyKey() = %+v, %v; want the reused key", got, err)
+		}
+	})
+}
+
+func runFingerprint(t *testing.T, open func(t *testing.T) store.Store) {
+	fingerprint := Record("a").Receipt.Fingerprint()
+
+	t.Run("Finds Earliest", func(t *testing.T) {
+		s := open(t)
+		for i, id := range []string{"c", "a", "b"} {
+			record := Record(id)
+			record.SubmittedAt = record.SubmittedAt.Add(time.Duration(i) * time.Minute)
+			s.Save(record)
+		}
+		other := Record("d")
+		other.Receipt.Total = "9.99"
+		other.SubmittedAt = time.Time{}
+		s.Save(other)
+
+		got, err := s.FindByFingerprint(fingerprint)
+		if err != nil || got.ID != "c" {
+			t.Errorf("FindByFingerprint() = %q, %v; want the earliest submission c", got.ID, err)
+		}
+		if got, err := s.FindByFingerprint(other.Receipt.Fingerprint()); err != nil || got.ID != "d" {
+			t.Errorf("FindByFingerprint() = %q, %v; want d", got.ID, err)
+		}
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		s := open(t)
+		if _, err := s.FindByFingerprint(fingerprint); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("FindByFingerprint() on an empty store error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.FindByFingerprint(""); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("FindByFingerprint(\"\") error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Follows Changes", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Save(Record("b"))
+		s.Save(Record("c"))
+
+		amendment := Record("a")
+		amendment.Receipt.Total = "9.99"
+		s.Amend(amendment)
+		s.Erase("b", models.AuditDelete, time.Now())
+		s.Erase("c", models.AuditRedact, time.Now())
+
+		if got, err := s.FindByFingerprint(fingerprint); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("FindByFingerprint() = %q, %v; want ErrNotFound after amend, delete and redact", got.ID, err)
+		}
+		if got, err := s.FindByFingerprint(amendment.Receipt.Fingerprint()); err != nil || got.ID != "a" {
+			t.Errorf("FindByFingerprint() of the amendment = %q, %v; want a", got.ID, err)
+		}
+	})
+}
+
+func runAmend(t *testing.T, open func(t *testing.T) store.Store) {
+	amendment := func(retailer string, points int64, at time.Time) store.Record {
+		record := Record("a")
+		record.Receipt.Retailer = retailer
+		record.Score.Points = points
+		record.SubmittedAt = time.Time{}
+		record.Revision = 0
+		record.AmendedAt = at
+		return record
+	}
+	first := time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	t.Run("Keeps Every Revision", func(t *testing.T) {
+		s := open(t)
+		original := Record("a")
+		s.Save(original)
+
+		saved, err := s.Amend(amendment("Target", 40, first))
+		if err != nil {
+			t.Fatalf("Amend() error = %v", err)
+		}
+		if saved.Revision != 2 || !saved.SubmittedAt.Equal(original.SubmittedAt) {
+			t.Errorf("Amend() = revision %d submitted %v, want revision 2 submitted %v",
+				saved.Revision, saved.SubmittedAt, original.SubmittedAt)
+		}
+		latest, _ := s.Amend(amendment("Walgreens", 50, second))
+
+		if got, err := s.Get("a"); err != nil || !reflect.DeepEqual(got, latest) {
+			t.Errorf("Get() = %+v, %v; want the latest revision %+v", got, err, latest)
+		}
+		history, err := s.History("a")
+		if err != nil {
+			t.Fatalf("History() error = %v", err)
+		}
+		want := []store.Record{original, saved, latest}
+		if !reflect.DeepEqual(history, want) {
+			t.Errorf("History() = %+v, want %+v", history, want)
+		}
+		page, _ := s.Query(store.Query{Retailer: "Walgreens", Limit: 10})
+		if len(page.Records) != 1 || page.Records[0].Score.Points != 50 {
+			t.Errorf("Query() by amended retailer = %+v, want the latest revision", page.Records)
+		}
+		if page, _ := s.Query(store.Query{Retailer: "Target", Limit: 10}); len(page.Records) != 0 {
+			t.Errorf("Query() still finds a replaced revision: %+v", page.Records)
+		}
+	})
+
+	t.Run("Unamended Record", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		history, err := s.History("a")
+		if err != nil || !reflect.DeepEqual(history, []store.Record{Record("a")}) {
+			t.Errorf("History() = %+v, %v; want only the original", history, err)
+		}
+	})
+
+	t.Run("Missing Record", func(t *testing.T) {
+		s := open(t)
+		if _, err := s.Amend(amendment("Target", 1, first)); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Amend() error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.History("a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("History() error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Delete Removes History", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Amend(amendment("Target", 40, first))
+		s.Erase("a", models.AuditDelete, second)
+		s.Save(Record("a"))
+
+		history, _ := s.History("a")
+		if len(history) != 1 {
+			t.Errorf("History() after delete and resubmit has %d versions, want 1", len(history))
+		}
+	})
+
+	t.Run("Redact Covers History", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Amend(amendment("Target", 40, first))
+		s.Erase("a", models.AuditRedact, second)
+
+		history, _ := s.History("a")
+		if len(history) != 2 {
+			t.Fatalf("History() has %d versions, want 2", len(history))
+		}
+		for _, revision := range history {
+			if revision.Receipt.Retailer != "" || revision.Receipt.Items[0].ShortDescription != "" ||
+				!revision.RedactedAt.Equal(second) {
+				t.Errorf("revision %d survived redaction: %+v", revision.Revision, revision)
+			}
+		}
+	})
+}
+
+func runErase(t *testing.T, open func(t *testing.T) store.Store) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Delete", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Save(Record("b"))
+
+		if err := s.Erase("a", models.AuditDelete, at); err != nil {
+			t.Fatalf("Erase() error = %v", err)
+		}
+		if _, err := s.Get("a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Get() after delete error = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Get("b"); err != nil {
+			t.Errorf("Erase() removed the wrong record: %v", err)
+		}
+		entries, err := s.AuditLog("a")
+		want := []models.AuditEntry{{ReceiptID: "a", Action: models.AuditDelete, At: at}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("AuditLog() = %+v, %v; want %+v", entries, err, want)
+		}
+	})
+
+	t.Run("Redact", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+
+		if err := s.Erase("a", models.AuditRedact, at); err != nil {
+			t.Fatalf("Erase() error = %v", err)
+		}
+		got, err := s.Get("a")
+		if err != nil {
+			t.Fatalf("Get() after redact error = %v", err)
+		}
+		if want := Record("a").Redacted(at); !reflect.DeepEqual(got, want) {
+			t.Errorf("Get() after redact = %+v, want %+v", got, want)
+		}
+		if got.Receipt.Retailer != "" || got.Receipt.Items[0].ShortDescription != "" || got.Score.Breakdown[0].Inputs != nil {
+			t.Errorf("identifying details survived redaction: %+v", got)
+		}
+		if got.Score.Points != Record("a").Score.Points || got.Receipt.Items[0].Price != "2.25" {
+			t.Errorf("redaction lost points or prices: %+v", got)
+		}
+		page, _ := s.Query(store.Query{Retailer: Record("a").Receipt.Retailer, Limit: 10})
+		if len(page.Records) != 0 {
+			t.Errorf("Query() still finds the redacted retailer: %+v", page.Records)
+		}
+	})
+
+	t.Run("Audit Log Order And Filter", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		s.Save(Record("b"))
+		s.Erase("b", models.AuditRedact, at)
+		s.Erase("a", models.AuditDelete, at.Add(time.Minute))
+		s.Erase("b", models.AuditDelete, at.Add(2*time.Minute))
+
+		entries, _ := s.AuditLog("")
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.ReceiptID+":"+string(entry.Action))
+		}
+		if want := []string{"b:redact", "a:delete", "b:delete"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("AuditLog() = %v, want %v", got, want)
+		}
+		if entries, _ := s.AuditLog("b"); len(entries) != 2 {
+			t.Errorf("AuditLog(b) returned %d entries, want 2", len(entries))
+		}
+		if entries, err := s.AuditLog("missing"); err != nil || len(entries) != 0 {
+			t.Errorf("AuditLog(missing) = %v, %v; want none", entries, err)
+		}
+	})
+
+	t.Run("Missing Record", func(t *testing.T) {
+		s := open(t)
+		if err := s.Erase("missing", models.AuditDelete, at); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Erase() error = %v, want ErrNotFound", err)
+		}
+		if err := s.Erase("missing", models.AuditRedact, at); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Erase() error = %v, want ErrNotFound", err)
+		}
+		if entries, _ := s.AuditLog(""); len(entries) != 0 {
+			t.Errorf("AuditLog() = %+v, want nothing audited", entries)
+		}
+	})
+
+	t.Run("Unknown Action", func(t *testing.T) {
+		s := open(t)
+		s.Save(Record("a"))
+		if err := s.Erase("a", "shred", at); err == nil {
+			t.Error("expected an error for an unknown action")
+		}
+		if got, err := s.Get("a"); err != nil || !reflect.DeepEqual(got, Record("a")) {
+			t.Errorf("Get() after failed Erase() = %+v, %v; want the record unchanged", got, err)
+		}
+	})
+}
+
+// queryRecords returns records with varied retailers, dates, points and
+// submission times, including ties on every sort field.
+func queryRecords() []store.Record {
+	retailers := []string{"Target", "Walgreens", "M&M Corner Market"}
+	base := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	var records []store.Record
+	for i := 0; i < 15; i++ {
+		record := Record(fmt.Sprintf("receipt-%02d", (i*7)%15))
+		record.Receipt.Retailer = retailers[i%3]
+		record.Receipt.PurchaseDate = fmt.Sprintf("2024-04-%02d", 10+i%5)
+		record.Receipt.PurchaseTime = fmt.Sprintf("%02d:00", 10+i%4)
+		record.Score.Points = int64((i * 13) % 40)
+		record.SubmittedAt = base.Add(time.Duration(i/2) * time.Hour)
+		if i == 14 {
+			// Stored before submission times were recorded.
+			record.SubmittedAt = time.Time{}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// less orders records the way Query documents for field.
+func less(a, b store.Record, field store.SortField) bool {
+	switch field {
+	case store.SortByPurchaseDate:
+		ka := a.Receipt.PurchaseDate + " " + a.Receipt.PurchaseTime
+		kb := b.Receipt.PurchaseDate + " " + b.Receipt.PurchaseTime
+		if ka != kb {
+			return ka < kb
+		}
+	case store.SortByPoints:
+		if a.Score.Points != b.Score.Points {
+			return a.Score.Points < b.Score.Points
+		}
+	default:
+		if !a.SubmittedAt.Equal(b.SubmittedAt) {
+			return a.SubmittedAt.Before(b.SubmittedAt)
+		}
+	}
+	return a.ID < b.ID
+}
+
+// expect returns the IDs Query should return for q over records, ignoring
+// paging.
+func expect(records []store.Record, q store.Query, matches func(store.Record) bool) []string {
+	var want []store.Record
+	for _, record := range records {
+		if matches(record) {
+			want = append(want, record)
+		}
+	}
+	field := q.Sort
+	if field == "" {
+		field = store.SortBySubmittedAt
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if q.Descending {
+			return less(want[j], want[i], field)
+		}
+		return less(want[i], want[j], field)
+	})
+	ids := []string{}
+	for _, record := range want {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+// queryAll follows Next through every page of q and returns the IDs.
+func queryAll(t *testing.T, s store.Store, q store.Query) []string {
+	t.Helper()
+	ids := []string{}
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("Query() kept returning pages")
+		}
+		page, err := s.Query(q)
+		if err != nil {
+			t.Fatalf("Query(%+v) error = %v", q, err)
+		}
+		if len(page.Records) > q.Limit {
+			t.Fatalf("Query() returned %d records, limit %d", len(page.Records), q.Limit)
+		}
+		for _, record := range page.Records {
+			ids = append(ids, record.ID)
+		}
+		if page.Next == nil {
+			return ids
+		}
+		q.After = page.Next
+	}
+}
+
+func runQuery(t *testing.T, open func(t *testing.T) store.Store) {
+	records := queryRecords()
+	s := open(t)
+	for _, record := range records {
+		if err := s.Save(record); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	points := func(n int64) *int64 { return &n }
+	everything := func(store.Record) bool { return true }
+
+	tests := []struct {
+		name    string
+		query   store.Query
+		matches func(store.Record) bool
+	}{
+		{"Default Order", store.Query{}, everything},
+		{"Submitted Descending", store.Query{Sort: store.SortBySubmittedAt, Descending: true}, everything},
+		{"Purchase Date", store.Query{Sort: store.SortByPurchaseDate}, everything},
+		{"Points Descending", store.Query{Sort: store.SortByPoints, Descending: true}, everything},
+		{
+			"Retailer",
+			store.Query{Retailer: "Walgreens", Sort: store.SortByPoints},
+			func(r store.Record) bool { return r.Receipt.Retailer == "Walgreens" },
+		},
+		{
+			"Purchase Date Range",
+			store.Query{PurchasedFrom: "2024-04-11", PurchasedTo: "2024-04-13", Sort: store.SortByPurchaseDate, Descending: true},
+			func(r store.Record) bool {
+				return r.Receipt.PurchaseDate >= "2024-04-11" && r.Receipt.PurchaseDate <= "2024-04-13"
+			},
+		},
+		{
+			"Points Range",
+			store.Query{MinPoints: points(10), MaxPoints: points(26), Sort: store.SortByPoints},
+			func(r store.Record) bool { return r.Score.Points >= 10 && r.Score.Points <= 26 },
+		},
+		{
+			"Points Range In Another Order",
+			store.Query{MinPoints: points(10), MaxPoints: points(26), Sort: store.SortByPurchaseDate},
+			func(r store.Record) bool { return r.Score.Points >= 10 && r.Score.Points <= 26 },
+		},
+		{
+			"Purchase Date Range In Points Order",
+			store.Query{PurchasedFrom: "2024-04-12", PurchasedTo: "2024-04-12", Sort: store.SortByPoints, Descending: true},
+			func(r store.Record) bool { return r.Receipt.PurchaseDate == "2024-04-12" },
+		},
+		{
+			"Empty Range In Another Order",
+			store.Query{MinPoints: points(5000), Sort: store.SortBySubmittedAt},
+			func(store.Record) bool { return false },
+		},
+		{
+			"Submission Time Range",
+			store.Query{
+				SubmittedFrom: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+				SubmittedTo:   time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC),
+			},
+			func(r store.Record) bool {
+				return !r.SubmittedAt.Before(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) &&
+					!r.SubmittedAt.After(time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC))
+			},
+		},
+		{
+			"Combined Filters",
+			store.Query{Retailer: "Target", PurchasedFrom: "2024-04-12", MinPoints: points(5), Sort: store.SortByPoints, Descending: true},
+			func(r store.Record) bool {
+				return r.Receipt.Retailer == "Target" && r.Receipt.PurchaseDate >= "2024-04-12" && r.Score.Points >= 5
+			},
+		},
+		{"No Matches", store.Query{Retailer: "Nobody"}, func(store.Record) bool { return false }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := expect(records, tt.query, tt.matches)
+			for _, limit := range []int{1, 4, 100} {
+				q := tt.query
+				q.Limit = limit
+				if got := queryAll(t, s, q); !reflect.DeepEqual(got, want) {
+					t.Errorf("limit %d: got %v, want %v", limit, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Returns Whole Records", func(t *testing.T) {
+		page, err := s.Query(store.Query{Sort: store.SortByPoints, Limit: 100})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		for _, record := range page.Records {
+			if stored, _ := s.Get(record.ID); !reflect.DeepEqual(record, stored) {
+				t.Errorf("Query() record = %+v, want %+v", record, stored)
+			}
+		}
+	})
+
+	t.Run("Pages Stay Stable Across Writes", func(t *testing.T) {
+		s := open(t)
+		for _, record := range records {
+			s.Save(record)
+		}
+		q := store.Query{Sort: store.SortByPoints, Limit: 5}
+		first, _ := s.Query(q)
+
+		// A record sorting before the cursor and one after it arrive, and
+		// a record already returned goes away.
+		early := Record("early")
+		early.Score.Points = -1
+		late := Record("late")
+		late.Score.Points = 1000
+		s.Save(early)
+		s.Save(late)
+		s.Delete(first.Records[0].ID)
+
+		q.After = first.Next
+		rest := queryAll(t, s, q)
+		var seen []string
+		for _, record := range first.Records {
+			seen = append(seen, record.ID)
+		}
+		all := append(seen, rest...)
+		want := append(expect(records, store.Query{Sort: store.SortByPoints}, everything), "late")
+		if !reflect.DeepEqual(all, want) {
+			t.Errorf("pages = %v, want %v", all, want)
+		}
+	})
+
+	t.Run("Cursor From Another Order", func(t *testing.T) {
+		page, _ := s.Query(store.Query{Sort: store.SortByPoints, Limit: 1})
+		_, err := s.Query(store.Query{Sort: store.SortByPurchaseDate, Limit: 1, After: page.Next})
+		if !errors.Is(err, store.ErrInvalidCursor) {
+			t.Errorf("Query() error = %v, want ErrInvalidCursor", err)
+		}
+	})
+
+	t.Run("Cursor Round Trips", func(t *testing.T) {
+		page, _ := s.Query(store.Query{Sort: store.SortByPurchaseDate, Descending: true, Limit: 2})
+		cursor, err := store.ParseCursor(page.Next.String())
+		if err != nil || cursor != *page.Next {
+			t.Fatalf("ParseCursor() = %+v, %v; want %+v", cursor, err, *page.Next)
+		}
+		if _, err := store.ParseCursor("not a cursor"); !errors.Is(err, store.ErrInvalidCursor) {
+			t.Errorf("ParseCursor() error = %v, want ErrInvalidCursor", err)
+		}
+	})
+}
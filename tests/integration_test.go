@@ -5,21 +5,44 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"receipt-processor/internal/handlers"
 	"receipt-processor/internal/models"
+	"receipt-processor/internal/service"
 	"receipt-processor/internal/store"
 	"testing"
+	"time"
 )
 
 func setupRouter() http.Handler {
 	store := store.NewStore()
-	handler := handlers.NewReceiptHandler(store)
+	webhooks := service.NewWebhooks(nil, service.DefaultRetryPolicy, service.AllowPrivateWebhooks())
+	handler := handlers.NewReceiptHandler(store, handlers.WithWebhooks(webhooks))
+	hooks := handlers.NewWebhookHandler(webhooks)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/receipts/process", handler.ProcessReceipt).Methods("POST")
+	router.HandleFunc("/receipts/simulate", handler.SimulateReceipt).Methods("POST")
+	router.HandleFunc("/receipts/batch", handler.ProcessBatch).Methods("POST")
+	router.HandleFunc("/receipts/import", handler.ImportReceipts).Methods("POST")
+	router.HandleFunc("/receipts", handler.ListReceipts).Methods("GET")
+	router.HandleFunc("/receipts/{id}", handler.GetReceipt).Methods("GET")
+	router.HandleFunc("/receipts/{id}", handler.AmendReceipt).Methods("PUT")
+	router.HandleFunc("/receipts/{id}", handler.DeleteReceipt).Methods("DELETE")
+	router.HandleFunc("/receipts/{id}/history", handler.GetReceiptHistory).Methods("GET")
 	router.HandleFunc("/receipts/{id}/points", handler.GetPoints).Methods("GET")
+	router.HandleFunc("/receipts/{id}/points/breakdown", handler.GetPointsBreakdown).Methods("GET")
+	router.HandleFunc("/jobs/{id}", handler.GetJob).Methods("GET")
+	router.HandleFunc("/events", handler.StreamEvents).Methods("GET")
+	router.HandleFunc("/webhooks", hooks.CreateWebhook).Methods("POST")
+	router.HandleFunc("/webhooks", hooks.ListWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters", hooks.ListDeadLetters).Methods("GET")
+	router.HandleFunc("/webhooks/dead-letters/{id}/redeliver", hooks.RedeliverDeadLetter).Methods("POST")
+	router.HandleFunc("/webhooks/{id}", hooks.GetWebhook).Methods("GET")
+	router.HandleFunc("/webhooks/{id}", hooks.DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id}/deliveries", hooks.GetWebhookDeliveries).Methods("GET")
 	return router
 }
 
@@ -71,6 +94,206 @@ func TestIntegrationReceiptProcessing(t *testing.T) {
 		if pointsResponse.Points != expectedPoints {
 			t.Errorf("Expected %d points, got %d", expectedPoints, pointsResponse.Points)
 		}
+		if pointsResponse.RuleVersion != service.DefaultRegistry.Version() {
+			t.Errorf("Expected rule version %v, got %v", service.DefaultRegistry.Version(), pointsResponse.RuleVersion)
+		}
+
+		// Get the stored receipt back
+		resp, err = http.Get(fmt.Sprintf("%s/receipts/%s", server.URL, receiptResponse.ID))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get receipt: %v", err)
+		}
+
+		var stored models.ReceiptDetailResponse
+		if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+			t.Fatalf("Failed to decode receipt response: %v", err)
+		}
+		resp.Body.Close()
+
+		if stored.ID != receiptResponse.ID || stored.Points != expectedPoints || stored.SubmittedAt == nil {
+			t.Errorf("Unexpected stored receipt %+v", stored)
+		}
+		if stored.Retailer != receipt.Retailer || len(stored.Items) != 1 || stored.Items[0] != receipt.Items[0] {
+			t.Errorf("Expected the submitted receipt back, got %+v", stored.Receipt)
+		}
+	})
+
+	t.Run("Points Breakdown Matches Total", func(t *testing.T) {
+		receipt := models.Receipt{
+			Retailer:     "M&M Corner Market",
+			PurchaseDate: "2022-03-20",
+			PurchaseTime: "14:33",
+			Items: []models.Item{
+				{ShortDescription: "Gatorade", Price: "2.25"},
+				{ShortDescription: "Gatorade", Price: "2.25"},
+				{ShortDescription: "Gatorade", Price: "2.25"},
+				{ShortDescription: "Gatorade", Price: "2.25"},
+			},
+			Total: "9.00",
+		}
+
+		receiptJSON, _ := json.Marshal(receipt)
+		resp, err := http.Post(fmt.Sprintf("%s/receipts/process", server.URL),
+			"application/json",
+			bytes.NewBuffer(receiptJSON))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to process receipt: %v", err)
+		}
+
+		var receiptResponse models.ReceiptResponse
+		if err := json.NewDecoder(resp.Body).Decode(&receiptResponse); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		resp.Body.Close()
+
+		resp, err = http.Get(fmt.Sprintf("%s/receipts/%s/points/breakdown", server.URL, receiptResponse.ID))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get points breakdown: %v", err)
+		}
+
+		var breakdownResponse models.PointsBreakdownResponse
+		if err := json.NewDecoder(resp.Body).Decode(&breakdownResponse); err != nil {
+			t.Fatalf("Failed to decode breakdown response: %v", err)
+		}
+		resp.Body.Close()
+
+		if breakdownResponse.Points != 109 {
+			t.Errorf("Expected 109 points, got %d", breakdownResponse.Points)
+		}
+		var sum int64
+		for _, rule := range breakdownResponse.Breakdown {
+			sum += rule.Points
+		}
+		if sum != breakdownResponse.Points {
+			t.Errorf("Breakdown sums to %d, want %d", sum, breakdownResponse.Points)
+		}
+	})
+
+	t.Run("Batch Submission", func(t *testing.T) {
+		receipts := []models.Receipt{
+			{
+				Retailer:     "Walgreens",
+				PurchaseDate: "2022-01-02",
+				PurchaseTime: "08:13",
+				Items:        []models.Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+				Total:        "1.25",
+			},
+			{Retailer: "Walgreens"},
+		}
+		batchJSON, _ := json.Marshal(receipts)
+		resp, err := http.Post(fmt.Sprintf("%s/receipts/batch", server.URL), "application/json", bytes.NewBuffer(batchJSON))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to submit batch: %v", err)
+		}
+
+		var batchResponse models.BatchResponse
+		if err := json.NewDecoder(resp.Body).Decode(&batchResponse); err != nil {
+			t.Fatalf("Failed to decode batch response: %v", err)
+		}
+		resp.Body.Close()
+
+		if batchResponse.Succeeded != 1 || batchResponse.Failed != 1 {
+			t.Fatalf("Expected one success and one failure, got %+v", batchResponse)
+		}
+		resp, err = http.Get(fmt.Sprintf("%s/receipts/%s/points", server.URL, batchResponse.Results[0].ID))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get points of batch receipt: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("Asynchronous Submission", func(t *testing.T) {
+		receipt := models.Receipt{
+			Retailer:     "Walgreens",
+			PurchaseDate: "2022-01-03",
+			PurchaseTime: "08:13",
+			Items:        []models.Item{{ShortDescription: "Dasani", Price: "1.40"}},
+			Total:        "1.40",
+		}
+		receiptJSON, _ := json.Marshal(receipt)
+		resp, err := http.Post(fmt.Sprintf("%s/receipts/process?async=true", server.URL), "application/json", bytes.NewBuffer(receiptJSON))
+		if err != nil || resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Failed to queue receipt: %v", err)
+		}
+		location := resp.Header.Get("Location")
+		resp.Body.Close()
+
+		var job models.JobResponse
+		for deadline := time.Now().Add(5 * time.Second); job.Status != models.JobDone; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Job still %s after 5s", job.Status)
+			}
+			resp, err := http.Get(server.URL + location)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("Failed to get job: %v", err)
+			}
+			json.NewDecoder(resp.Body).Decode(&job)
+			resp.Body.Close()
+		}
+
+		resp, err = http.Get(fmt.Sprintf("%s/receipts/%s/points", server.URL, job.ReceiptID))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get points of queued receipt: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("Webhook Notification", func(t *testing.T) {
+		type delivery struct {
+			signature string
+			body      []byte
+		}
+		received := make(chan delivery, 1)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- delivery{signature: r.Header.Get(service.WebhookSignatureHeader), body: body}
+		}))
+		defer receiver.Close()
+
+		subscription, _ := json.Marshal(models.WebhookRequest{URL: receiver.URL, Secret: "integration"})
+		resp, err := http.Post(fmt.Sprintf("%s/webhooks", server.URL), "application/json", bytes.NewBuffer(subscription))
+		if err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Failed to create webhook: %v", err)
+		}
+		var webhook models.Webhook
+		json.NewDecoder(resp.Body).Decode(&webhook)
+		resp.Body.Close()
+
+		receiptJSON, _ := json.Marshal(models.Receipt{
+			Retailer:     "Walgreens",
+			PurchaseDate: "2022-01-04",
+			PurchaseTime: "08:13",
+			Items:        []models.Item{{ShortDescription: "Dasani", Price: "1.40"}},
+			Total:        "1.40",
+		})
+		resp, err = http.Post(fmt.Sprintf("%s/receipts/process", server.URL), "application/json", bytes.NewBuffer(receiptJSON))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to process receipt: %v", err)
+		}
+		var receiptResponse models.ReceiptResponse
+		json.NewDecoder(resp.Body).Decode(&receiptResponse)
+		resp.Body.Close()
+
+		var got delivery
+		select {
+		case got = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Webhook was not delivered")
+		}
+		var event models.ReceiptEvent
+		if err := json.Unmarshal(got.body, &event); err != nil || event.Data.ReceiptID != receiptResponse.ID {
+			t.Errorf("Expected an event for receipt %s, got %s", receiptResponse.ID, got.body)
+		}
+		var unix int64
+		fmt.Sscanf(got.signature, "t=%d,", &unix)
+		if want := service.SignWebhook("integration", time.Unix(unix, 0), got.body); got.signature != want {
+			t.Errorf("Signature %q doesn't match %q", got.signature, want)
+		}
+
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/webhooks/%s", server.URL, webhook.ID), nil)
+		if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Failed to delete webhook: %v", err)
+		}
 	})
 
 	t.Run("Get Points for Non-existent Receipt", func(t *testing.T) {